@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"a0/internal/app/xerror"
+)
+
+// archiveErrorStatus maps archive errors to http status codes
+func archiveErrorStatus(err error) int {
+	var notAllowed *xerror.ErrPathNotAllowed
+	var tooLarge *xerror.ErrUploadTooLarge
+	switch {
+	case errors.As(err, &notAllowed):
+		return http.StatusForbidden
+	case errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// UploadArchive writes the request body into the container.
+// Content-Type application/x-tar is extracted into ?path=, anything else is stored as ?path=/?name= file.
+func (h *ContainerHandler) UploadArchive(c echo.Context) error {
+	id := c.Param("id")
+	destDir := c.QueryParam("path")
+	req := c.Request()
+
+	maxSize := h.Service.MaxUploadSize()
+	if req.ContentLength > maxSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": (&xerror.ErrUploadTooLarge{}).Error()})
+	}
+	body := http.MaxBytesReader(c.Response(), req.Body, maxSize)
+
+	var err error
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), "application/x-tar") {
+		err = h.Service.UploadArchive(req.Context(), id, destDir, body)
+	} else {
+		if req.ContentLength < 0 {
+			return c.JSON(http.StatusLengthRequired, map[string]string{"error": "Content-Length is required"})
+		}
+		err = h.Service.UploadFile(req.Context(), id, destDir, c.QueryParam("name"), req.ContentLength, body)
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": (&xerror.ErrUploadTooLarge{}).Error()})
+		}
+		return c.JSON(archiveErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "uploaded"})
+}
+
+// TransferPathsHandler lists the paths uploads and downloads are allowed under, the first one is the default
+func (h *ContainerHandler) TransferPathsHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string][]string{"allowedPaths": h.Service.TransferPaths()})
+}
+
+// DownloadArchive streams ?path= of the container as tar.gz
+func (h *ContainerHandler) DownloadArchive(c echo.Context) error {
+	id := c.Param("id")
+	archive, stat, err := h.Service.DownloadArchive(c.Request().Context(), id, c.QueryParam("path"))
+	if err != nil {
+		return c.JSON(archiveErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	defer archive.Close()
+
+	name := path.Base(stat.Name)
+	if name == "" || name == "/" || name == "." {
+		name = "archive"
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/gzip")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name+".tar.gz"))
+	res.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(res, archive)
+	return nil
+}
@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"a0/internal/app/service"
+	"a0/internal/app/xerror"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
 )
 
 // Handler struct
@@ -23,12 +28,37 @@ func (h *ContainerHandler) CreateContainer(c echo.Context) error {
 
 	resp, err := h.Service.CreateContainer(req)
 	if err != nil {
+		var bindNotAllowed *xerror.ErrBindNotAllowed
+		var denied *xerror.ErrAdmissionDenied
+		switch {
+		case errors.As(err, &bindNotAllowed):
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		case errors.As(err, &denied):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]any{
+				"error":      err.Error(),
+				"violations": denied.Violations,
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
 	return c.JSON(http.StatusCreated, resp)
 }
 
+func (h *ContainerHandler) UpdateContainer(c echo.Context) error {
+	id := c.Param("id")
+	req := new(service.UpdateContainerRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	resp, err := h.Service.UpdateContainer(id, req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"status": "updated", "warnings": resp.Warnings})
+}
+
 func (h *ContainerHandler) StartContainer(c echo.Context) error {
 	id := c.Param("id")
 	if err := h.Service.StartContainer(id); err != nil {
@@ -72,7 +102,7 @@ func (h *ContainerHandler) ListContainers(c echo.Context) error {
 }
 
 func (h *ContainerHandler) ListCodeServerContainers(c echo.Context) error {
-	containers, err := h.Service.ListCodeServerContainersPrefix()
+	containers, err := h.Service.ListManagedContainers()
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
@@ -82,6 +112,9 @@ func (h *ContainerHandler) ListCodeServerContainers(c echo.Context) error {
 func (h *ContainerHandler) LogsContainer(c echo.Context) error {
 	id := c.Param("id")
 	tail := c.QueryParam("tail")
+	if c.QueryParam("follow") == "true" {
+		return h.followLogs(c, id, tail)
+	}
 	logs, err := h.Service.LogsContainer(id, tail)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
@@ -89,6 +122,43 @@ func (h *ContainerHandler) LogsContainer(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"logs": logs})
 }
 
+// followLogs streams logs as server-sent events, one "stdout" or "stderr" event per line
+func (h *ContainerHandler) followLogs(c echo.Context, id string, tail string) error {
+	if tail == "" {
+		tail = "100"
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	err := h.Service.FollowLogsContainer(c.Request().Context(), id, tail, func(line service.LogLine) error {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", line.Stream, data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	})
+	if err != nil {
+		data, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprintf(res, "event: error\ndata: %s\n\n", data)
+		res.Flush()
+		return nil
+	}
+
+	fmt.Fprint(res, "event: end\ndata: {}\n\n")
+	res.Flush()
+	return nil
+}
+
 func (h *ContainerHandler) GetContainerIDByName(c echo.Context) error {
 	name := c.Param("name")
 	id, err := h.Service.GetContainerIDByName(name)
@@ -99,7 +169,7 @@ func (h *ContainerHandler) GetContainerIDByName(c echo.Context) error {
 }
 
 func (h *ContainerHandler) GetConfigDefaultsHandler(c echo.Context) error {
-	resp, err := h.Service.GetConfigDefaults()
+	resp, err := h.Service.GetConfigDefaults(c.QueryParam("profile"))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": err.Error(),
@@ -109,6 +179,11 @@ func (h *ContainerHandler) GetConfigDefaultsHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, resp)
 }
 
+// ListProfilesHandler lists the container profiles of the agent
+func (h *ContainerHandler) ListProfilesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Service.ListProfiles())
+}
+
 func (h *ContainerHandler) IsContainerExistHandler(c echo.Context) error {
 	name := c.Param("name")
 	exist, err := h.Service.IsContainerExist(name)
@@ -136,6 +211,30 @@ func (h *ContainerHandler) IsContainerRunningHandler(c echo.Context) error {
 	})
 }
 
+// IsContainerReadyHandler checks if a container is running and its port (default 8443) answers
+func (h *ContainerHandler) IsContainerReadyHandler(c echo.Context) error {
+	name := c.Param("name")
+	port := 8443
+	if p := c.QueryParam("port"); p != "" {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 1 || v > 65535 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid port"})
+		}
+		port = v
+	}
+
+	ready, err := h.Service.IsContainerPortReady(name, port)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"name":  name,
+		"port":  port,
+		"ready": ready,
+	})
+}
+
 func (h *ContainerHandler) GetContainerStats(c echo.Context) error {
 	containerName := c.Param("name")
 	if containerName == "" {
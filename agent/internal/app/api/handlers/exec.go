@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
+
+	"a0/internal/app/service"
+)
+
+// execMessage is a client -> agent terminal message
+type execMessage struct {
+	Type string `json:"type"` // stdin | resize
+	Data string `json:"data,omitempty"`
+	Cols uint   `json:"cols,omitempty"`
+	Rows uint   `json:"rows,omitempty"`
+}
+
+type ExecHandler struct {
+	Service *service.ContainerService
+	log     zerolog.Logger
+}
+
+func NewExecHandler(s *service.ContainerService, log zerolog.Logger) *ExecHandler {
+	return &ExecHandler{Service: s, log: log}
+}
+
+// Exec bridges a docker exec TTY session over WebSocket.
+// Client sends JSON text frames ({"type":"stdin","data":"..."} or {"type":"resize","cols":80,"rows":24}),
+// agent sends raw terminal output as binary frames.
+func (h *ExecHandler) Exec(c echo.Context) error {
+	containerID := c.Param("id")
+	user := c.QueryParam("user")
+	requestedBy := c.Request().Header.Get("X-Exec-Requested-By")
+
+	server := websocket.Server{
+		// origin is checked by proxy-backend, the agent is only reachable with X-Agent-Key
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			h.bridge(ws, containerID, user, requestedBy)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func (h *ExecHandler) bridge(ws *websocket.Conn, containerID, user, requestedBy string) {
+	defer ws.Close()
+	ws.PayloadType = websocket.BinaryFrame
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hijacked, execID, err := h.Service.ExecAttach(ctx, containerID, nil, user)
+	if err != nil {
+		h.log.Error().Err(err).Msgf("exec: failed to attach to %s", containerID)
+		_ = websocket.Message.Send(ws, "error: "+err.Error()+"\r\n")
+		return
+	}
+	defer hijacked.Close()
+
+	started := time.Now()
+	h.log.Info().
+		Str("container", containerID).
+		Str("exec_id", execID).
+		Str("requested_by", requestedBy).
+		Msg("exec session started")
+
+	// container -> websocket
+	go func() {
+		defer cancel()
+		defer ws.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := hijacked.Reader.Read(buf)
+			if n > 0 {
+				if werr := websocket.Message.Send(ws, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// websocket -> container
+	for {
+		var raw []byte
+		if err := websocket.Message.Receive(ws, &raw); err != nil {
+			break
+		}
+		var msg execMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "stdin":
+			if _, err := hijacked.Conn.Write([]byte(msg.Data)); err != nil {
+				cancel()
+			}
+		case "resize":
+			if msg.Cols > 0 && msg.Rows > 0 {
+				if err := h.Service.ExecResize(ctx, execID, msg.Rows, msg.Cols); err != nil {
+					h.log.Warn().Err(err).Msgf("exec: resize failed for %s", execID)
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	_ = hijacked.CloseWrite()
+
+	h.log.Info().
+		Str("container", containerID).
+		Str("exec_id", execID).
+		Str("requested_by", requestedBy).
+		Dur("duration", time.Since(started)).
+		Msg("exec session ended")
+}
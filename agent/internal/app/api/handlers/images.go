@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"a0/internal/app/service"
+)
+
+type PullImageRequest struct {
+	Image string `json:"image"`
+	// Always pulls even if the image is present, to pick up a new image behind the same tag
+	Always bool `json:"always"`
+}
+
+// PullImage makes sure the image is present on the agent, pulling it if needed or always if asked.
+// With "Accept: text/event-stream" the pull progress is streamed as "progress" events,
+// followed by a "done" event with the result or an "error" event.
+func (h *ContainerHandler) PullImage(c echo.Context) error {
+	req := new(PullImageRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	imageName := strings.TrimSpace(req.Image)
+	if imageName == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "image is required"})
+	}
+
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		return h.streamPullImage(c, imageName, req.Always)
+	}
+
+	pulled, err := h.Service.EnsureImage(c.Request().Context(), imageName, req.Always, nil)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"image": imageName, "status": pullStatus(pulled)})
+}
+
+// ImageIDHandler returns the ID of the local image behind the "image" reference
+func (h *ContainerHandler) ImageIDHandler(c echo.Context) error {
+	imageName := strings.TrimSpace(c.QueryParam("image"))
+	if imageName == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "image is required"})
+	}
+	id, err := h.Service.ImageID(c.Request().Context(), imageName)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"image": imageName, "id": id})
+}
+
+func pullStatus(pulled bool) string {
+	if pulled {
+		return "pulled"
+	}
+	return "present"
+}
+
+func (h *ContainerHandler) streamPullImage(c echo.Context, imageName string, always bool) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	send := func(event string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	pulled, err := h.Service.EnsureImage(c.Request().Context(), imageName, always, func(p service.PullProgress) error {
+		return send("progress", p)
+	})
+	if err != nil {
+		send("error", map[string]string{"image": imageName, "error": err.Error()})
+		return nil
+	}
+	send("done", map[string]string{"image": imageName, "status": pullStatus(pulled)})
+	return nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"a0/internal/app/service"
+	"a0/internal/app/xerror"
+)
+
+// ContainerSpecHandler returns the create request to recreate the container on another agent
+func (h *ContainerHandler) ContainerSpecHandler(c echo.Context) error {
+	spec, err := h.Service.ContainerSpec(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, spec)
+}
+
+// ExportStateHandler streams the workspace home of the container as tar.gz
+func (h *ContainerHandler) ExportStateHandler(c echo.Context) error {
+	archive, err := h.Service.ExportState(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	defer archive.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/gzip")
+	res.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(res, archive)
+	return nil
+}
+
+// ImportStateHandler extracts a tar.gz made by ExportStateHandler into the workspace home of the container
+func (h *ContainerHandler) ImportStateHandler(c echo.Context) error {
+	if err := h.Service.ImportState(c.Request().Context(), c.Param("id"), c.Request().Body); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "imported"})
+}
+
+// RecreateContainerHandler replaces the container with one running a new image, keeping its settings and workspace
+func (h *ContainerHandler) RecreateContainerHandler(c echo.Context) error {
+	req := new(service.RecreateContainerRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	resp, err := h.Service.RecreateContainer(c.Request().Context(), c.Param("id"), req.Image)
+	if err != nil {
+		var bindNotAllowed *xerror.ErrBindNotAllowed
+		var denied *xerror.ErrAdmissionDenied
+		switch {
+		case errors.As(err, &bindNotAllowed):
+			return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+		case errors.As(err, &denied):
+			return c.JSON(http.StatusUnprocessableEntity, map[string]any{
+				"error":      err.Error(),
+				"violations": denied.Violations,
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -9,8 +9,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"a0/internal/app/inmemory"
+	"a0/internal/app/security"
 	"a0/internal/app/service"
 	"a0/internal/app/utils"
 	"a0/internal/app/xerror"
@@ -19,10 +21,22 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// ownerCacheTTL is how long the owner of a container addressed by X-Target-Container is remembered
+const ownerCacheTTL = time.Minute
+
+type cachedOwner struct {
+	owner string
+	at    time.Time
+}
+
 type ProxyHandler struct {
 	proxy         *httputil.ReverseProxy
 	proxyService  *service.ProxyService
-	inMemoryCache *inmemory.InMemoryCache
+	forwardPorts  *service.ForwardPortRegistry
+	containers    *service.ContainerService
+	owners        *sync.Map
+	agentKey      string
+	sessionCookie string
 	log           zerolog.Logger
 	Host          string
 	Port          int
@@ -33,18 +47,105 @@ type ProxyHandler struct {
 func NewProxyHandler(
 	proxy *httputil.ReverseProxy,
 	proxyService *service.ProxyService,
-	inmemoryCache *inmemory.InMemoryCache,
+	forwardPorts *service.ForwardPortRegistry,
+	containers *service.ContainerService,
+	agentKey string,
+	sessionCookie string,
 	log zerolog.Logger,
 	host string,
 	port int,
 	withUsername bool,
 	withTLS bool) *ProxyHandler {
-	return &ProxyHandler{proxy, proxyService, inmemoryCache, log, host, port, withUsername, withTLS}
+	return &ProxyHandler{proxy, proxyService, forwardPorts, containers, &sync.Map{}, agentKey, sessionCookie, log, host, port, withUsername, withTLS}
+}
+
+// containerOwner returns the owner of the container, cached for ownerCacheTTL
+func (h *ProxyHandler) containerOwner(name string) (string, error) {
+	if v, ok := h.owners.Load(name); ok {
+		if cached := v.(cachedOwner); time.Since(cached.at) < ownerCacheTTL {
+			return cached.owner, nil
+		}
+	}
+	owner, err := h.containers.ContainerOwner(name)
+	if err != nil {
+		return "", err
+	}
+	h.owners.Store(name, cachedOwner{owner, time.Now()})
+	return owner, nil
+}
+
+// targetContainer returns the container named by the X-Target-Container header proxy-backend sets for workspaces,
+// after checking it belongs to the user of the request. Empty means the user's default container.
+// Workspaces shared with the user are marked by X-Workspace-Grant, it is only trusted on authenticated
+// requests which also carry the agent key.
+func (h *ProxyHandler) targetContainer(c echo.Context) (string, error) {
+	name := c.Request().Header.Get("X-Target-Container")
+	if name == "" {
+		return "", nil
+	}
+	username, _ := c.Get("username").(string)
+	if username != "" && c.Request().Header.Get("X-Workspace-Grant") != "" {
+		if _, err := h.containerOwner(name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	if username == "" {
+		// /code-server/request/<username>/... carries a request assertion instead of a session, the container must belong to the user of the path
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(c.Request().URL.Path, "/code-server"), "/"), "/")
+		if len(parts) >= 2 && parts[0] == "request" {
+			username = strings.ToLower(parts[1])
+		}
+	}
+	owner, err := h.containerOwner(name)
+	if err != nil || username == "" || owner != username {
+		return "", fmt.Errorf("container %s does not belong to %s", name, username)
+	}
+	return name, nil
+}
+
+// checkRequestAssertion verifies the X-Request-Assertion proxy-backend signs for /code-server/request/<username>/<protocol>/<port>/...
+// traffic after checking the port is visible to the requester. The assertion must name the user and port of the path and
+// the container of X-Target-Container, other paths are not affected.
+func (h *ProxyHandler) checkRequestAssertion(c echo.Context) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(c.Request().URL.Path, "/code-server"), "/"), "/")
+	if len(parts) == 0 || parts[0] != "request" {
+		return nil
+	}
+	if len(parts) < 4 {
+		return fmt.Errorf("invalid request path")
+	}
+	assertion, err := security.VerifyRequestAssertion(h.agentKey, c.Request().Header.Get("X-Request-Assertion"))
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(parts[3])
+	if err != nil || assertion.Port != port {
+		return fmt.Errorf("request assertion is not valid for port %s", parts[3])
+	}
+	if assertion.User != strings.ToLower(parts[1]) {
+		return fmt.Errorf("request assertion is not valid for user %s", parts[1])
+	}
+	if assertion.Container != c.Request().Header.Get("X-Target-Container") {
+		return fmt.Errorf("request assertion is not valid for container %s", c.Request().Header.Get("X-Target-Container"))
+	}
+	return nil
 }
 
 func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 
+		if err := h.checkRequestAssertion(c); err != nil {
+			h.log.Warn().Err(err).Msg("rejected forwarded port request")
+			return c.String(http.StatusForbidden, "access denied")
+		}
+
+		target, err := h.targetContainer(c)
+		if err != nil {
+			h.log.Warn().Err(err).Msg("rejected workspace request")
+			return c.String(http.StatusForbidden, "access denied")
+		}
+
 		baseTransport := h.proxyService.BaseTransportInit(true)
 
 		rp := *h.proxy
@@ -83,6 +184,15 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 
 		rp.Director = func(req *http.Request) {
 
+			// the request is authenticated, the credentials of the user and of proxy-backend are not passed on
+			// to the container, an app on a forwarded port could replay them
+			req.Header.Del("X-Session-ID")
+			req.Header.Del("X-Agent-Key")
+			req.Header.Del("X-Request-Assertion")
+			if h.sessionCookie != "" {
+				withoutCookie(req, h.sessionCookie)
+			}
+
 			// remove /code-server prefix
 			if strings.HasPrefix(req.URL.Path, "/code-server/") {
 				req.URL.Path = strings.TrimPrefix(req.URL.Path, "/code-server")
@@ -95,14 +205,14 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 			if len(parts) == 2 && parts[0] == "update" && parts[1] == "check" {
 				username := c.Get("username").(string)
 				req.URL.Scheme = _scheme
-				req.URL.Host = h.setHost(username)
+				req.URL.Host = h.setHost(username, target)
 				req.URL.Path = "/update/check"
 				return
 			}
 			if len(parts) == 1 && parts[0] == "mint-key" {
 				username := c.Get("username").(string)
 				req.URL.Scheme = _scheme
-				req.URL.Host = h.setHost(username)
+				req.URL.Host = h.setHost(username, target)
 				req.URL.Path = "/mint-key"
 				return
 			}
@@ -129,7 +239,12 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 					req.Header.Set("X-Proxy-Error", err.Error())
 					return
 				}
-				if !h.inMemoryCache.IsValidPort(portInt) {
+				// only ports the user declared in proxy-backend are forwarded, with the declared protocol
+				containerName := target
+				if containerName == "" {
+					containerName = fmt.Sprintf("%s-%s", h.Host, username)
+				}
+				if !h.forwardPorts.IsForwarded(req.Context(), username, containerName, portInt, protocol) {
 					err := &xerror.ErrInvalidPortNumberCode2{}
 					req.URL.Path = ""
 					req.Body = io.NopCloser(strings.NewReader(err.Error()))
@@ -153,7 +268,7 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 				}
 
 				req.URL.Scheme = protocol
-				req.URL.Host = h.setHostForProxy(username, portStr)
+				req.URL.Host = h.setHostForProxy(username, target, portStr)
 				req.URL.Path = extraPath
 				req.Header.Set("X-Real-IP", c.RealIP())
 				req.Header.Set("X-Forwarded-For", req.RemoteAddr)
@@ -171,7 +286,7 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 				username := c.Get("username").(string)
 				targetURL := &url.URL{
 					Scheme: _scheme,
-					Host:   h.setHost(username),
+					Host:   h.setHost(username, target),
 				}
 				// h.log.Debug().Msgf("%s", targetURL.Host)
 				reqPath := "/" + strings.Join(parts[1:], "/")
@@ -186,11 +301,11 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 				if h.WithUsername {
 					if strings.HasPrefix(req.URL.Path, "/_static") || strings.HasPrefix(req.URL.Path, "/manifest.json") {
 						req.URL.Scheme = _scheme
-						req.URL.Host = h.setHost(username)
+						req.URL.Host = h.setHost(username, target)
 						return
 					}
 					targetURL.Scheme = _scheme
-					targetURL.Host = h.setHost(username)
+					targetURL.Host = h.setHost(username, target)
 					reqPath := "/" + strings.Join(parts[1:], "/")
 					//msg := h.requestToFmt(targetURL.Scheme, targetURL.Host, reqPath)
 					//h.log.Debug().Msgf("Request to: %s", msg)
@@ -210,14 +325,31 @@ func (h *ProxyHandler) EchoHandler() echo.HandlerFunc {
 	}
 }
 
-func (h *ProxyHandler) setHost(username string) string {
+// withoutCookie removes a cookie from the Cookie header of req
+func withoutCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+func (h *ProxyHandler) setHost(username, target string) string {
+	if target != "" {
+		return fmt.Sprintf("%s:%d", target, h.Port)
+	}
 	if h.WithUsername {
 		return fmt.Sprintf("%s-%s:%d", h.Host, username, h.Port)
 	}
 	return fmt.Sprintf("%s:%d", h.Host, h.Port)
 }
 
-func (h *ProxyHandler) setHostForProxy(username, port string) string {
+func (h *ProxyHandler) setHostForProxy(username, target, port string) string {
+	if target != "" {
+		return fmt.Sprintf("%s:%s", target, port)
+	}
 	if h.WithUsername {
 		return fmt.Sprintf("%s-%s:%s", h.Host, username, port)
 	}
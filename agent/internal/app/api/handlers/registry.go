@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"a0/internal/app/service"
+)
+
+// maxRegistryAuthBody bounds the credentials document accepted from proxy-backend
+const maxRegistryAuthBody = 1 << 20
+
+type RegistryHandler struct {
+	auth *service.RegistryAuthStore
+}
+
+func NewRegistryHandler(auth *service.RegistryAuthStore) *RegistryHandler {
+	return &RegistryHandler{auth}
+}
+
+// ListCredentials returns the registry hosts credentials are configured for, never the credentials
+func (h *RegistryHandler) ListCredentials(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{"registries": h.auth.Hosts()})
+}
+
+// ReplaceCredentials replaces all registry credentials without a restart.
+// Body: {"registries": {"registry.local:5000": {"username": "...", "password": "..."}}}
+func (h *RegistryHandler) ReplaceCredentials(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxRegistryAuthBody))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
+	}
+	var req struct {
+		Registries json.RawMessage `json:"registries"`
+	}
+	// errors are not passed on, they may quote the credentials
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Registries) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "body must be {\"registries\": {...}}"})
+	}
+
+	hosts, err := h.auth.Replace(req.Registries)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"registries": hosts})
+}
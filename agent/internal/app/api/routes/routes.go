@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -16,7 +17,6 @@ import (
 
 	"a0/internal/app/adapters"
 	"a0/internal/app/api/handlers"
-	"a0/internal/app/inmemory"
 	"a0/internal/app/security"
 	"a0/internal/app/service"
 	"a0/internal/app/utils"
@@ -37,6 +37,7 @@ func JWTAuthMiddleware(
 
 			requestPath := c.Request().URL.Path
 
+			// forwarded ports are authorized by proxy-backend, the proxy handler checks its request assertion
 			if strings.HasPrefix(requestPath, "/request") ||
 				strings.HasPrefix(requestPath, "/code-server/request") {
 				return next(c)
@@ -79,6 +80,7 @@ func agentKeyMiddleware(expectedKey string) echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			agentKey := c.Request().Header.Get("X-Agent-Key")
 
+			// forwarded ports carry a request assertion signed with the agent key instead
 			if strings.HasPrefix(c.Request().URL.Path, "/code-server/request") ||
 				strings.HasPrefix(c.Request().URL.Path, "/request") {
 				return next(c)
@@ -122,14 +124,26 @@ func RegisterRoutes(log zerolog.Logger, config *config.Config) (*echo.Echo, *xdi
 	}
 
 	// Agent
-	containerService := service.NewContainerService(cli, config, log)
+	portAllocator := service.NewPortAllocator(config, log)
+	registryAuth := service.NewRegistryAuthStore(config, log)
+	containerService := service.NewContainerService(cli, config, portAllocator, registryAuth, log)
+	if err := containerService.PrunePortReservations(); err != nil {
+		log.Warn().Err(err).Msg("failed to prune port reservations")
+	}
 	containerHandler := handlers.NewContainerHandler(containerService)
+	execHandler := handlers.NewExecHandler(containerService, log)
 	metricsService := service.NewMetricsService(log, config)
 	metricsHandler := handlers.NewMetricsHandler(metricsService)
 	restClient := adapters.NewRestyClientAdapter()
 	discoveryService := service.NewDiscoveryService(restClient, config, log)
 	agent := xdiscovery.NewAgent(config, discoveryService, time.Second*10, log)
+	eventForwarder := service.NewEventForwarder(containerService, discoveryService, log)
+	memoryWatcher := service.NewMemoryWatcher(containerService, discoveryService, log)
+	eventForwarder.OnEvent(memoryWatcher.HandleEvent)
+	eventForwarder.Start(context.Background(), time.Second*5)
+	memoryWatcher.Start(context.Background(), memoryWatcher.Interval())
 	agentHandler := handlers.NewAgentHandler(config)
+	registryHandler := handlers.NewRegistryHandler(registryAuth)
 
 	// Proxy Config
 
@@ -153,7 +167,7 @@ func RegisterRoutes(log zerolog.Logger, config *config.Config) (*echo.Echo, *xdi
 	}
 
 	// Proxy Common
-	inMemCache := inmemory.NewInMemoryCache()
+	forwardPorts := service.NewForwardPortRegistry(redisClient, log)
 	dummyProxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: "localhost"})
 	proxyService := service.NewProxyService(log)
 	jwtService := security.NewJWTService(
@@ -166,7 +180,10 @@ func RegisterRoutes(log zerolog.Logger, config *config.Config) (*echo.Echo, *xdi
 	proxyHandler := handlers.NewProxyHandler(
 		dummyProxy,
 		proxyService,
-		inMemCache,
+		forwardPorts,
+		containerService,
+		config.AgentMetadata.AgentKey,
+		config.Secrets.SessionCookie,
 		log,
 		"code-server",
 		8443,
@@ -180,18 +197,33 @@ func RegisterRoutes(log zerolog.Logger, config *config.Config) (*echo.Echo, *xdi
 	apiGroup.POST("/containers/:id/start", containerHandler.StartContainer)
 	apiGroup.POST("/containers/:id/stop", containerHandler.StopContainer)
 	apiGroup.POST("/containers/:id/restart", containerHandler.RestartContainer)
+	apiGroup.POST("/containers/:id/update", containerHandler.UpdateContainer)
 	apiGroup.DELETE("/containers/:id", containerHandler.RemoveContainer)
 	apiGroup.POST("/containers/:id", containerHandler.RemoveContainer)
 	apiGroup.GET("/containers", containerHandler.ListContainers)
 	apiGroup.GET("/containers/code-server", containerHandler.ListCodeServerContainers)
 	apiGroup.GET("/containers/:id/logs", containerHandler.LogsContainer)
+	apiGroup.GET("/containers/:id/exec", execHandler.Exec)
+	apiGroup.PUT("/containers/:id/archive", containerHandler.UploadArchive)
+	apiGroup.GET("/containers/:id/archive", containerHandler.DownloadArchive)
+	apiGroup.GET("/containers/:id/spec", containerHandler.ContainerSpecHandler)
+	apiGroup.POST("/containers/:id/recreate", containerHandler.RecreateContainerHandler)
+	apiGroup.GET("/containers/:id/state", containerHandler.ExportStateHandler)
+	apiGroup.PUT("/containers/:id/state", containerHandler.ImportStateHandler)
 	apiGroup.GET("/containers/:name/id", containerHandler.GetContainerIDByName)
 	apiGroup.GET("/containers/defaults", containerHandler.GetConfigDefaultsHandler)
+	apiGroup.GET("/containers/profiles", containerHandler.ListProfilesHandler)
+	apiGroup.GET("/containers/archive/paths", containerHandler.TransferPathsHandler)
 	apiGroup.GET("/containers/:name/exist", containerHandler.IsContainerExistHandler)
 	apiGroup.GET("/containers/:name/running", containerHandler.IsContainerRunningHandler)
+	apiGroup.GET("/containers/:name/ready", containerHandler.IsContainerReadyHandler)
 	apiGroup.GET("/containers/:name/stats", containerHandler.GetContainerStats)
+	apiGroup.POST("/images/pull", containerHandler.PullImage)
+	apiGroup.GET("/images/id", containerHandler.ImageIDHandler)
 	apiGroup.GET("/metrics", metricsHandler.Fetch)
 	apiGroup.GET("/tags", agentHandler.GetTags)
+	apiGroup.GET("/registry/credentials", registryHandler.ListCredentials)
+	apiGroup.PUT("/registry/credentials", registryHandler.ReplaceCredentials)
 
 	// /code-server
 	e.Any("/code-server/*",
@@ -0,0 +1,17 @@
+package constants
+
+// Labels stamped on every container created by the platform.
+const (
+	LabelManaged   = "csplatform.managed"
+	LabelOwner     = "csplatform.owner"
+	LabelInstance  = "csplatform.instance"
+	LabelProfile   = "csplatform.profile"
+	LabelCreatedBy = "csplatform.created-by"
+	LabelCreatedAt = "csplatform.created-at"
+	LabelWorkspace = "csplatform.workspace"
+	// LabelPortEnv holds the env values with "${port:name}" placeholders before they were resolved, as JSON
+	LabelPortEnv = "csplatform.port-env"
+)
+
+// LegacyContainerPrefix is the name prefix of containers created before ownership labels.
+const LegacyContainerPrefix = "code-server-"
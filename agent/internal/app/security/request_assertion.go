@@ -0,0 +1,50 @@
+package security
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// requestAssertionAudience keeps other tokens signed with the agent key from passing as assertions
+const requestAssertionAudience = "csplatform-request"
+
+// RequestAssertion is what proxy-backend signed for one request to a forwarded port: the user whose
+// container is the target, the container name and the port.
+type RequestAssertion struct {
+	User      string
+	Container string
+	Port      int
+}
+
+// VerifyRequestAssertion checks an assertion proxy-backend signed with the agent key and returns its claims.
+func VerifyRequestAssertion(agentKey, tokenString string) (*RequestAssertion, error) {
+	if tokenString == "" {
+		return nil, fmt.Errorf("missing request assertion")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(agentKey), nil
+	},
+		jwt.WithAudience(requestAssertionAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid request assertion")
+	}
+	user, _ := claims["sub"].(string)
+	container, _ := claims["ctr"].(string)
+	port, _ := claims["port"].(float64)
+	if user == "" || port <= 0 {
+		return nil, fmt.Errorf("invalid request assertion")
+	}
+	return &RequestAssertion{User: user, Container: container, Port: int(port)}, nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+
+	"a0/internal/app/xerror"
+)
+
+// Admission rule names, returned in violations so callers can tell which rule failed
+const (
+	AdmissionRuleImage    = "images"
+	AdmissionRuleRegistry = "registries"
+	AdmissionRuleCPU      = "max_cpus"
+	AdmissionRuleMemory   = "max_memory"
+	AdmissionRuleVolume   = "volume_prefixes"
+	AdmissionRulePort     = "host_port_ranges"
+	AdmissionRuleNetwork  = "networks"
+	AdmissionRuleSysctl   = "sysctls"
+)
+
+// admit evaluates the admission policy against the final container config and returns every violated rule
+func (s *ContainerService) admit(containerConfig *container.Config, hostConfig *container.HostConfig) error {
+	policy := s.config.Admission
+	violations := []xerror.AdmissionViolation{}
+	violate := func(rule, field, value, format string, args ...any) {
+		violations = append(violations, xerror.AdmissionViolation{
+			Rule:    rule,
+			Field:   field,
+			Value:   value,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// image, patterns must match the whole reference
+	image := containerConfig.Image
+	if len(policy.Images) > 0 {
+		matched := false
+		for _, expr := range policy.Images {
+			re, err := regexp.Compile(`^(?:` + expr + `)$`)
+			if err != nil {
+				violate(AdmissionRuleImage, "image", image, "invalid image pattern %q in policy", expr)
+				continue
+			}
+			if re.MatchString(image) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			violate(AdmissionRuleImage, "image", image, "image %s is not allowed", image)
+		}
+	}
+
+	// registry
+	if len(policy.Registries) > 0 {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			violate(AdmissionRuleRegistry, "image", image, "invalid image reference: %v", err)
+		} else if domain := reference.Domain(named); !containsFold(policy.Registries, domain) {
+			violate(AdmissionRuleRegistry, "image", image, "registry %s is not allowed", domain)
+		}
+	}
+
+	// cpus
+	if policy.MaxCpus > 0 {
+		maxNano := int64(policy.MaxCpus) * 1_000_000_000
+		if nano := hostConfig.Resources.NanoCPUs; nano == 0 || nano > maxNano {
+			violate(AdmissionRuleCPU, "cpuQuota", strconv.FormatInt(nano/1_000_000_000, 10),
+				"cpu quota must be between 1 and %d", policy.MaxCpus)
+		}
+	}
+
+	// memory
+	if policy.MaxMemory != "" {
+		maxMem, err := s.parseMemoryLimitWithSanityCheck(policy.MaxMemory)
+		switch {
+		case err != nil:
+			violate(AdmissionRuleMemory, "memory", formatMemory(hostConfig.Resources.Memory), "invalid max_memory in policy")
+		case hostConfig.Resources.Memory == 0 || hostConfig.Resources.Memory > maxMem:
+			violate(AdmissionRuleMemory, "memory", formatMemory(hostConfig.Resources.Memory),
+				"memory must be at most %s", formatMemory(maxMem))
+		}
+	}
+
+	// volumes, named volumes are not host paths
+	if len(policy.VolumePrefixes) > 0 {
+		for _, bind := range hostConfig.Binds {
+			src, _, _ := strings.Cut(bind, ":")
+			if !strings.HasPrefix(src, "/") {
+				continue
+			}
+			clean := path.Clean(src)
+			allowed := false
+			for _, prefix := range policy.VolumePrefixes {
+				prefix = path.Clean(prefix)
+				if clean == prefix || strings.HasPrefix(clean, strings.TrimSuffix(prefix, "/")+"/") {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				violate(AdmissionRuleVolume, "volumes", bind, "host path %s is not under an allowed prefix", src)
+			}
+		}
+	}
+
+	// host ports, an empty host port lets docker pick an ephemeral one
+	if len(policy.HostPortRanges) > 0 {
+		ports := []string{}
+		for containerPort, bindings := range hostConfig.PortBindings {
+			for _, b := range bindings {
+				if b.HostPort != "" {
+					ports = append(ports, fmt.Sprintf("%s:%s", b.HostPort, containerPort.Port()))
+				}
+			}
+		}
+		sort.Strings(ports)
+		for _, p := range ports {
+			hostPort, _, _ := strings.Cut(p, ":")
+			if !portInRanges(hostPort, policy.HostPortRanges) {
+				violate(AdmissionRulePort, "ports", p, "host port %s is not in %s", hostPort, strings.Join(policy.HostPortRanges, ", "))
+			}
+		}
+	}
+
+	// network
+	if len(policy.Networks) > 0 {
+		network := string(hostConfig.NetworkMode)
+		if network == "" {
+			network = "default"
+		}
+		if !containsFold(policy.Networks, network) {
+			violate(AdmissionRuleNetwork, "network", network, "network %s is not allowed", network)
+		}
+	}
+
+	// sysctls
+	if len(policy.Sysctls) > 0 {
+		keys := make([]string, 0, len(hostConfig.Sysctls))
+		for k := range hostConfig.Sysctls {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !sysctlAllowed(k, policy.Sysctls) {
+				violate(AdmissionRuleSysctl, "sysctls", k, "sysctl %s is not allowed", k)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &xerror.ErrAdmissionDenied{Violations: violations}
+	}
+	return nil
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// portInRanges checks port against "from-to" or single port entries
+func portInRanges(port string, ranges []string) bool {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		from, to, isRange := strings.Cut(strings.TrimSpace(r), "-")
+		if !isRange {
+			to = from
+		}
+		lo, err1 := strconv.Atoi(strings.TrimSpace(from))
+		hi, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 == nil && err2 == nil && p >= lo && p <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// sysctlAllowed matches key against exact keys or "prefix.*" patterns
+func sysctlAllowed(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
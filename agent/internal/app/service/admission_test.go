@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+
+	"a0/internal/app/xerror"
+	"a0/internal/config"
+)
+
+func TestAdmitImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		image    string
+		allowed  bool
+	}{
+		{name: "exact match", patterns: []string{"csplatform-env/py:latest"}, image: "csplatform-env/py:latest", allowed: true},
+		{name: "pattern match", patterns: []string{"csplatform-env/[a-z]+:.*"}, image: "csplatform-env/py:3.12", allowed: true},
+		{name: "already anchored", patterns: []string{"^csplatform-env/.*$"}, image: "csplatform-env/py:latest", allowed: true},
+		{name: "second pattern", patterns: []string{"nginx:.*", "csplatform-env/.*"}, image: "csplatform-env/py:latest", allowed: true},
+		{name: "prefix is not enough", patterns: []string{"csplatform-env/py"}, image: "csplatform-env/py-evil:latest", allowed: false},
+		{name: "suffix is not enough", patterns: []string{"py:latest"}, image: "evil/py:latest", allowed: false},
+		{name: "substring is not enough", patterns: []string{"csplatform-env"}, image: "evil.io/csplatform-env/py:latest", allowed: false},
+		{name: "alternation stays anchored", patterns: []string{"a/x:1|b/y:1"}, image: "a/x:1-evil", allowed: false},
+		{name: "invalid pattern", patterns: []string{"("}, image: "csplatform-env/py:latest", allowed: false},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{}
+		cfg.Admission.Images = tt.patterns
+		s := &ContainerService{config: cfg}
+
+		err := s.admit(&container.Config{Image: tt.image}, &container.HostConfig{})
+		if tt.allowed != (err == nil) {
+			t.Errorf("%s: admit(%s) with %v = %v, want allowed %v", tt.name, tt.image, tt.patterns, err, tt.allowed)
+		}
+	}
+}
+
+func TestAdmitViolations(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Admission.Registries = []string{"docker.io"}
+	cfg.Admission.MaxCpus = 4
+	cfg.Admission.MaxMemory = "8g"
+	cfg.Admission.VolumePrefixes = []string{"/mnt/nfs/home/"}
+	cfg.Admission.HostPortRanges = []string{"40000-49999", "8080"}
+	cfg.Admission.Networks = []string{"codeserver_net"}
+	cfg.Admission.Sysctls = []string{"net.ipv4.*", "kernel.shmmax"}
+	s := &ContainerService{config: cfg}
+
+	allowedHost := func() *container.HostConfig {
+		return &container.HostConfig{
+			Resources:   container.Resources{NanoCPUs: 2_000_000_000, Memory: 4 * 1024 * 1024 * 1024},
+			Binds:       []string{"/mnt/nfs/home/alice:/config", "alice-cache:/cache"},
+			NetworkMode: "codeserver_net",
+			PortBindings: nat.PortMap{
+				"8443/tcp": {{HostPort: "40001"}},
+				"80/tcp":   {{HostPort: ""}},
+				"8080/tcp": {{HostPort: "8080"}},
+			},
+			Sysctls: map[string]string{"net.ipv4.tcp_keepalive_time": "60", "kernel.shmmax": "1"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		image  string
+		change func(*container.HostConfig)
+		rules  []string
+	}{
+		{name: "allowed", image: "csplatform-env/py:latest", change: func(*container.HostConfig) {}},
+		{name: "registry", image: "ghcr.io/evil/py:latest", change: func(*container.HostConfig) {}, rules: []string{AdmissionRuleRegistry}},
+		{name: "no cpu limit", change: func(h *container.HostConfig) { h.Resources.NanoCPUs = 0 }, rules: []string{AdmissionRuleCPU}},
+		{name: "too many cpus", change: func(h *container.HostConfig) { h.Resources.NanoCPUs = 5_000_000_000 }, rules: []string{AdmissionRuleCPU}},
+		{name: "too much memory", change: func(h *container.HostConfig) { h.Resources.Memory = 9 * 1024 * 1024 * 1024 }, rules: []string{AdmissionRuleMemory}},
+		{name: "volume outside prefix", change: func(h *container.HostConfig) { h.Binds = append(h.Binds, "/mnt/nfs/homework:/x") }, rules: []string{AdmissionRuleVolume}},
+		{name: "volume escaping prefix", change: func(h *container.HostConfig) { h.Binds = []string{"/mnt/nfs/home/../etc:/x"} }, rules: []string{AdmissionRuleVolume}},
+		{name: "host port", change: func(h *container.HostConfig) { h.PortBindings["22/tcp"] = []nat.PortBinding{{HostPort: "2222"}} }, rules: []string{AdmissionRulePort}},
+		{name: "default network", change: func(h *container.HostConfig) { h.NetworkMode = "" }, rules: []string{AdmissionRuleNetwork}},
+		{name: "sysctl", change: func(h *container.HostConfig) { h.Sysctls["kernel.shmall"] = "1" }, rules: []string{AdmissionRuleSysctl}},
+		{
+			name:  "every violation is reported",
+			image: "ghcr.io/evil/py:latest",
+			change: func(h *container.HostConfig) {
+				h.Resources.NanoCPUs = 0
+				h.NetworkMode = "host"
+			},
+			rules: []string{AdmissionRuleRegistry, AdmissionRuleCPU, AdmissionRuleNetwork},
+		},
+	}
+	for _, tt := range tests {
+		image := tt.image
+		if image == "" {
+			image = "csplatform-env/py:latest"
+		}
+		hostConfig := allowedHost()
+		tt.change(hostConfig)
+
+		err := s.admit(&container.Config{Image: image}, hostConfig)
+		if len(tt.rules) == 0 {
+			if err != nil {
+				t.Errorf("%s: admit = %v, want allowed", tt.name, err)
+			}
+			continue
+		}
+		var denied *xerror.ErrAdmissionDenied
+		if !errors.As(err, &denied) {
+			t.Errorf("%s: admit = %v, want ErrAdmissionDenied", tt.name, err)
+			continue
+		}
+		rules := []string{}
+		for _, v := range denied.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if !slices.Equal(rules, tt.rules) {
+			t.Errorf("%s: violated rules = %v, want %v", tt.name, rules, tt.rules)
+		}
+	}
+}
+
+func TestSysctlAllowed(t *testing.T) {
+	patterns := []string{"net.ipv4.*", " kernel.shmmax "}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "net.ipv4.tcp_keepalive_time", want: true},
+		{key: "kernel.shmmax", want: true},
+		{key: "kernel.shmall", want: false},
+		{key: "net.ipv6.conf.all.disable_ipv6", want: false},
+		{key: "net.ipv4", want: false},
+	}
+	for _, tt := range tests {
+		if got := sysctlAllowed(tt.key, patterns); got != tt.want {
+			t.Errorf("sysctlAllowed(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"a0/internal/app/xerror"
+)
+
+const defaultMaxUploadSize int64 = 512 * 1024 * 1024
+
+var defaultTransferPaths = []string{"/config"}
+
+// MaxUploadSize returns the configured upload limit in bytes
+func (s *ContainerService) MaxUploadSize() int64 {
+	size, err := s.parseMemoryLimit(s.config.FileTransfer.MaxUploadSize)
+	if err != nil || size <= 0 {
+		return defaultMaxUploadSize
+	}
+	return size
+}
+
+// TransferPaths returns the cleaned file transfer allow list, the root path is never allowed
+func (s *ContainerService) TransferPaths() []string {
+	allowed := s.config.FileTransfer.AllowedPaths
+	if len(allowed) == 0 {
+		allowed = defaultTransferPaths
+	}
+	paths := make([]string, 0, len(allowed))
+	for _, prefix := range allowed {
+		prefix = path.Clean(prefix)
+		if prefix == "/" || !strings.HasPrefix(prefix, "/") {
+			continue
+		}
+		paths = append(paths, prefix)
+	}
+	return paths
+}
+
+// transferPath cleans p and checks it is equal to or under one of the allowed paths
+func (s *ContainerService) transferPath(p string) (string, error) {
+	if !strings.HasPrefix(p, "/") {
+		return "", &xerror.ErrPathNotAllowed{}
+	}
+	clean := path.Clean(p)
+
+	for _, prefix := range s.TransferPaths() {
+		if clean == prefix || strings.HasPrefix(clean, prefix+"/") {
+			return clean, nil
+		}
+	}
+	return "", &xerror.ErrPathNotAllowed{}
+}
+
+// containerOwner returns PUID/PGID of the container env so uploaded files belong to the code-server user
+func (s *ContainerService) containerOwner(ctx context.Context, containerID string) (int, int) {
+	inspect, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.Config == nil {
+		return 0, 0
+	}
+	var uid, gid int
+	for _, kv := range inspect.Config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "PUID":
+			uid, _ = strconv.Atoi(v)
+		case "PGID":
+			gid, _ = strconv.Atoi(v)
+		}
+	}
+	return uid, gid
+}
+
+// UploadFile writes a single file of the given size from r into destDir of the container
+func (s *ContainerService) UploadFile(ctx context.Context, containerID, destDir, fileName string, size int64, r io.Reader) error {
+	dir, err := s.transferPath(destDir)
+	if err != nil {
+		return err
+	}
+	if fileName == "" || fileName != path.Base(fileName) || fileName == "." || fileName == ".." {
+		return fmt.Errorf("invalid file name: %q", fileName)
+	}
+	if size < 0 || size > s.MaxUploadSize() {
+		return &xerror.ErrUploadTooLarge{}
+	}
+
+	uid, gid := s.containerOwner(ctx, containerID)
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Name:    fileName,
+			Mode:    0o644,
+			Size:    size,
+			Uid:     uid,
+			Gid:     gid,
+			ModTime: time.Now(),
+		})
+		if err == nil {
+			_, err = io.CopyN(tw, r, size)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return s.cli.CopyToContainer(ctx, containerID, dir, pr, container.CopyToContainerOptions{})
+}
+
+// UploadArchive extracts a tar stream into destDir of the container, at most MaxUploadSize bytes are read
+func (s *ContainerService) UploadArchive(ctx context.Context, containerID, destDir string, r io.Reader) error {
+	dir, err := s.transferPath(destDir)
+	if err != nil {
+		return err
+	}
+	limited := &limitedReader{r: r, remaining: s.MaxUploadSize()}
+	return s.cli.CopyToContainer(ctx, containerID, dir, limited, container.CopyToContainerOptions{})
+}
+
+// DownloadArchive returns srcPath of the container as a gzip compressed tar stream
+func (s *ContainerService) DownloadArchive(ctx context.Context, containerID, srcPath string) (io.ReadCloser, container.PathStat, error) {
+	src, err := s.transferPath(srcPath)
+	if err != nil {
+		return nil, container.PathStat{}, err
+	}
+
+	content, stat, err := s.cli.CopyFromContainer(ctx, containerID, src)
+	if err != nil {
+		return nil, container.PathStat{}, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer content.Close()
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, content)
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, stat, nil
+}
+
+// limitedReader fails with ErrUploadTooLarge instead of silently truncating
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// allow a clean EOF exactly at the limit
+		var one [1]byte
+		if n, _ := l.r.Read(one[:]); n > 0 {
+			return 0, &xerror.ErrUploadTooLarge{}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
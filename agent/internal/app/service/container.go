@@ -1,22 +1,26 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"math"
-	"os"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"encoding/json"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/rs/zerolog"
 
+	"a0/internal/app/constants"
 	"a0/internal/config"
 )
 
@@ -40,9 +44,47 @@ type CreateContainerRequest struct {
 	Network    string            `json:"network,omitempty"`
 	Restart    string            `json:"restart,omitempty"`
 	ExtraHosts []string          `json:"extra_hosts,omitempty"`
+	Profile    string            `json:"profile,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	CreatedBy  string            `json:"createdBy,omitempty"`
+	Workspace  string            `json:"workspace,omitempty"`
+
+	PidsLimit         int64             `json:"pidsLimit,omitempty"`   // -1 for unlimited
+	Ulimits           map[string]string `json:"ulimits,omitempty"`     // name: "soft[:hard]"
+	ShmSize           string            `json:"shmSize,omitempty"`
+	MemorySwap        string            `json:"memorySwap,omitempty"` // "-1" for unlimited swap
+	MemoryReservation string            `json:"memoryReservation,omitempty"`
+	CPUShares         int64             `json:"cpuShares,omitempty"`
+	CpusetCpus        string            `json:"cpusetCpus,omitempty"`
+	StorageSize       string            `json:"storageSize,omitempty"`
+	Tmpfs             []string          `json:"tmpfs,omitempty"` // "path[:options]"
+}
+
+// CreateContainerResponse is the docker create response with the host ports reserved for the container
+type CreateContainerResponse struct {
+	container.CreateResponse
+	Ports map[string]int `json:"ports,omitempty"`
+}
+
+// ManagedContainer is a platform container with its ownership labels resolved
+type ManagedContainer struct {
+	container.Summary
+	Owner     string `json:"owner"`
+	Profile   string `json:"profile,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
+	Legacy    bool   `json:"legacy,omitempty"` // created before ownership labels, owner comes from the name
+}
+
+// UpdateContainerRequest changes resources of an existing container, empty fields are left unchanged
+type UpdateContainerRequest struct {
+	CPUQuota   int64  `json:"cpuQuota,omitempty"`
+	Memory     string `json:"memory,omitempty"`
+	MemorySwap string `json:"memorySwap,omitempty"` // "-1" for unlimited swap
+	Restart    string `json:"restart,omitempty"`
 }
 
 type ConfigDefaultsResponse struct {
+	Profile    string            `json:"profile"`
 	Image      string            `json:"image"`
 	Name       string            `json:"name,omitempty"`
 	Env        map[string]string `json:"env,omitempty"`
@@ -55,16 +97,40 @@ type ConfigDefaultsResponse struct {
 	Network    string            `json:"network,omitempty"`
 	Restart    string            `json:"restart,omitempty"`
 	ExtraHosts []string          `json:"extra_hosts,omitempty"`
+
+	PidsLimit         int64             `json:"pidsLimit,omitempty"`
+	Ulimits           map[string]string `json:"ulimits,omitempty"`
+	ShmSize           string            `json:"shmSize,omitempty"`
+	MemorySwap        string            `json:"memorySwap,omitempty"`
+	MemoryReservation string            `json:"memoryReservation,omitempty"`
+	CPUShares         int64             `json:"cpuShares,omitempty"`
+	CpusetCpus        string            `json:"cpusetCpus,omitempty"`
+	StorageSize       string            `json:"storageSize,omitempty"`
+	Tmpfs             []string          `json:"tmpfs,omitempty"`
+}
+
+// ProfileSummary describes a container profile for selection in the create form
+type ProfileSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image"`
+	Cpus        int    `json:"cpus,omitempty"`
+	Memory      string `json:"memory,omitempty"`
+	Default     bool   `json:"default"`
+	// ForwardPorts are the ports users can declare for forwarding, "8501" or "8500-8599"
+	ForwardPorts []string `json:"forwardPorts,omitempty"`
 }
 
 type ContainerService struct {
 	cli    *client.Client
 	config *config.Config
+	ports  *PortAllocator
+	auth   *RegistryAuthStore
 	log    zerolog.Logger
 }
 
-func NewContainerService(cli *client.Client, config *config.Config, log zerolog.Logger) *ContainerService {
-	return &ContainerService{cli, config, log}
+func NewContainerService(cli *client.Client, config *config.Config, ports *PortAllocator, auth *RegistryAuthStore, log zerolog.Logger) *ContainerService {
+	return &ContainerService{cli, config, ports, auth, log}
 }
 
 func RemoveDuplicateEnv(envs []string) []string {
@@ -351,47 +417,38 @@ func (s *ContainerService) parseRestartPolicy(restart string) container.RestartP
 
 /********/
 
-func (s *ContainerService) PullImage(cli *client.Client, ctx context.Context, imageName string) error {
-	out, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-	io.Copy(os.Stdout, out)
-	return nil
-}
-
-func (s *ContainerService) buildContainerConfig() *container.Config {
+func (s *ContainerService) buildContainerConfig(tpl *config.ContainerTemplate) *container.Config {
 	containerConfig := &container.Config{}
 
 	// expose
-	if len(s.config.ContainerTemplate.Expose) > 0 {
-		containerConfig.ExposedPorts = s.buildExposedPorts(s.config.ContainerTemplate.Expose)
+	if len(tpl.Expose) > 0 {
+		containerConfig.ExposedPorts = s.buildExposedPorts(tpl.Expose)
 	}
 
 	// env
-	if len(s.config.ContainerTemplate.Environment) > 0 {
-		containerConfig.Env = RemoveDuplicateEnv(normalizeEnv(s.config.ContainerTemplate.Environment))
+	if len(tpl.Environment) > 0 {
+		containerConfig.Env = RemoveDuplicateEnv(normalizeEnv(tpl.Environment))
 	}
 
 	// image_name
-	if s.config.ContainerTemplate.ImageName != "" {
-		containerConfig.Image = s.config.ContainerTemplate.ImageName
+	if tpl.ImageName != "" {
+		containerConfig.Image = tpl.ImageName
 	}
 
 	return containerConfig
 }
 
-func (s *ContainerService) buildHostConfig(containerConfig *container.Config) *container.HostConfig {
+func (s *ContainerService) buildHostConfig(tpl *config.ContainerTemplate, containerConfig *container.Config) (*container.HostConfig, error) {
 	hostConfig := &container.HostConfig{}
 
 	// set sysctls
-	if len(s.config.ContainerTemplate.Sysctls) > 0 {
-		hostConfig.Sysctls = flattenSysctls(s.config.ContainerTemplate.Sysctls)
+	if len(tpl.Sysctls) > 0 {
+		hostConfig.Sysctls = flattenSysctls(tpl.Sysctls)
 	}
 
-	if len(s.config.ContainerTemplate.Ports) > 0 {
-		if bindings, err := s.buildPortBindings(s.config.ContainerTemplate.Ports); err == nil {
+	// ports with "${port:name}" placeholders are bound on create, once host ports are reserved
+	if fixedPorts, _ := splitPortPlaceholders(tpl.Ports); len(fixedPorts) > 0 {
+		if bindings, err := s.buildPortBindings(fixedPorts); err == nil {
 			hostConfig.PortBindings = bindings
 
 			if containerConfig.ExposedPorts == nil {
@@ -404,11 +461,11 @@ func (s *ContainerService) buildHostConfig(containerConfig *container.Config) *c
 	}
 
 	// set restart policy
-	hostConfig.RestartPolicy = s.parseRestartPolicy(s.config.ContainerTemplate.Restart)
+	hostConfig.RestartPolicy = s.parseRestartPolicy(tpl.Restart)
 
 	// set extra hosts
 	filteredHosts := []string{}
-	for _, h := range s.config.ContainerTemplate.ExtraHost {
+	for _, h := range tpl.ExtraHost {
 		h = strings.TrimSpace(h)
 		if h != "" {
 			filteredHosts = append(filteredHosts, h)
@@ -417,21 +474,21 @@ func (s *ContainerService) buildHostConfig(containerConfig *container.Config) *c
 	hostConfig.ExtraHosts = filteredHosts
 
 	// set cpus
-	if s.config.ContainerTemplate.Cpus > 0 {
-		hostConfig.Resources.NanoCPUs = int64(s.config.ContainerTemplate.Cpus) * 1_000_000_000
+	if tpl.Cpus > 0 {
+		hostConfig.Resources.NanoCPUs = int64(tpl.Cpus) * 1_000_000_000
 	} else {
 		hostConfig.Resources.NanoCPUs = 1_000_000_000
 	}
 
 	// set mem_limit
-	memBytes, err := s.parseMemoryLimit(s.config.ContainerTemplate.MemLimit)
+	memBytes, err := s.parseMemoryLimit(tpl.MemLimit)
 	if err != nil || memBytes == 0 {
 		memBytes = 1 * 1024 * 1024 * 1024
 	}
 	hostConfig.Resources.Memory = memBytes
 
 	// set network
-	for netName := range s.config.ContainerTemplate.Networks {
+	for netName := range tpl.Networks {
 		if netName != "" {
 			hostConfig.NetworkMode = container.NetworkMode(netName)
 			break
@@ -440,7 +497,7 @@ func (s *ContainerService) buildHostConfig(containerConfig *container.Config) *c
 
 	// set bind volumes
 	filteredVolumes := []string{}
-	for _, v := range s.config.ContainerTemplate.Volumes {
+	for _, v := range tpl.Volumes {
 		v = strings.TrimSpace(v)
 		if v != "" {
 			filteredVolumes = append(filteredVolumes, v)
@@ -448,17 +505,32 @@ func (s *ContainerService) buildHostConfig(containerConfig *container.Config) *c
 	}
 	hostConfig.Binds = filteredVolumes
 
-	return hostConfig
+	// set pids_limit, ulimits, shm_size, swap, cpu shares, storage and tmpfs
+	s.applyTemplateResources(tpl, hostConfig)
+
+	// set security profile
+	if err := s.applySecurityProfile(&tpl.Security, hostConfig); err != nil {
+		return nil, fmt.Errorf("security profile: %w", err)
+	}
+
+	return hostConfig, nil
 }
 
-func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*container.CreateResponse, error) {
+func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*CreateContainerResponse, error) {
 
 	// DEFAULTS
 	// ***************
 	ctx := context.Background()
-	containerName := s.config.ContainerTemplate.ContainerName
-	defaultContainerConfig := s.buildContainerConfig()
-	defaultHostConfig := s.buildHostConfig(defaultContainerConfig)
+	profileName, tpl, err := s.config.Template(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	containerName := tpl.ContainerName
+	defaultContainerConfig := s.buildContainerConfig(tpl)
+	defaultHostConfig, err := s.buildHostConfig(tpl, defaultContainerConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	// OVERRIDE
 	// ***************
@@ -488,8 +560,11 @@ func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*contai
 	}
 
 	// set ports
+	_, dynamicPorts := splitPortPlaceholders(tpl.Ports)
 	if len(req.Ports) > 0 {
-		if bindings, err := s.buildPortBindings(req.Ports); err == nil {
+		var fixedPorts []string
+		fixedPorts, dynamicPorts = splitPortPlaceholders(req.Ports)
+		if bindings, err := s.buildPortBindings(fixedPorts); err == nil {
 			defaultHostConfig.PortBindings = bindings
 
 			if defaultContainerConfig.ExposedPorts == nil {
@@ -514,6 +589,7 @@ func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*contai
 	}
 
 	// set mem_limit
+	templateMemory := defaultHostConfig.Resources.Memory
 	if req.Memory != "" {
 		memBytes, err := s.parseMemoryLimit(req.Memory)
 		if err != nil || memBytes == 0 {
@@ -542,6 +618,9 @@ func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*contai
 				filtered = append(filtered, v)
 			}
 		}
+		if err := s.checkBinds(filtered); err != nil {
+			return nil, err
+		}
 		defaultHostConfig.Binds = removeDuplicates(append(defaultHostConfig.Binds, filtered...))
 	}
 
@@ -555,6 +634,92 @@ func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*contai
 		defaultHostConfig.NetworkMode = container.NetworkMode(req.Network)
 	}
 
+	// set pids_limit, ulimits, shm_size, swap, cpu shares, storage and tmpfs
+	if err := s.applyRequestResources(req, defaultHostConfig, templateMemory); err != nil {
+		return nil, err
+	}
+
+	// set dynamic ports, "${port:name}" in ports and env get a reserved host port
+	var assignedPorts map[string]int
+	portEnv := map[string]string{}
+	newReservation := false
+	if names := portPlaceholderNames(dynamicPorts, defaultContainerConfig.Env); len(names) > 0 {
+		newReservation = s.ports.Assigned(containerName) == nil
+		assignedPorts, err = s.reservePorts(containerName, names)
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range defaultContainerConfig.Env {
+			if k, v, ok := strings.Cut(e, "="); ok && hasPortPlaceholder(v) {
+				portEnv[k] = v
+			}
+			defaultContainerConfig.Env[i] = resolvePortPlaceholders(e, assignedPorts)
+		}
+		resolved := []string{}
+		for _, p := range dynamicPorts {
+			resolved = append(resolved, resolvePortPlaceholders(p, assignedPorts))
+		}
+		bindings, err := s.buildPortBindings(resolved)
+		if err != nil {
+			if newReservation {
+				s.ports.Release(containerName)
+			}
+			return nil, err
+		}
+		if defaultHostConfig.PortBindings == nil {
+			defaultHostConfig.PortBindings = nat.PortMap{}
+		}
+		if defaultContainerConfig.ExposedPorts == nil {
+			defaultContainerConfig.ExposedPorts = nat.PortSet{}
+		}
+		for port, binding := range bindings {
+			defaultHostConfig.PortBindings[port] = binding
+			defaultContainerConfig.ExposedPorts[port] = struct{}{}
+		}
+	}
+	releasePorts := func() {
+		if newReservation {
+			s.ports.Release(containerName)
+		}
+	}
+
+	// set labels
+	owner := req.Owner
+	if owner == "" {
+		owner = strings.TrimPrefix(containerName, constants.LegacyContainerPrefix)
+	}
+	createdBy := req.CreatedBy
+	if createdBy == "" {
+		createdBy = owner
+	}
+	if defaultContainerConfig.Labels == nil {
+		defaultContainerConfig.Labels = map[string]string{}
+	}
+	defaultContainerConfig.Labels[constants.LabelManaged] = "true"
+	defaultContainerConfig.Labels[constants.LabelOwner] = owner
+	defaultContainerConfig.Labels[constants.LabelInstance] = s.config.AgentMetadata.InstanceID
+	defaultContainerConfig.Labels[constants.LabelProfile] = profileName
+	defaultContainerConfig.Labels[constants.LabelCreatedBy] = createdBy
+	defaultContainerConfig.Labels[constants.LabelCreatedAt] = time.Now().UTC().Format(time.RFC3339)
+	if req.Workspace != "" {
+		defaultContainerConfig.Labels[constants.LabelWorkspace] = req.Workspace
+	}
+	// kept whenever host ports were reserved so ContainerSpec only restores the env values that had placeholders
+	if assignedPorts != nil {
+		data, err := json.Marshal(portEnv)
+		if err != nil {
+			releasePorts()
+			return nil, err
+		}
+		defaultContainerConfig.Labels[constants.LabelPortEnv] = string(data)
+	}
+
+	// check admission policy
+	if err := s.admit(defaultContainerConfig, defaultHostConfig); err != nil {
+		releasePorts()
+		return nil, err
+	}
+
 	resp, err := s.cli.ContainerCreate(
 		ctx,
 		defaultContainerConfig,
@@ -563,9 +728,90 @@ func (s *ContainerService) CreateContainer(req *CreateContainerRequest) (*contai
 		nil,
 		containerName,
 	)
+	if err != nil {
+		releasePorts()
+		return nil, err
+	}
+
+	return &CreateContainerResponse{CreateResponse: resp, Ports: assignedPorts}, nil
 
-	return &resp, err
+}
+
+// UpdateContainer applies new cpu/memory limits and restart policy to an existing (running) container
+func (s *ContainerService) UpdateContainer(containerID string, req *UpdateContainerRequest) (*container.UpdateResponse, error) {
+	ctx := context.Background()
+	updateConfig := container.UpdateConfig{}
+
+	// set cpus
+	if req.CPUQuota < 0 {
+		return nil, fmt.Errorf("cpuQuota must be non-negative")
+	}
+	if req.CPUQuota > 0 {
+		updateConfig.Resources.NanoCPUs = req.CPUQuota * 1_000_000_000
+	}
+
+	// set mem_limit
+	memBytes, err := s.parseMemoryLimitWithSanityCheck(req.Memory)
+	if err != nil {
+		return nil, err
+	}
+	if req.Memory != "" && memBytes < 6*1024*1024 {
+		return nil, fmt.Errorf("memory limit must be at least 6m")
+	}
+	updateConfig.Resources.Memory = memBytes
+
+	// set memory_swap
+	switch strings.TrimSpace(req.MemorySwap) {
+	case "":
+		// docker rejects a memory limit above the current swap limit, scale swap with memory so
+		// no swap (swap == memory) stays no swap and the create-time 2x stays 2x
+		if memBytes > 0 {
+			inspect, err := s.cli.ContainerInspect(ctx, containerID)
+			if err != nil {
+				return nil, err
+			}
+			if inspect.HostConfig != nil && inspect.HostConfig.MemorySwap > 0 {
+				updateConfig.Resources.MemorySwap = scaledMemorySwap(inspect.HostConfig.Memory, inspect.HostConfig.MemorySwap, memBytes)
+			}
+		}
+	case "-1":
+		updateConfig.Resources.MemorySwap = -1
+	default:
+		swapBytes, err := s.parseMemoryLimitWithSanityCheck(req.MemorySwap)
+		if err != nil {
+			return nil, err
+		}
+		if memBytes > 0 && swapBytes < memBytes {
+			return nil, fmt.Errorf("memorySwap must be greater than or equal to memory")
+		}
+		updateConfig.Resources.MemorySwap = swapBytes
+	}
+
+	// set restart
+	if req.Restart != "" {
+		updateConfig.RestartPolicy = s.parseRestartPolicy(req.Restart)
+	}
 
+	resp, err := s.cli.ContainerUpdate(ctx, containerID, updateConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// scaledMemorySwap keeps the swap to memory ratio of a container for its new memory limit
+func scaledMemorySwap(memory, memorySwap, newMemory int64) int64 {
+	if memory <= 0 {
+		return newMemory * 2
+	}
+	if memorySwap == memory {
+		return newMemory
+	}
+	swap := int64(float64(newMemory) * float64(memorySwap) / float64(memory))
+	if swap < newMemory {
+		return newMemory
+	}
+	return swap
 }
 
 // StopContainer
@@ -586,12 +832,72 @@ func (s *ContainerService) RestartContainer(containerID string) error {
 	return s.cli.ContainerRestart(ctx, containerID, container.StopOptions{})
 }
 
-// RemoveContainer
+// RemoveContainer removes the container and releases its reserved host ports
 func (s *ContainerService) RemoveContainer(containerID string, force bool) error {
 	ctx := context.Background()
-	return s.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{
+	name := ""
+	if inspect, err := s.cli.ContainerInspect(ctx, containerID); err == nil {
+		name = strings.TrimPrefix(inspect.Name, "/")
+	}
+	if err := s.cli.ContainerRemove(ctx, containerID, container.RemoveOptions{
 		Force: force,
-	})
+	}); err != nil {
+		return err
+	}
+	if name != "" {
+		s.ports.Release(name)
+	}
+	return nil
+}
+
+// PrunePortReservations frees the host ports of containers removed while the agent was down
+func (s *ContainerService) PrunePortReservations() error {
+	containers, err := s.cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, c := range containers {
+		for _, n := range c.Names {
+			existing[strings.TrimPrefix(n, "/")] = true
+		}
+	}
+	s.ports.Prune(existing)
+	return nil
+}
+
+// reservePorts reserves host ports for the placeholder names, skipping ports published by any container
+func (s *ContainerService) reservePorts(containerName string, names []string) (map[string]int, error) {
+	containers, err := s.cli.ContainerList(context.Background(), container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[int]bool{}
+	for _, c := range containers {
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				inUse[int(p.PublicPort)] = true
+			}
+		}
+	}
+	// ports of a stopped container are not listed, keep the ones bound in its host config
+	for _, c := range containers {
+		if c.State == "running" {
+			continue
+		}
+		inspect, err := s.cli.ContainerInspect(context.Background(), c.ID)
+		if err != nil || inspect.HostConfig == nil {
+			continue
+		}
+		for _, bindings := range inspect.HostConfig.PortBindings {
+			for _, b := range bindings {
+				if p, err := strconv.Atoi(b.HostPort); err == nil {
+					inUse[p] = true
+				}
+			}
+		}
+	}
+	return s.ports.Reserve(containerName, names, inUse)
 }
 
 // ListContainers
@@ -626,6 +932,94 @@ func (s *ContainerService) LogsContainer(containerID string, tail string) (strin
 	return string(logs), nil
 }
 
+// LogLine is a single demultiplexed log line of a container
+type LogLine struct {
+	Stream    string `json:"stream"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Line      string `json:"line"`
+}
+
+// logLineWriter splits written bytes into lines and emits them as LogLine
+type logLineWriter struct {
+	stream string
+	buf    []byte
+	emit   func(LogLine) error
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSuffix(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if err := w.emit(newLogLine(w.stream, line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *logLineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.emit(newLogLine(w.stream, line))
+}
+
+// newLogLine splits the RFC3339Nano timestamp docker prepends with Timestamps: true
+func newLogLine(stream, raw string) LogLine {
+	if ts, rest, ok := strings.Cut(raw, " "); ok {
+		if _, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return LogLine{Stream: stream, Timestamp: ts, Line: rest}
+		}
+	}
+	return LogLine{Stream: stream, Line: raw}
+}
+
+// FollowLogsContainer streams stdout/stderr lines of the container until ctx is done or emit fails
+func (s *ContainerService) FollowLogsContainer(ctx context.Context, containerID string, tail string, emit func(LogLine) error) error {
+	inspect, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	out, err := s.cli.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+		Tail:       tail,
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	stdout := &logLineWriter{stream: "stdout", emit: emit}
+	stderr := &logLineWriter{stream: "stderr", emit: emit}
+
+	// tty containers are not multiplexed
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = io.Copy(stdout, out)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, out)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := stdout.Flush(); err != nil {
+		return err
+	}
+	return stderr.Flush()
+}
+
 // InspectContainer
 func (s *ContainerService) InspectContainer(containerID string) (container.InspectResponse, error) {
 	ctx := context.Background()
@@ -636,43 +1030,84 @@ func (s *ContainerService) InspectContainer(containerID string) (container.Inspe
 	return inspect, nil
 }
 
-func (s *ContainerService) ListCodeServerContainersPrefix() ([]container.Summary, error) {
+// exactNameFilter matches only the container named exactly name, docker name filters are regexps otherwise matching substrings
+func exactNameFilter(name string) filters.Args {
+	return filters.NewArgs(filters.Arg("name", "^/"+regexp.QuoteMeta(strings.TrimPrefix(name, "/"))+"$"))
+}
+
+// ListManagedContainers returns containers labelled as platform containers, plus unlabelled legacy code-server containers
+func (s *ContainerService) ListManagedContainers() ([]ManagedContainer, error) {
 	ctx := context.Background()
 
-	args := filters.NewArgs()
-	args.Add("name", "code-server")
+	labelled, err := s.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", constants.LabelManaged+"=true")),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
+	legacy, err := s.cli.ContainerList(ctx, container.ListOptions{
 		All:     true,
-		Filters: args,
+		Filters: filters.NewArgs(filters.Arg("name", "^/"+regexp.QuoteMeta(constants.LegacyContainerPrefix))),
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	filtered := []container.Summary{}
-	for _, c := range containers {
-		for _, name := range c.Names {
-			name = strings.TrimPrefix(name, "/")
-			if strings.HasPrefix(name, "code-server-") {
-				filtered = append(filtered, c)
-				break
-			}
+	managed := []ManagedContainer{}
+	for _, c := range labelled {
+		managed = append(managed, ManagedContainer{
+			Summary: c,
+			Owner:     c.Labels[constants.LabelOwner],
+			Profile:   c.Labels[constants.LabelProfile],
+			Workspace: c.Labels[constants.LabelWorkspace],
+		})
+	}
+	for _, c := range legacy {
+		if c.Labels[constants.LabelManaged] == "true" || len(c.Names) == 0 {
+			continue
 		}
+		managed = append(managed, ManagedContainer{
+			Summary: c,
+			Owner:   strings.TrimPrefix(strings.TrimPrefix(c.Names[0], "/"), constants.LegacyContainerPrefix),
+			Legacy:  true,
+		})
 	}
 
-	return filtered, nil
+	return managed, nil
 }
 
-func (s *ContainerService) GetContainerIDByName(name string) (string, error) {
+// ContainerOwner returns the owner label of the container named name, or the user in the name of a legacy container
+func (s *ContainerService) ContainerOwner(name string) (string, error) {
 	ctx := context.Background()
 
-	args := filters.NewArgs()
-	args.Add("name", name)
+	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: exactNameFilter(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(containers) == 0 {
+		return "", fmt.Errorf("container with name '%s' not found", name)
+	}
+
+	if owner := containers[0].Labels[constants.LabelOwner]; owner != "" {
+		return owner, nil
+	}
+	if strings.HasPrefix(name, constants.LegacyContainerPrefix) {
+		return strings.TrimPrefix(name, constants.LegacyContainerPrefix), nil
+	}
+	return "", fmt.Errorf("container '%s' has no owner", name)
+}
+
+func (s *ContainerService) GetContainerIDByName(name string) (string, error) {
+	ctx := context.Background()
 
 	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
 		All:     true,
-		Filters: args,
+		Filters: exactNameFilter(name),
 	})
 	if err != nil {
 		return "", err
@@ -685,10 +1120,17 @@ func (s *ContainerService) GetContainerIDByName(name string) (string, error) {
 	return containers[0].ID, nil
 }
 
-func (s *ContainerService) GetConfigDefaults() (*ConfigDefaultsResponse, error) {
-	containerName := s.config.ContainerTemplate.ContainerName
-	defaultContainerConfig := s.buildContainerConfig()
-	defaultHostConfig := s.buildHostConfig(defaultContainerConfig)
+func (s *ContainerService) GetConfigDefaults(profile string) (*ConfigDefaultsResponse, error) {
+	profileName, tpl, err := s.config.Template(profile)
+	if err != nil {
+		return nil, err
+	}
+	containerName := tpl.ContainerName
+	defaultContainerConfig := s.buildContainerConfig(tpl)
+	defaultHostConfig, err := s.buildHostConfig(tpl, defaultContainerConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	// memory
 	memBytes := defaultHostConfig.Resources.Memory
@@ -700,8 +1142,8 @@ func (s *ContainerService) GetConfigDefaults() (*ConfigDefaultsResponse, error)
 
 	// cpus (int64)
 	var cpus int64
-	if s.config.ContainerTemplate.Cpus > 0 {
-		cpus = int64(s.config.ContainerTemplate.Cpus) * 1_000_000_000
+	if tpl.Cpus > 0 {
+		cpus = int64(tpl.Cpus) * 1_000_000_000
 	} else {
 		cpus = 1_000_000_000
 	}
@@ -728,8 +1170,12 @@ func (s *ContainerService) GetConfigDefaults() (*ConfigDefaultsResponse, error)
 			portList = append(portList, fmt.Sprintf("%s:%s", binding.HostPort, k.Port()))
 		}
 	}
+	// dynamic ports are returned as placeholders, host ports are reserved on create
+	_, dynamicPorts := splitPortPlaceholders(tpl.Ports)
+	portList = append(portList, dynamicPorts...)
 
 	resp := &ConfigDefaultsResponse{
+		Profile:    profileName,
 		Image:      defaultContainerConfig.Image,
 		Name:       containerName,
 		Env:        envMap,
@@ -742,6 +1188,18 @@ func (s *ContainerService) GetConfigDefaults() (*ConfigDefaultsResponse, error)
 		Network:    defaultHostConfig.NetworkMode.NetworkName(),
 		Restart:    string(defaultHostConfig.RestartPolicy.Name),
 		ExtraHosts: defaultHostConfig.ExtraHosts,
+
+		Ulimits:           formatUlimits(defaultHostConfig.Resources.Ulimits),
+		ShmSize:           formatMemory(defaultHostConfig.ShmSize),
+		MemorySwap:        formatMemorySwap(defaultHostConfig.Resources.MemorySwap),
+		MemoryReservation: formatMemory(defaultHostConfig.Resources.MemoryReservation),
+		CPUShares:         defaultHostConfig.Resources.CPUShares,
+		CpusetCpus:        defaultHostConfig.Resources.CpusetCpus,
+		StorageSize:       defaultHostConfig.StorageOpt["size"],
+		Tmpfs:             formatTmpfs(defaultHostConfig.Tmpfs),
+	}
+	if defaultHostConfig.Resources.PidsLimit != nil {
+		resp.PidsLimit = *defaultHostConfig.Resources.PidsLimit
 	}
 
 	return resp, nil
@@ -750,12 +1208,9 @@ func (s *ContainerService) GetConfigDefaults() (*ConfigDefaultsResponse, error)
 // IsContainerExist checks if a container with the given name exists (any state)
 func (s *ContainerService) IsContainerExist(name string) (bool, error) {
 	ctx := context.Background()
-	args := filters.NewArgs()
-	args.Add("name", name)
-
 	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
 		All:     true, // all containers
-		Filters: args,
+		Filters: exactNameFilter(name),
 	})
 	if err != nil {
 		return false, err
@@ -767,12 +1222,9 @@ func (s *ContainerService) IsContainerExist(name string) (bool, error) {
 // IsContainerRunning checks if a container with the given name is currently running
 func (s *ContainerService) IsContainerRunning(name string) (bool, error) {
 	ctx := context.Background()
-	args := filters.NewArgs()
-	args.Add("name", name)
-
 	containers, err := s.cli.ContainerList(ctx, container.ListOptions{
 		All:     false, // only running containers
-		Filters: args,
+		Filters: exactNameFilter(name),
 	})
 	if err != nil {
 		return false, err
@@ -781,6 +1233,21 @@ func (s *ContainerService) IsContainerRunning(name string) (bool, error) {
 	return len(containers) > 0, nil
 }
 
+// IsContainerPortReady reports whether the container is running and accepts TCP connections on port.
+func (s *ContainerService) IsContainerPortReady(name string, port int) (bool, error) {
+	running, err := s.IsContainerRunning(name)
+	if err != nil || !running {
+		return false, err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(name, strconv.Itoa(port)), 2*time.Second)
+	if err != nil {
+		return false, nil
+	}
+	conn.Close()
+	return true, nil
+}
+
 func (s *ContainerService) GetContainerStats(containerID string) (*ContainerStatsResponse, error) {
 	ctx := context.Background()
 
@@ -867,3 +1334,22 @@ func calculateMemory(v container.StatsResponse) (usageGB, limitGB, percent float
 	return
 }
 
+// ListProfiles returns the configured container profiles sorted by name
+func (s *ContainerService) ListProfiles() []ProfileSummary {
+	defaultName, _, _ := s.config.Template("")
+	profiles := []ProfileSummary{}
+	for _, name := range s.config.ProfileNames() {
+		tpl := s.config.ContainerTemplates[name]
+		profiles = append(profiles, ProfileSummary{
+			Name:        name,
+			Description: tpl.Description,
+			Image:       tpl.ImageName,
+			Cpus:        tpl.Cpus,
+			Memory:      tpl.MemLimit,
+			Default:     name == defaultName,
+
+			ForwardPorts: tpl.ForwardPorts,
+		})
+	}
+	return profiles
+}
@@ -132,3 +132,50 @@ func (s *DiscoveryService) Healtcheck(req *HealthcheckRequest) (*HealthcheckResp
 	return &result, nil
 
 }
+
+// AgentURL is the address proxy-backend knows this agent by
+func (s *DiscoveryService) AgentURL() string {
+	return fmt.Sprintf("%s://%s", s.config.AgentMetadata.MainHostProto, s.config.AgentMetadata.MainHost)
+}
+
+func (s *DiscoveryService) PushEvent(req *ContainerEvent) error {
+	endpoint := "/discovery/events"
+	agentAPI := fmt.Sprintf("%s%s", s.config.AgentMetadata.ServerURL, endpoint)
+	resp, err := s.restyClient.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.config.AgentMetadata.AgentKey).
+		SetBody(req).
+		Post(agentAPI)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		var bodyStr string
+		if resp.Body() != nil {
+			bodyStr = string(resp.Body())
+		}
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), bodyStr)
+	}
+	return nil
+}
+
+func (s *DiscoveryService) PushAlert(req *MemoryAlert) error {
+	endpoint := "/discovery/alerts"
+	agentAPI := fmt.Sprintf("%s%s", s.config.AgentMetadata.ServerURL, endpoint)
+	resp, err := s.restyClient.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.config.AgentMetadata.AgentKey).
+		SetBody(req).
+		Post(agentAPI)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		var bodyStr string
+		if resp.Body() != nil {
+			bodyStr = string(resp.Body())
+		}
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), bodyStr)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog"
+
+	"a0/internal/app/constants"
+)
+
+// ContainerEvent is a docker lifecycle event of a managed container, forwarded to proxy-backend
+type ContainerEvent struct {
+	InstanceID    string `json:"instanceID"`
+	AgentHost     string `json:"agentHost"`
+	ContainerID   string `json:"containerID"`
+	ContainerName string `json:"containerName"`
+	Action        string `json:"action"`
+	Status        string `json:"status,omitempty"`
+	ExitCode      string `json:"exitCode,omitempty"`
+	Image         string `json:"image,omitempty"`
+	Time          string `json:"time"`
+}
+
+// watchedActions are the docker event actions forwarded to proxy-backend
+var watchedActions = []events.Action{
+	events.ActionStart,
+	events.ActionDie,
+	events.ActionOOM,
+	events.ActionHealthStatus,
+	events.ActionDestroy,
+}
+
+// WatchEvents subscribes to the docker events stream and calls emit for every event of a managed container.
+// It returns when ctx is done or the stream fails.
+func (s *ContainerService) WatchEvents(ctx context.Context, emit func(*ContainerEvent)) error {
+	args := filters.NewArgs()
+	args.Add("type", string(events.ContainerEventType))
+	for _, action := range watchedActions {
+		args.Add("event", string(action))
+	}
+
+	msgs, errs := s.cli.Events(ctx, events.ListOptions{Filters: args})
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case msg := <-msgs:
+			name := msg.Actor.Attributes["name"]
+			// event attributes carry the container labels
+			if msg.Actor.Attributes[constants.LabelManaged] != "true" && !strings.HasPrefix(name, constants.LegacyContainerPrefix) {
+				continue
+			}
+
+			// health events come as "health_status: healthy"
+			action, status, _ := strings.Cut(string(msg.Action), ":")
+			emit(&ContainerEvent{
+				ContainerID:   msg.Actor.ID,
+				ContainerName: name,
+				Action:        action,
+				Status:        strings.TrimSpace(status),
+				ExitCode:      msg.Actor.Attributes["exitCode"],
+				Image:         msg.Actor.Attributes["image"],
+				Time:          time.Unix(0, msg.TimeNano).UTC().Format(time.RFC3339Nano),
+			})
+		}
+	}
+}
+
+// EventForwarder pushes container events to proxy-backend, re-subscribing when the docker stream drops
+type EventForwarder struct {
+	containers *ContainerService
+	discovery  *DiscoveryService
+	log        zerolog.Logger
+	hooks      []func(*ContainerEvent)
+}
+
+func NewEventForwarder(containers *ContainerService, discovery *DiscoveryService, log zerolog.Logger) *EventForwarder {
+	return &EventForwarder{containers: containers, discovery: discovery, log: log}
+}
+
+// OnEvent registers fn to be called for every watched event, must be called before Start
+func (f *EventForwarder) OnEvent(fn func(*ContainerEvent)) {
+	f.hooks = append(f.hooks, fn)
+}
+
+// Start watches docker events in background until ctx is done
+func (f *EventForwarder) Start(ctx context.Context, retryInterval time.Duration) {
+	go func() {
+		for {
+			err := f.containers.WatchEvents(ctx, func(ev *ContainerEvent) {
+				ev.InstanceID = f.discovery.config.AgentMetadata.InstanceID
+				ev.AgentHost = f.discovery.AgentURL()
+				for _, hook := range f.hooks {
+					hook(ev)
+				}
+				if err := f.discovery.PushEvent(ev); err != nil {
+					f.log.Warn().Err(err).Msgf("failed to forward %s event of %s", ev.Action, ev.ContainerName)
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			f.log.Warn().Err(err).Msg("docker events stream closed, re-subscribing...")
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultExecShell prefers bash and falls back to sh
+var defaultExecShell = []string{"/bin/sh", "-c", "if [ -x /bin/bash ]; then exec /bin/bash -l; else exec /bin/sh -l; fi"}
+
+// ExecAttach creates an interactive TTY exec session in the container and attaches to it.
+// The caller must close the returned HijackedResponse.
+func (s *ContainerService) ExecAttach(ctx context.Context, containerID string, cmd []string, user string) (types.HijackedResponse, string, error) {
+	if len(cmd) == 0 {
+		cmd = defaultExecShell
+	}
+
+	exec, err := s.cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		User:         user,
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return types.HijackedResponse{}, "", err
+	}
+
+	hijacked, err := s.cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{Tty: true})
+	if err != nil {
+		return types.HijackedResponse{}, "", err
+	}
+	return hijacked, exec.ID, nil
+}
+
+// ExecResize resizes the TTY of an exec session
+func (s *ContainerService) ExecResize(ctx context.Context, execID string, rows, cols uint) error {
+	return s.cli.ContainerExecResize(ctx, execID, container.ResizeOptions{Height: rows, Width: cols})
+}
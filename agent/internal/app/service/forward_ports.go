@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// forwardPortCacheTTL is how long the forwarded ports of a container are remembered, a newly declared port
+// can be reached at the latest after it
+const forwardPortCacheTTL = 10 * time.Second
+
+type cachedPorts struct {
+	ports map[int]string
+	at    time.Time
+}
+
+// ForwardPortRegistry reads the ports users declared for forwarding in proxy-backend, only those are proxied.
+// proxy-backend keeps them of every container in the hash forwarded-ports:<user>/<container name>, port -> protocol.
+type ForwardPortRegistry struct {
+	rdb   *redis.Client
+	cache *sync.Map
+	log   zerolog.Logger
+}
+
+func NewForwardPortRegistry(rdb *redis.Client, log zerolog.Logger) *ForwardPortRegistry {
+	return &ForwardPortRegistry{rdb, &sync.Map{}, log}
+}
+
+// ports returns the declared ports of the container of user with their protocol, cached for forwardPortCacheTTL
+func (r *ForwardPortRegistry) ports(ctx context.Context, user, containerName string) (map[int]string, error) {
+	key := user + "/" + containerName
+	if v, ok := r.cache.Load(key); ok {
+		if cached := v.(cachedPorts); time.Since(cached.at) < forwardPortCacheTTL {
+			return cached.ports, nil
+		}
+	}
+	vals, err := r.rdb.HGetAll(ctx, "forwarded-ports:"+key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get forwarded ports of %s: %w", key, err)
+	}
+	ports := map[int]string{}
+	for field, protocol := range vals {
+		if port, err := strconv.Atoi(field); err == nil {
+			ports[port] = protocol
+		}
+	}
+	r.cache.Store(key, cachedPorts{ports, time.Now()})
+	return ports, nil
+}
+
+// forwardScheme is the scheme the container is reached with for a protocol of a forwarded port path,
+// the sse protocols only change the response headers
+func forwardScheme(protocol string) string {
+	if protocol == "https" || protocol == "sse-https" {
+		return "https"
+	}
+	return "http"
+}
+
+// IsForwarded reports whether user declared port of the container for forwarding with a protocol of the
+// same scheme as protocol
+func (r *ForwardPortRegistry) IsForwarded(ctx context.Context, user, containerName string, port int, protocol string) bool {
+	ports, err := r.ports(ctx, user, containerName)
+	if err != nil {
+		r.log.Error().Err(err).Msg("forward ports: lookup failed")
+		return false
+	}
+	declared, ok := ports[port]
+	return ok && forwardScheme(declared) == forwardScheme(protocol)
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// pullProgressInterval limits how often download/extract progress of the same layer is reported
+const pullProgressInterval = 500 * time.Millisecond
+
+// PullProgress is one progress event of an image pull. Layer is empty for image level messages
+// like "Pulling from library/python" or "Digest: sha256:...".
+type PullProgress struct {
+	Image   string `json:"image"`
+	Layer   string `json:"layer,omitempty"`
+	Status  string `json:"status"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
+
+// PullImage pulls the image and reports the per-layer progress of the docker pull stream to emit, emit may be nil.
+// The pull only finishes once the stream is consumed, failures are reported inside the stream.
+func (s *ContainerService) PullImage(ctx context.Context, imageName string, emit func(PullProgress) error) error {
+	registryAuth, err := s.auth.Encoded(imageName)
+	if err != nil {
+		return err
+	}
+	out, err := s.cli.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	type layerState struct {
+		status string
+		at     time.Time
+	}
+	layers := map[string]layerState{}
+
+	dec := json.NewDecoder(out)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if emit == nil {
+			continue
+		}
+
+		p := PullProgress{Image: imageName, Status: msg.Status}
+		// the id of "Pulling from ..." is the tag, not a layer
+		if msg.ID != "" && !strings.HasPrefix(msg.Status, "Pulling from") {
+			p.Layer = msg.ID
+		}
+		if msg.Progress != nil {
+			p.Current = msg.Progress.Current
+			p.Total = msg.Progress.Total
+		}
+
+		// status changes are always reported, progress of the same status only every pullProgressInterval
+		if p.Layer != "" {
+			last, seen := layers[p.Layer]
+			now := time.Now()
+			if seen && last.status == p.Status && now.Sub(last.at) < pullProgressInterval {
+				continue
+			}
+			layers[p.Layer] = layerState{status: p.Status, at: now}
+		}
+		if err := emit(p); err != nil {
+			return err
+		}
+	}
+}
+
+// ImageID resolves an image reference to the ID of the local image, so references written differently
+// ("python" and "docker.io/library/python:latest") or a tag moved by a pull can be compared
+func (s *ContainerService) ImageID(ctx context.Context, imageName string) (string, error) {
+	img, err := s.cli.ImageInspect(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	return img.ID, nil
+}
+
+// EnsureImage pulls the image unless it is already present on the host. With always the registry is asked
+// for a newer image of a moving tag like "latest" too. pulled reports whether the local image changed.
+func (s *ContainerService) EnsureImage(ctx context.Context, imageName string, always bool, emit func(PullProgress) error) (pulled bool, err error) {
+	present, err := s.cli.ImageInspect(ctx, imageName)
+	if err == nil && !always {
+		return false, nil
+	}
+
+	if err := s.PullImage(ctx, imageName, emit); err != nil {
+		return false, err
+	}
+	if present.ID != "" {
+		if current, err := s.cli.ImageInspect(ctx, imageName); err == nil && current.ID == present.ID {
+			return false, nil
+		}
+	}
+	s.log.Info().Msgf("pulled image %s", imageName)
+	return true, nil
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultMemoryPressurePercent = 90.0
+	defaultMemoryWatchInterval   = 30 * time.Second
+	// a pressure alert is sent again only after usage drops this many points below the threshold
+	memoryPressureHysteresis = 10.0
+	// docker sends "oom" before "die", wait a bit to know whether the container itself was killed
+	oomSettleDelay = 2 * time.Second
+)
+
+// Memory alert kinds
+const (
+	MemoryAlertOOMKilled = "oom_killed"      // container was killed by the kernel OOM killer
+	MemoryAlertOOM       = "oom"             // a process was OOM killed but the container keeps running
+	MemoryAlertPressure  = "memory_pressure" // usage crossed the pressure threshold
+)
+
+// MemoryAlert is sent to proxy-backend, memory values are GB like ContainerStatsResponse
+type MemoryAlert struct {
+	InstanceID    string  `json:"instanceID"`
+	AgentHost     string  `json:"agentHost"`
+	ContainerID   string  `json:"containerID"`
+	ContainerName string  `json:"containerName"`
+	Kind          string  `json:"kind"`
+	MemoryUsage   float64 `json:"memory_usage"`
+	MemoryLimit   float64 `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	PeakUsage     float64 `json:"peak_usage"`
+	Time          string  `json:"time"`
+}
+
+type memorySample struct {
+	last      ContainerStatsResponse
+	peak      float64
+	pressured bool
+	oomKilled bool
+}
+
+// MemoryWatcher samples memory of managed containers and reports OOM kills and memory pressure
+type MemoryWatcher struct {
+	containers *ContainerService
+	discovery  *DiscoveryService
+	threshold  float64
+	log        zerolog.Logger
+	mu         sync.Mutex
+	samples    map[string]*memorySample
+}
+
+func NewMemoryWatcher(containers *ContainerService, discovery *DiscoveryService, log zerolog.Logger) *MemoryWatcher {
+	threshold := discovery.config.MemoryWatch.PressurePercent
+	if threshold <= 0 || threshold > 100 {
+		threshold = defaultMemoryPressurePercent
+	}
+	return &MemoryWatcher{
+		containers: containers,
+		discovery:  discovery,
+		threshold:  threshold,
+		log:        log,
+		samples:    make(map[string]*memorySample),
+	}
+}
+
+// Interval returns the configured sampling interval
+func (w *MemoryWatcher) Interval() time.Duration {
+	if sec := w.discovery.config.MemoryWatch.IntervalSeconds; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return defaultMemoryWatchInterval
+}
+
+// Start samples container memory in background until ctx is done
+func (w *MemoryWatcher) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.Sample()
+			}
+		}
+	}()
+}
+
+// Sample reads stats of running managed containers, tracks peaks and reports pressure
+func (w *MemoryWatcher) Sample() {
+	containers, err := w.containers.ListManagedContainers()
+	if err != nil {
+		w.log.Warn().Err(err).Msg("memory watch: failed to list containers")
+		return
+	}
+
+	seen := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		if c.State != "running" || len(c.Names) == 0 {
+			continue
+		}
+		name := c.Names[0][1:]
+		seen[name] = true
+
+		stats, err := w.containers.GetContainerStats(c.ID)
+		if err != nil {
+			w.log.Debug().Err(err).Msgf("memory watch: failed to get stats of %s", name)
+			continue
+		}
+
+		w.mu.Lock()
+		sample := w.sample(name)
+		sample.last = *stats
+		if stats.MemoryUsage > sample.peak {
+			sample.peak = stats.MemoryUsage
+		}
+		notify := false
+		if !sample.pressured && stats.MemoryPercent >= w.threshold {
+			sample.pressured = true
+			notify = true
+		} else if sample.pressured && stats.MemoryPercent < w.threshold-memoryPressureHysteresis {
+			sample.pressured = false
+		}
+		peak := sample.peak
+		w.mu.Unlock()
+
+		if notify {
+			w.push(&MemoryAlert{
+				ContainerID:   c.ID,
+				ContainerName: name,
+				Kind:          MemoryAlertPressure,
+				MemoryUsage:   stats.MemoryUsage,
+				MemoryLimit:   stats.MemoryLimit,
+				MemoryPercent: stats.MemoryPercent,
+				PeakUsage:     peak,
+			})
+		}
+	}
+
+	w.mu.Lock()
+	for name := range w.samples {
+		if !seen[name] {
+			delete(w.samples, name)
+		}
+	}
+	w.mu.Unlock()
+}
+
+// HandleEvent reacts to docker lifecycle events forwarded by the EventForwarder
+func (w *MemoryWatcher) HandleEvent(ev *ContainerEvent) {
+	switch ev.Action {
+	case "start":
+		w.mu.Lock()
+		delete(w.samples, ev.ContainerName)
+		w.mu.Unlock()
+	case "oom":
+		time.AfterFunc(oomSettleDelay, func() { w.checkOOM(ev, true) })
+	case "die":
+		w.checkOOM(ev, false)
+	}
+}
+
+// checkOOM inspects the container and sends a single alert per OOM kill
+func (w *MemoryWatcher) checkOOM(ev *ContainerEvent, fromOOMEvent bool) {
+	inspect, err := w.containers.InspectContainer(ev.ContainerID)
+	if err != nil {
+		w.log.Warn().Err(err).Msgf("memory watch: failed to inspect %s", ev.ContainerName)
+		return
+	}
+	killed := inspect.State != nil && inspect.State.OOMKilled && !inspect.State.Running
+
+	w.mu.Lock()
+	sample := w.sample(ev.ContainerName)
+	kind := ""
+	switch {
+	case killed && !sample.oomKilled:
+		sample.oomKilled = true
+		kind = MemoryAlertOOMKilled
+	case !killed && fromOOMEvent:
+		kind = MemoryAlertOOM
+	}
+	last, peak := sample.last, sample.peak
+	w.mu.Unlock()
+
+	if kind == "" {
+		return
+	}
+	alert := &MemoryAlert{
+		ContainerID:   ev.ContainerID,
+		ContainerName: ev.ContainerName,
+		Kind:          kind,
+		MemoryUsage:   last.MemoryUsage,
+		MemoryLimit:   last.MemoryLimit,
+		MemoryPercent: last.MemoryPercent,
+		PeakUsage:     peak,
+	}
+	if inspect.HostConfig != nil && inspect.HostConfig.Memory > 0 {
+		alert.MemoryLimit = float64(inspect.HostConfig.Memory) / (1024 * 1024 * 1024)
+	}
+	w.push(alert)
+}
+
+// sample returns the tracked sample of the container, mu must be held
+func (w *MemoryWatcher) sample(name string) *memorySample {
+	sample, ok := w.samples[name]
+	if !ok {
+		sample = &memorySample{}
+		w.samples[name] = sample
+	}
+	return sample
+}
+
+func (w *MemoryWatcher) push(alert *MemoryAlert) {
+	alert.InstanceID = w.discovery.config.AgentMetadata.InstanceID
+	alert.AgentHost = w.discovery.AgentURL()
+	alert.Time = time.Now().UTC().Format(time.RFC3339)
+	w.log.Warn().Msgf("memory alert %s for %s: %.3f/%.3f GB (peak %.3f GB)",
+		alert.Kind, alert.ContainerName, alert.MemoryUsage, alert.MemoryLimit, alert.PeakUsage)
+	if err := w.discovery.PushAlert(alert); err != nil {
+		w.log.Warn().Err(err).Msgf("failed to forward memory alert of %s", alert.ContainerName)
+	}
+}
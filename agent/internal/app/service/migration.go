@@ -0,0 +1,164 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"a0/internal/app/constants"
+)
+
+// hostPortNumber matches numbers that may be reserved host ports, for containers created without the port env label
+var hostPortNumber = regexp.MustCompile(`\b\d{1,5}\b`)
+
+// workspaceStatePath is the code-server home directory moved between agents on migration
+const workspaceStatePath = "/config"
+
+// ContainerSpec rebuilds the create request of an existing container so it can be recreated on another agent.
+// Env inherited from the image is left out.
+func (s *ContainerService) ContainerSpec(containerID string) (*CreateContainerRequest, error) {
+	ctx := context.Background()
+	inspect, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.Config == nil || inspect.HostConfig == nil {
+		return nil, fmt.Errorf("container %s has no config", containerID)
+	}
+	cfg, hostCfg := inspect.Config, inspect.HostConfig
+
+	// reserved host ports go back to placeholders so the target agent reserves its own
+	name := strings.TrimPrefix(inspect.Name, "/")
+	placeholders := map[string]string{}
+	for portName, p := range s.ports.Assigned(name) {
+		placeholders[strconv.Itoa(p)] = fmt.Sprintf("${port:%s}", portName)
+	}
+	unresolve := func(v string) string {
+		if len(placeholders) == 0 {
+			return v
+		}
+		return hostPortNumber.ReplaceAllStringFunc(v, func(m string) string {
+			if placeholder, ok := placeholders[m]; ok {
+				return placeholder
+			}
+			return m
+		})
+	}
+
+	imageEnv := map[string]bool{}
+	if img, err := s.cli.ImageInspect(ctx, cfg.Image); err == nil && img.Config != nil {
+		for _, e := range img.Config.Env {
+			imageEnv[e] = true
+		}
+	}
+	// env values with placeholders come back from the label, older containers get reserved host ports replaced anywhere
+	var portEnv map[string]string
+	if raw, ok := cfg.Labels[constants.LabelPortEnv]; ok {
+		if err := json.Unmarshal([]byte(raw), &portEnv); err != nil {
+			return nil, fmt.Errorf("invalid %s label: %w", constants.LabelPortEnv, err)
+		}
+	}
+	env := map[string]string{}
+	for _, e := range cfg.Env {
+		if imageEnv[e] {
+			continue
+		}
+		k, v, ok := strings.Cut(e, "=")
+		if !ok {
+			continue
+		}
+		switch original, substituted := portEnv[k]; {
+		case substituted:
+			env[k] = original
+		case portEnv != nil:
+			env[k] = v
+		default:
+			env[k] = unresolve(v)
+		}
+	}
+
+	expose := []string{}
+	for p := range cfg.ExposedPorts {
+		if _, ok := placeholders[p.Port()]; !ok {
+			expose = append(expose, p.Port())
+		}
+	}
+	ports := []string{}
+	for p, bindings := range hostCfg.PortBindings {
+		for _, b := range bindings {
+			ports = append(ports, fmt.Sprintf("%s:%s", unresolve(b.HostPort), unresolve(p.Port())))
+		}
+	}
+
+	req := &CreateContainerRequest{
+		Image:      cfg.Image,
+		Name:       name,
+		Env:        env,
+		Volumes:    hostCfg.Binds,
+		Expose:     expose,
+		Ports:      ports,
+		CPUQuota:   hostCfg.NanoCPUs / 1_000_000_000,
+		Memory:     formatMemory(hostCfg.Memory),
+		Sysctls:    hostCfg.Sysctls,
+		Network:    string(hostCfg.NetworkMode),
+		Restart:    string(hostCfg.RestartPolicy.Name),
+		ExtraHosts: hostCfg.ExtraHosts,
+		Profile:    cfg.Labels[constants.LabelProfile],
+		Owner:      cfg.Labels[constants.LabelOwner],
+		CreatedBy:  cfg.Labels[constants.LabelCreatedBy],
+		Workspace:  cfg.Labels[constants.LabelWorkspace],
+
+		Ulimits:           formatUlimits(hostCfg.Ulimits),
+		ShmSize:           formatMemory(hostCfg.ShmSize),
+		MemorySwap:        formatMemorySwap(hostCfg.MemorySwap),
+		MemoryReservation: formatMemory(hostCfg.MemoryReservation),
+		CPUShares:         hostCfg.CPUShares,
+		CpusetCpus:        hostCfg.CpusetCpus,
+		StorageSize:       hostCfg.StorageOpt["size"],
+		Tmpfs:             formatTmpfs(hostCfg.Tmpfs),
+	}
+	if hostCfg.PidsLimit != nil {
+		req.PidsLimit = *hostCfg.PidsLimit
+	}
+	return req, nil
+}
+
+// ExportState returns the workspace home of the container as a gzip compressed tar stream.
+// The archive holds the directory content so it can be imported into the same path.
+func (s *ContainerService) ExportState(ctx context.Context, containerID string) (io.ReadCloser, error) {
+	content, _, err := s.cli.CopyFromContainer(ctx, containerID, workspaceStatePath+"/.")
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer content.Close()
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, content)
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// ImportState extracts a gzip compressed tar stream made by ExportState into the workspace home of the container
+func (s *ContainerService) ImportState(ctx context.Context, containerID string, r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return s.cli.CopyToContainer(ctx, containerID, workspaceStatePath, gz, container.CopyToContainerOptions{
+		CopyUIDGID: true,
+	})
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"a0/internal/config"
+)
+
+const (
+	defaultPortRange     = "40000-49999"
+	defaultPortStateFile = "data/ports.json"
+)
+
+// portPlaceholder matches "${port:name}" in template/request ports and env values
+var portPlaceholder = regexp.MustCompile(`\$\{port:([A-Za-z0-9_.-]+)\}`)
+
+// PortAllocator reserves host ports per container from the configured range.
+// Reservations are keyed by container name and persisted so they survive agent restarts.
+type PortAllocator struct {
+	from      int
+	to        int
+	stateFile string
+	log       zerolog.Logger
+	mu        sync.Mutex
+	reserved  map[string]map[string]int // container name -> placeholder name -> host port
+}
+
+func NewPortAllocator(cfg *config.Config, log zerolog.Logger) *PortAllocator {
+	a := &PortAllocator{
+		stateFile: cfg.PortAllocator.StateFile,
+		log:       log,
+		reserved:  map[string]map[string]int{},
+	}
+	if a.stateFile == "" {
+		a.stateFile = defaultPortStateFile
+	}
+
+	portRange := cfg.PortAllocator.Range
+	if portRange == "" {
+		portRange = defaultPortRange
+	}
+	from, to, err := parsePortRange(portRange)
+	if err != nil {
+		log.Warn().Err(err).Msgf("port_allocator: invalid range, using %s", defaultPortRange)
+		from, to, _ = parsePortRange(defaultPortRange)
+	}
+	a.from, a.to = from, to
+
+	if err := a.load(); err != nil {
+		log.Warn().Err(err).Msgf("port_allocator: failed to load %s, starting empty", a.stateFile)
+	}
+	return a
+}
+
+func parsePortRange(r string) (int, int, error) {
+	fromStr, toStr, ok := strings.Cut(strings.TrimSpace(r), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	from, err1 := strconv.Atoi(strings.TrimSpace(fromStr))
+	to, err2 := strconv.Atoi(strings.TrimSpace(toStr))
+	if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	return from, to, nil
+}
+
+func (a *PortAllocator) load() error {
+	data, err := os.ReadFile(a.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &a.reserved)
+}
+
+// save writes the reservations atomically, mu must be held
+func (a *PortAllocator) save() error {
+	data, err := json.MarshalIndent(a.reserved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.stateFile), 0o755); err != nil {
+		return err
+	}
+	tmp := a.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.stateFile)
+}
+
+// Reserve returns host ports for the given names of the container. Ports already reserved for the
+// container are kept, new ones are taken from the range skipping reserved ports and inUse.
+func (a *PortAllocator) Reserve(containerName string, names []string, inUse map[int]bool) (map[string]int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	taken := map[int]bool{}
+	for _, ports := range a.reserved {
+		for _, p := range ports {
+			taken[p] = true
+		}
+	}
+
+	current := a.reserved[containerName]
+	assigned := map[string]int{}
+	for name, p := range current {
+		assigned[name] = p
+	}
+
+	next := a.from
+	for _, name := range names {
+		if _, ok := assigned[name]; ok {
+			continue
+		}
+		for next <= a.to && (taken[next] || inUse[next]) {
+			next++
+		}
+		if next > a.to {
+			return nil, fmt.Errorf("no free host port left in %d-%d", a.from, a.to)
+		}
+		assigned[name] = next
+		taken[next] = true
+	}
+
+	a.reserved[containerName] = assigned
+	if err := a.save(); err != nil {
+		if current == nil {
+			delete(a.reserved, containerName)
+		} else {
+			a.reserved[containerName] = current
+		}
+		return nil, fmt.Errorf("failed to persist port reservations: %w", err)
+	}
+	return assigned, nil
+}
+
+// Assigned returns the ports reserved for the container, nil if there are none
+func (a *PortAllocator) Assigned(containerName string) map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ports, ok := a.reserved[containerName]
+	if !ok {
+		return nil
+	}
+	out := make(map[string]int, len(ports))
+	for name, p := range ports {
+		out[name] = p
+	}
+	return out
+}
+
+// Release frees the ports of the container
+func (a *PortAllocator) Release(containerName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.reserved[containerName]; !ok {
+		return
+	}
+	delete(a.reserved, containerName)
+	if err := a.save(); err != nil {
+		a.log.Warn().Err(err).Msgf("port_allocator: failed to persist release of %s", containerName)
+	}
+}
+
+// Prune frees the ports of containers that do not exist anymore
+func (a *PortAllocator) Prune(existing map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pruned := []string{}
+	for name := range a.reserved {
+		if !existing[name] {
+			delete(a.reserved, name)
+			pruned = append(pruned, name)
+		}
+	}
+	if len(pruned) == 0 {
+		return
+	}
+	sort.Strings(pruned)
+	a.log.Info().Msgf("port_allocator: released ports of removed containers %s", strings.Join(pruned, ", "))
+	if err := a.save(); err != nil {
+		a.log.Warn().Err(err).Msg("port_allocator: failed to persist pruned reservations")
+	}
+}
+
+// portPlaceholderNames returns the distinct placeholder names used in values, in order of appearance
+func portPlaceholderNames(values ...[]string) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, list := range values {
+		for _, v := range list {
+			for _, m := range portPlaceholder.FindAllStringSubmatch(v, -1) {
+				if !seen[m[1]] {
+					seen[m[1]] = true
+					names = append(names, m[1])
+				}
+			}
+		}
+	}
+	return names
+}
+
+func hasPortPlaceholder(v string) bool {
+	return portPlaceholder.MatchString(v)
+}
+
+// resolvePortPlaceholders replaces "${port:name}" with the assigned host port
+func resolvePortPlaceholders(v string, ports map[string]int) string {
+	return portPlaceholder.ReplaceAllStringFunc(v, func(m string) string {
+		name := portPlaceholder.FindStringSubmatch(m)[1]
+		if p, ok := ports[name]; ok {
+			return strconv.Itoa(p)
+		}
+		return m
+	})
+}
+
+// splitPortPlaceholders separates port mappings with placeholders from fixed ones
+func splitPortPlaceholders(ports []string) (fixed, dynamic []string) {
+	for _, p := range ports {
+		if hasPortPlaceholder(p) {
+			dynamic = append(dynamic, p)
+		} else {
+			fixed = append(fixed, p)
+		}
+	}
+	return fixed, dynamic
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"a0/internal/config"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to int
+		wantErr  bool
+	}{
+		{in: "40000-49999", from: 40000, to: 49999},
+		{in: " 40000 - 40010 ", from: 40000, to: 40010},
+		{in: "8080-8080", from: 8080, to: 8080},
+		{in: "8080", wantErr: true},
+		{in: "0-100", wantErr: true},
+		{in: "60000-70000", wantErr: true},
+		{in: "5000-4000", wantErr: true},
+		{in: "a-b", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		from, to, err := parsePortRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePortRange(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (from != tt.from || to != tt.to) {
+			t.Errorf("parsePortRange(%q) = %d-%d, want %d-%d", tt.in, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func newTestPortAllocator(t *testing.T, portRange, stateFile string) *PortAllocator {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.PortAllocator.Range = portRange
+	cfg.PortAllocator.StateFile = stateFile
+	return NewPortAllocator(cfg, zerolog.Nop())
+}
+
+func TestPortAllocator(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "ports.json")
+	a := newTestPortAllocator(t, "40000-40003", stateFile)
+
+	// ports in use on the host are skipped
+	alice, err := a.Reserve("code-server-alice", []string{"web", "api"}, map[int]bool{40000: true})
+	if err != nil {
+		t.Fatalf("Reserve alice: %v", err)
+	}
+	if want := map[string]int{"web": 40001, "api": 40002}; !maps.Equal(alice, want) {
+		t.Fatalf("Reserve alice = %v, want %v", alice, want)
+	}
+
+	// reserved ports are kept and only new names get a port
+	again, err := a.Reserve("code-server-alice", []string{"web", "debug"}, nil)
+	if err != nil {
+		t.Fatalf("Reserve alice again: %v", err)
+	}
+	if want := map[string]int{"web": 40001, "api": 40002, "debug": 40000}; !maps.Equal(again, want) {
+		t.Fatalf("Reserve alice again = %v, want %v", again, want)
+	}
+
+	// ports of other containers are taken
+	bob, err := a.Reserve("code-server-bob", []string{"web"}, nil)
+	if err != nil {
+		t.Fatalf("Reserve bob: %v", err)
+	}
+	if want := map[string]int{"web": 40003}; !maps.Equal(bob, want) {
+		t.Fatalf("Reserve bob = %v, want %v", bob, want)
+	}
+
+	// the range is exhausted, nothing is reserved
+	if _, err := a.Reserve("code-server-carol", []string{"web"}, nil); err == nil {
+		t.Fatal("Reserve carol: want error for an exhausted range")
+	}
+	if got := a.Assigned("code-server-carol"); got != nil {
+		t.Fatalf("Assigned carol = %v, want nil", got)
+	}
+
+	// reservations survive a restart
+	restarted := newTestPortAllocator(t, "40000-40003", stateFile)
+	if got := restarted.Assigned("code-server-alice"); !maps.Equal(got, again) {
+		t.Fatalf("Assigned alice after restart = %v, want %v", got, again)
+	}
+
+	restarted.Release("code-server-bob")
+	if got := restarted.Assigned("code-server-bob"); got != nil {
+		t.Fatalf("Assigned bob after release = %v, want nil", got)
+	}
+	carol, err := restarted.Reserve("code-server-carol", []string{"web"}, nil)
+	if err != nil {
+		t.Fatalf("Reserve carol after release: %v", err)
+	}
+	if want := map[string]int{"web": 40003}; !maps.Equal(carol, want) {
+		t.Fatalf("Reserve carol after release = %v, want %v", carol, want)
+	}
+
+	restarted.Prune(map[string]bool{"code-server-carol": true})
+	if got := restarted.Assigned("code-server-alice"); got != nil {
+		t.Fatalf("Assigned alice after prune = %v, want nil", got)
+	}
+	if got := restarted.Assigned("code-server-carol"); !maps.Equal(got, carol) {
+		t.Fatalf("Assigned carol after prune = %v, want %v", got, carol)
+	}
+}
+
+func TestPortAllocatorInvalidRange(t *testing.T) {
+	a := newTestPortAllocator(t, "9000-8000", filepath.Join(t.TempDir(), "ports.json"))
+	if a.from != 40000 || a.to != 49999 {
+		t.Errorf("invalid range gives %d-%d, want the default range", a.from, a.to)
+	}
+}
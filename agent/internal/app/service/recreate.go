@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// recreateStartCheck is how long the new container must keep running before the old one is removed
+const recreateStartCheck = 5 * time.Second
+
+type RecreateContainerRequest struct {
+	Image string `json:"image"` // empty pulls and reuses the current image reference
+}
+
+type RecreateContainerResponse struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	OldImage    string         `json:"oldImage"`
+	Image       string         `json:"image"`
+	Ports       map[string]int `json:"ports,omitempty"`
+	StateCopied bool           `json:"stateCopied"`
+}
+
+// RecreateContainer replaces the container with an equivalent one running imageName. The old container is
+// renamed aside and only removed once the new one is running, any failure puts the old one back.
+// The workspace home is copied over unless it is a bind or volume, reserved host ports stay the same.
+func (s *ContainerService) RecreateContainer(ctx context.Context, containerID string, imageName string) (*RecreateContainerResponse, error) {
+	inspect, err := s.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(inspect.Name, "/")
+	spec, err := s.ContainerSpec(inspect.ID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &RecreateContainerResponse{Name: name, OldImage: spec.Image, Image: strings.TrimSpace(imageName)}
+	if resp.Image == "" {
+		resp.Image = spec.Image
+	}
+	spec.Image = resp.Image
+
+	// pull even if present so a moved tag is picked up
+	if err := s.PullImage(ctx, resp.Image, nil); err != nil {
+		return nil, fmt.Errorf("failed to pull image %s: %w", resp.Image, err)
+	}
+
+	wasRunning := inspect.State != nil && inspect.State.Running
+	copyState := true
+	for _, m := range inspect.Mounts {
+		if m.Destination == workspaceStatePath {
+			copyState = false
+		}
+	}
+
+	// move the old container aside, its name is needed for the new one
+	if wasRunning {
+		if err := s.cli.ContainerStop(ctx, inspect.ID, container.StopOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to stop container: %w", err)
+		}
+	}
+	oldName := fmt.Sprintf("%s-recreate-%d", name, time.Now().Unix())
+	if err := s.cli.ContainerRename(ctx, inspect.ID, oldName); err != nil {
+		if wasRunning {
+			s.cli.ContainerStart(ctx, inspect.ID, container.StartOptions{})
+		}
+		return nil, fmt.Errorf("failed to rename container: %w", err)
+	}
+
+	// rollback removes the new container without releasing the ports, they still belong to the old one
+	rollback := func(newID string, cause error) error {
+		if newID != "" {
+			if err := s.cli.ContainerRemove(ctx, newID, container.RemoveOptions{Force: true}); err != nil {
+				s.log.Error().Err(err).Msgf("recreate: failed to remove new container of %s", name)
+			}
+		}
+		if err := s.cli.ContainerRename(ctx, inspect.ID, name); err != nil {
+			s.log.Error().Err(err).Msgf("recreate: failed to rename %s back to %s", oldName, name)
+			return fmt.Errorf("%w, rollback failed: old container is kept as %s", cause, oldName)
+		}
+		if wasRunning {
+			if err := s.cli.ContainerStart(ctx, inspect.ID, container.StartOptions{}); err != nil {
+				s.log.Error().Err(err).Msgf("recreate: failed to start %s after rollback", name)
+			}
+		}
+		s.log.Warn().Msgf("recreate: %s rolled back to %s", name, resp.OldImage)
+		return cause
+	}
+
+	created, err := s.CreateContainer(spec)
+	if err != nil {
+		return nil, rollback("", fmt.Errorf("failed to create container: %w", err))
+	}
+	resp.ID = created.ID
+	resp.Ports = created.Ports
+
+	if copyState {
+		if err := s.copyState(ctx, inspect.ID, created.ID); err != nil {
+			return nil, rollback(created.ID, fmt.Errorf("failed to copy workspace: %w", err))
+		}
+		resp.StateCopied = true
+	}
+
+	// the new container has to start and keep running, a stopped workspace is stopped again afterwards
+	if err := s.cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return nil, rollback(created.ID, fmt.Errorf("failed to start container: %w", err))
+	}
+	time.Sleep(recreateStartCheck)
+	started, err := s.cli.ContainerInspect(ctx, created.ID)
+	if err != nil {
+		return nil, rollback(created.ID, fmt.Errorf("failed to inspect new container: %w", err))
+	}
+	if started.State == nil || !started.State.Running {
+		exitCode := 0
+		if started.State != nil {
+			exitCode = started.State.ExitCode
+		}
+		return nil, rollback(created.ID, fmt.Errorf("new container exited with code %d", exitCode))
+	}
+	if !wasRunning {
+		if err := s.cli.ContainerStop(ctx, created.ID, container.StopOptions{}); err != nil {
+			s.log.Warn().Err(err).Msgf("recreate: failed to stop %s again", name)
+		}
+	}
+
+	if err := s.cli.ContainerRemove(ctx, inspect.ID, container.RemoveOptions{Force: true}); err != nil {
+		s.log.Warn().Err(err).Msgf("recreate: failed to remove old container %s", oldName)
+	}
+	s.log.Info().Msgf("recreate: %s moved from %s to %s", name, resp.OldImage, resp.Image)
+	return resp, nil
+}
+
+// copyState copies the workspace home from one container to another on the same host
+func (s *ContainerService) copyState(ctx context.Context, fromID, toID string) error {
+	content, _, err := s.cli.CopyFromContainer(ctx, fromID, workspaceStatePath+"/.")
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return s.cli.CopyToContainer(ctx, toID, workspaceStatePath, content, container.CopyToContainerOptions{
+		CopyUIDGID: true,
+	})
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/rs/zerolog"
+
+	"a0/internal/config"
+)
+
+const defaultRegistryAuthEnv = "AGENT_REGISTRY_AUTH"
+
+// dockerHubAliases are the names docker hub credentials may be stored under
+var dockerHubAliases = []string{"index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// RegistryCredential authenticates pulls from one registry
+type RegistryCredential struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// String never prints the secret, in case a credential ends up in a log statement
+func (c RegistryCredential) String() string {
+	return fmt.Sprintf("RegistryCredential{username: %q, secret: [redacted]}", c.Username)
+}
+
+// RegistryAuthStore holds the pull credentials per registry host. They are loaded from the environment variable
+// and the file of the registry_auth config and can be replaced at runtime by proxy-backend, which writes the file.
+// The file wins for the hosts it defines so credentials pushed by proxy-backend survive a restart.
+// Credentials are never logged, only registry hosts are.
+type RegistryAuthStore struct {
+	file  string
+	log   zerolog.Logger
+	mu    sync.RWMutex
+	creds map[string]RegistryCredential
+}
+
+func NewRegistryAuthStore(cfg *config.Config, log zerolog.Logger) *RegistryAuthStore {
+	s := &RegistryAuthStore{
+		file:  cfg.RegistryAuth.File,
+		log:   log,
+		creds: map[string]RegistryCredential{},
+	}
+
+	envName := cfg.RegistryAuth.Env
+	if envName == "" {
+		envName = defaultRegistryAuthEnv
+	}
+	if data := os.Getenv(envName); data != "" {
+		if _, err := s.merge([]byte(data)); err != nil {
+			log.Warn().Msgf("registry_auth: ignoring invalid %s: %v", envName, err)
+		}
+	}
+
+	if s.file != "" {
+		if data, err := os.ReadFile(s.file); err == nil {
+			overridden, err := s.merge(data)
+			if err != nil {
+				log.Warn().Msgf("registry_auth: ignoring invalid %s: %v", s.file, err)
+			} else if len(overridden) > 0 {
+				log.Warn().Msgf("registry_auth: %s overrides %s for %s", s.file, envName, strings.Join(overridden, ", "))
+			}
+		} else if !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("registry_auth: failed to read %s", s.file)
+		}
+	}
+
+	if hosts := s.Hosts(); len(hosts) > 0 {
+		log.Info().Msgf("registry_auth: credentials loaded for %s", strings.Join(hosts, ", "))
+	}
+	return s
+}
+
+// normalizeRegistryHost strips the scheme and path, "https://index.docker.io/v1/" becomes "docker.io"
+func normalizeRegistryHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	for _, alias := range dockerHubAliases {
+		if host == alias {
+			return "docker.io"
+		}
+	}
+	return host
+}
+
+// parseRegistryCredentials decodes {"host": {"username": "...", "password": "..."}} with normalized hosts
+func parseRegistryCredentials(data []byte) (map[string]RegistryCredential, error) {
+	raw := map[string]RegistryCredential{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		// the decoder error may quote the input, do not pass it on
+		return nil, fmt.Errorf("invalid registry credentials json")
+	}
+	creds := map[string]RegistryCredential{}
+	for host, cred := range raw {
+		h := normalizeRegistryHost(host)
+		if h == "" {
+			return nil, fmt.Errorf("empty registry host")
+		}
+		if cred.IdentityToken == "" && (cred.Username == "" || cred.Password == "") {
+			return nil, fmt.Errorf("registry %s needs username and password or identitytoken", h)
+		}
+		creds[h] = cred
+	}
+	return creds, nil
+}
+
+// merge adds the credentials of data, replacing those of the same hosts, which are returned
+func (s *RegistryAuthStore) merge(data []byte) (overridden []string, err error) {
+	creds, err := parseRegistryCredentials(data)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for host, cred := range creds {
+		if _, ok := s.creds[host]; ok {
+			overridden = append(overridden, host)
+		}
+		s.creds[host] = cred
+	}
+	sort.Strings(overridden)
+	return overridden, nil
+}
+
+// Hosts returns the sorted registry hosts credentials are configured for
+func (s *RegistryAuthStore) Hosts() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hosts := make([]string, 0, len(s.creds))
+	for host := range s.creds {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Replace swaps all credentials, persisting them to the registry_auth file when one is configured
+func (s *RegistryAuthStore) Replace(data []byte) ([]string, error) {
+	creds, err := parseRegistryCredentials(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.file != "" {
+		if err := s.save(creds); err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to persist registry credentials: %w", err)
+		}
+	}
+	s.creds = creds
+	s.mu.Unlock()
+
+	hosts := s.Hosts()
+	if s.file == "" {
+		s.log.Warn().Msg("registry_auth: credentials replaced in memory only, set registry_auth.file to keep them across restarts")
+	}
+	s.log.Info().Msgf("registry_auth: credentials replaced for %s", strings.Join(hosts, ", "))
+	return hosts, nil
+}
+
+// save writes the credentials atomically and readable only by the agent, mu must be held
+func (s *RegistryAuthStore) save(creds map[string]RegistryCredential) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0o700); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// Encoded returns the X-Registry-Auth value for pulling imageName, empty if the registry has no credentials
+func (s *RegistryAuthStore) Encoded(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", err
+	}
+	host := reference.Domain(named)
+
+	s.mu.RLock()
+	cred, ok := s.creds[host]
+	s.mu.RUnlock()
+	if !ok {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      cred.Username,
+		Password:      cred.Password,
+		IdentityToken: cred.IdentityToken,
+		ServerAddress: host,
+	})
+}
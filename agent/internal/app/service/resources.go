@@ -0,0 +1,302 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+
+	"a0/internal/config"
+)
+
+// parseUlimits converts {"nofile": "1024:65536"} ("soft[:hard]") into docker ulimits
+func parseUlimits(input map[string]string) ([]*container.Ulimit, error) {
+	values := make(map[string]string, len(input))
+	names := make([]string, 0, len(input))
+	for name, val := range input {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = val
+	}
+	sort.Strings(names)
+
+	ulimits := []*container.Ulimit{}
+	for _, name := range names {
+		val := strings.TrimSpace(values[name])
+		if val == "" {
+			continue
+		}
+		ulimit, err := units.ParseUlimit(fmt.Sprintf("%s=%s", name, val))
+		if err != nil {
+			return nil, err
+		}
+		ulimits = append(ulimits, ulimit)
+	}
+	return ulimits, nil
+}
+
+func formatUlimits(ulimits []*container.Ulimit) map[string]string {
+	if len(ulimits) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(ulimits))
+	for _, u := range ulimits {
+		out[u.Name] = fmt.Sprintf("%d:%d", u.Soft, u.Hard)
+	}
+	return out
+}
+
+// parseTmpfs converts "path[:options]" entries (e.g. "/tmp:rw,size=512m") into the docker tmpfs map
+func parseTmpfs(input []string) (map[string]string, error) {
+	tmpfs := map[string]string{}
+	for _, t := range input {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		path, opts, _ := strings.Cut(t, ":")
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid tmpfs mount %q: path must be absolute", t)
+		}
+		tmpfs[path] = opts
+	}
+	return tmpfs, nil
+}
+
+func formatTmpfs(tmpfs map[string]string) []string {
+	out := []string{}
+	for path, opts := range tmpfs {
+		if opts != "" {
+			path = path + ":" + opts
+		}
+		out = append(out, path)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseMemorySwap accepts a memory value or "-1" for unlimited swap
+func (s *ContainerService) parseMemorySwap(swap string) (int64, error) {
+	if strings.TrimSpace(swap) == "-1" {
+		return -1, nil
+	}
+	return s.parseMemoryLimitWithSanityCheck(swap)
+}
+
+func formatMemorySwap(bytes int64) string {
+	if bytes < 0 {
+		return "-1"
+	}
+	return formatMemory(bytes)
+}
+
+// parseCpuset validates a cpuset list like "0-3" or "0,2"
+func parseCpuset(cpuset string) (string, error) {
+	cpuset = strings.TrimSpace(cpuset)
+	if cpuset == "" {
+		return "", nil
+	}
+	for _, part := range strings.Split(cpuset, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(from)
+		if err != nil || a < 0 {
+			return "", fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+		if isRange {
+			b, err := strconv.Atoi(to)
+			if err != nil || b < a {
+				return "", fmt.Errorf("invalid cpuset %q", cpuset)
+			}
+		}
+	}
+	return cpuset, nil
+}
+
+// applyTemplateResources sets the extended resource and isolation knobs of the template, invalid values are skipped with a warning
+func (s *ContainerService) applyTemplateResources(tpl *config.ContainerTemplate, hostConfig *container.HostConfig) {
+	warn := func(field string, err error) {
+		s.log.Warn().Err(err).Msgf("container_template: ignoring invalid %s", field)
+	}
+
+	// set pids_limit
+	if tpl.PidsLimit != 0 {
+		pids := tpl.PidsLimit
+		hostConfig.Resources.PidsLimit = &pids
+	}
+
+	// set ulimits
+	if ulimits, err := parseUlimits(tpl.Ulimits); err != nil {
+		warn("ulimits", err)
+	} else if len(ulimits) > 0 {
+		hostConfig.Resources.Ulimits = ulimits
+	}
+
+	// set shm_size
+	if shm, err := s.parseMemoryLimitWithSanityCheck(tpl.ShmSize); err != nil {
+		warn("shm_size", err)
+	} else {
+		hostConfig.ShmSize = shm
+	}
+
+	// set memswap_limit
+	if swap, err := s.parseMemorySwap(tpl.MemswapLimit); err != nil {
+		warn("memswap_limit", err)
+	} else {
+		hostConfig.Resources.MemorySwap = swap
+	}
+
+	// set mem_reservation
+	if reservation, err := s.parseMemoryLimitWithSanityCheck(tpl.MemReservation); err != nil {
+		warn("mem_reservation", err)
+	} else {
+		hostConfig.Resources.MemoryReservation = reservation
+	}
+
+	// set cpu_shares
+	if tpl.CpuShares > 0 {
+		hostConfig.Resources.CPUShares = tpl.CpuShares
+	}
+
+	// set cpuset
+	if cpuset, err := parseCpuset(tpl.Cpuset); err != nil {
+		warn("cpuset", err)
+	} else {
+		hostConfig.Resources.CpusetCpus = cpuset
+	}
+
+	// set storage_opt
+	if len(tpl.StorageOpt) > 0 {
+		hostConfig.StorageOpt = map[string]string{}
+		for k, v := range tpl.StorageOpt {
+			hostConfig.StorageOpt[k] = v
+		}
+	}
+
+	// set tmpfs
+	if tmpfs, err := parseTmpfs(tpl.Tmpfs); err != nil {
+		warn("tmpfs", err)
+	} else if len(tmpfs) > 0 {
+		hostConfig.Tmpfs = tmpfs
+	}
+}
+
+// applyRequestResources overrides the extended resource and isolation knobs with the values of the create request.
+// templateMemory is the memory limit of the template, before the request changed it.
+func (s *ContainerService) applyRequestResources(req *CreateContainerRequest, hostConfig *container.HostConfig, templateMemory int64) error {
+	// set pids_limit
+	if req.PidsLimit < -1 {
+		return fmt.Errorf("pidsLimit must be -1 (unlimited) or greater")
+	}
+	if req.PidsLimit != 0 {
+		pids := req.PidsLimit
+		hostConfig.Resources.PidsLimit = &pids
+	}
+
+	// set ulimits, merged by name over the template
+	if len(req.Ulimits) > 0 {
+		ulimits, err := parseUlimits(req.Ulimits)
+		if err != nil {
+			return err
+		}
+		merged := map[string]*container.Ulimit{}
+		for _, u := range hostConfig.Resources.Ulimits {
+			merged[u.Name] = u
+		}
+		for _, u := range ulimits {
+			merged[u.Name] = u
+		}
+		hostConfig.Resources.Ulimits = []*container.Ulimit{}
+		for _, u := range merged {
+			hostConfig.Resources.Ulimits = append(hostConfig.Resources.Ulimits, u)
+		}
+		sort.Slice(hostConfig.Resources.Ulimits, func(i, j int) bool {
+			return hostConfig.Resources.Ulimits[i].Name < hostConfig.Resources.Ulimits[j].Name
+		})
+	}
+
+	// set shm_size
+	if req.ShmSize != "" {
+		shm, err := s.parseMemoryLimitWithSanityCheck(req.ShmSize)
+		if err != nil {
+			return fmt.Errorf("invalid shmSize: %w", err)
+		}
+		hostConfig.ShmSize = shm
+	}
+
+	// set memswap_limit
+	if req.MemorySwap != "" {
+		swap, err := s.parseMemorySwap(req.MemorySwap)
+		if err != nil {
+			return fmt.Errorf("invalid memorySwap: %w", err)
+		}
+		hostConfig.Resources.MemorySwap = swap
+	} else if swap := hostConfig.Resources.MemorySwap; swap > 0 && hostConfig.Resources.Memory != templateMemory {
+		// the template swap was meant for the template memory, keep its ratio or at least no swap
+		if templateMemory > 0 {
+			hostConfig.Resources.MemorySwap = scaledMemorySwap(templateMemory, swap, hostConfig.Resources.Memory)
+		} else if swap < hostConfig.Resources.Memory {
+			hostConfig.Resources.MemorySwap = hostConfig.Resources.Memory
+		}
+	}
+	if swap := hostConfig.Resources.MemorySwap; swap > 0 && swap < hostConfig.Resources.Memory {
+		return fmt.Errorf("memorySwap must be greater than or equal to memory")
+	}
+
+	// set mem_reservation
+	if req.MemoryReservation != "" {
+		reservation, err := s.parseMemoryLimitWithSanityCheck(req.MemoryReservation)
+		if err != nil {
+			return fmt.Errorf("invalid memoryReservation: %w", err)
+		}
+		hostConfig.Resources.MemoryReservation = reservation
+	}
+	if reservation := hostConfig.Resources.MemoryReservation; reservation > 0 && hostConfig.Resources.Memory > 0 && reservation > hostConfig.Resources.Memory {
+		return fmt.Errorf("memoryReservation must be less than or equal to memory")
+	}
+
+	// set cpu_shares
+	if req.CPUShares < 0 {
+		return fmt.Errorf("cpuShares must be non-negative")
+	}
+	if req.CPUShares > 0 {
+		hostConfig.Resources.CPUShares = req.CPUShares
+	}
+
+	// set cpuset
+	if req.CpusetCpus != "" {
+		cpuset, err := parseCpuset(req.CpusetCpus)
+		if err != nil {
+			return err
+		}
+		hostConfig.Resources.CpusetCpus = cpuset
+	}
+
+	// set storage size
+	if req.StorageSize != "" {
+		if hostConfig.StorageOpt == nil {
+			hostConfig.StorageOpt = map[string]string{}
+		}
+		hostConfig.StorageOpt["size"] = strings.TrimSpace(req.StorageSize)
+	}
+
+	// set tmpfs, merged by path over the template
+	if len(req.Tmpfs) > 0 {
+		tmpfs, err := parseTmpfs(req.Tmpfs)
+		if err != nil {
+			return err
+		}
+		if hostConfig.Tmpfs == nil {
+			hostConfig.Tmpfs = map[string]string{}
+		}
+		for path, opts := range tmpfs {
+			hostConfig.Tmpfs[path] = opts
+		}
+	}
+
+	return nil
+}
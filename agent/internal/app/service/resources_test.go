@@ -0,0 +1,126 @@
+package service
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestParseCpuset(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "  ", want: ""},
+		{in: "0", want: "0"},
+		{in: "0-3", want: "0-3"},
+		{in: "0,2", want: "0,2"},
+		{in: " 0-1,4 ", want: "0-1,4"},
+		{in: "3-3", want: "3-3"},
+		{in: "3-1", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "a", wantErr: true},
+		{in: "0,", wantErr: true},
+		{in: "0-", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCpuset(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCpuset(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCpuset(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseUlimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      map[string]string
+		want    []container.Ulimit
+		wantErr bool
+	}{
+		{name: "empty", in: nil, want: nil},
+		{name: "soft only", in: map[string]string{"nofile": "1024"}, want: []container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 1024}}},
+		{name: "soft and hard", in: map[string]string{"nofile": "1024:65536"}, want: []container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 65536}}},
+		{
+			name: "sorted by name and lower cased",
+			in:   map[string]string{"NPROC": "512", "core": "0"},
+			want: []container.Ulimit{{Name: "core", Soft: 0, Hard: 0}, {Name: "nproc", Soft: 512, Hard: 512}},
+		},
+		{name: "blank value skipped", in: map[string]string{"nofile": " "}, want: nil},
+		{name: "unknown name", in: map[string]string{"bogus": "1"}, wantErr: true},
+		{name: "soft above hard", in: map[string]string{"nofile": "2048:1024"}, wantErr: true},
+		{name: "not a number", in: map[string]string{"nofile": "many"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseUlimits(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseUlimits error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: parseUlimits returned %d ulimits, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if *got[i] != tt.want[i] {
+				t.Errorf("%s: ulimit %d = %+v, want %+v", tt.name, i, *got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", in: nil, want: map[string]string{}},
+		{name: "path only", in: []string{"/tmp"}, want: map[string]string{"/tmp": ""}},
+		{name: "with options", in: []string{"/tmp:rw,size=512m"}, want: map[string]string{"/tmp": "rw,size=512m"}},
+		{name: "blank skipped", in: []string{" ", "/run:size=64m "}, want: map[string]string{"/run": "size=64m"}},
+		{name: "later entry wins", in: []string{"/tmp:size=1m", "/tmp:size=2m"}, want: map[string]string{"/tmp": "size=2m"}},
+		{name: "relative path", in: []string{"tmp:size=1m"}, wantErr: true},
+		{name: "missing path", in: []string{":size=1m"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTmpfs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseTmpfs error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("%s: parseTmpfs = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScaledMemorySwap(t *testing.T) {
+	const g = 1024 * 1024 * 1024
+	tests := []struct {
+		name                          string
+		memory, memorySwap, newMemory int64
+		want                          int64
+	}{
+		{name: "no swap stays no swap", memory: 4 * g, memorySwap: 4 * g, newMemory: 8 * g, want: 8 * g},
+		{name: "double keeps double", memory: 4 * g, memorySwap: 8 * g, newMemory: 6 * g, want: 12 * g},
+		{name: "shrinking memory", memory: 8 * g, memorySwap: 12 * g, newMemory: 4 * g, want: 6 * g},
+		{name: "unknown memory", memory: 0, memorySwap: 8 * g, newMemory: 2 * g, want: 4 * g},
+	}
+	for _, tt := range tests {
+		if got := scaledMemorySwap(tt.memory, tt.memorySwap, tt.newMemory); got != tt.want {
+			t.Errorf("%s: scaledMemorySwap = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
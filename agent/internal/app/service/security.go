@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"a0/internal/app/xerror"
+	"a0/internal/config"
+)
+
+// defaultDeniedBinds are host paths users can never bind mount, nor any of their parents
+var defaultDeniedBinds = []string{
+	"/",
+	"/etc",
+	"/root",
+	"/boot",
+	"/proc",
+	"/sys",
+	"/dev",
+	"/run/docker.sock",
+	"/var/run/docker.sock",
+	"/var/lib/docker",
+}
+
+// readOnlyTmpfs are mounted as tmpfs when the root filesystem is read-only so the image can still start
+var readOnlyTmpfs = []string{"/tmp", "/run"}
+
+// checkBinds rejects binds whose host path is a denied path, is under one or would expose one as a parent.
+// Named volumes are not host paths and always pass.
+// The check is lexical: the agent may run in a container whose filesystem is not the docker host's, so symlinks
+// on the host can not be resolved here. Keep user writable host directories out of volume_prefixes for that reason.
+func (s *ContainerService) checkBinds(binds []string) error {
+	denied := append(append([]string{}, defaultDeniedBinds...), s.config.Security.DeniedBinds...)
+	for _, bind := range binds {
+		src, _, _ := strings.Cut(strings.TrimSpace(bind), ":")
+		if !strings.HasPrefix(src, "/") {
+			continue
+		}
+		host := path.Clean(src)
+		for _, d := range denied {
+			d = path.Clean(d)
+			under := d != "/" && strings.HasPrefix(host, d+"/")
+			parent := host == "/" || strings.HasPrefix(d, host+"/")
+			if host == d || under || parent {
+				return &xerror.ErrBindNotAllowed{Path: src}
+			}
+		}
+	}
+	return nil
+}
+
+// seccompProfile returns the security option value of a seccomp profile name.
+// Docker expects the profile content, so named profiles are read from the seccomp profile dir.
+func (s *ContainerService) seccompProfile(name string) (string, error) {
+	switch name {
+	case "", "default":
+		return "", nil
+	case "unconfined":
+		return name, nil
+	}
+
+	file := name
+	if !strings.HasPrefix(name, "/") {
+		if strings.Contains(name, "/") || strings.Contains(name, "..") {
+			return "", fmt.Errorf("invalid seccomp profile name %q", name)
+		}
+		dir := s.config.Security.SeccompProfileDir
+		if dir == "" {
+			return "", fmt.Errorf("seccomp profile %q needs security.seccomp_profile_dir", name)
+		}
+		file = filepath.Join(dir, name+".json")
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read seccomp profile: %w", err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, content); err != nil {
+		return "", fmt.Errorf("invalid seccomp profile %s: %w", file, err)
+	}
+	return compact.String(), nil
+}
+
+// applySecurityProfile sets capabilities, security options, read-only rootfs and userns mode of the template.
+// A broken profile fails the create instead of starting a container with less isolation than configured.
+func (s *ContainerService) applySecurityProfile(sec *config.SecurityProfile, hostConfig *container.HostConfig) error {
+	normalizeCaps := func(caps []string) []string {
+		out := []string{}
+		for _, c := range caps {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			if c != "" {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+
+	// set capabilities
+	hostConfig.CapDrop = normalizeCaps(sec.CapDrop)
+	hostConfig.CapAdd = normalizeCaps(sec.CapAdd)
+
+	// set security options
+	securityOpt := []string{}
+	if sec.NoNewPrivileges {
+		securityOpt = append(securityOpt, "no-new-privileges:true")
+	}
+	seccomp, err := s.seccompProfile(strings.TrimSpace(sec.Seccomp))
+	if err != nil {
+		return err
+	}
+	if seccomp != "" {
+		securityOpt = append(securityOpt, "seccomp="+seccomp)
+	}
+	if apparmor := strings.TrimSpace(sec.AppArmor); apparmor != "" {
+		securityOpt = append(securityOpt, "apparmor="+apparmor)
+	}
+	if len(securityOpt) > 0 {
+		hostConfig.SecurityOpt = securityOpt
+	}
+
+	// set read_only with writable tmpfs
+	if sec.ReadOnly {
+		hostConfig.ReadonlyRootfs = true
+		if hostConfig.Tmpfs == nil {
+			hostConfig.Tmpfs = map[string]string{}
+		}
+		for _, p := range readOnlyTmpfs {
+			if _, ok := hostConfig.Tmpfs[p]; !ok {
+				hostConfig.Tmpfs[p] = "rw,exec"
+			}
+		}
+	}
+
+	// set userns_mode
+	if mode := strings.TrimSpace(sec.UsernsMode); mode != "" {
+		usernsMode := container.UsernsMode(mode)
+		if !usernsMode.Valid() {
+			return fmt.Errorf("invalid userns_mode %q", mode)
+		}
+		hostConfig.UsernsMode = usernsMode
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"a0/internal/app/xerror"
+	"a0/internal/config"
+)
+
+func TestCheckBinds(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Security.DeniedBinds = []string{"/home", "/srv/secrets/"}
+	s := &ContainerService{config: cfg}
+
+	tests := []struct {
+		name    string
+		binds   []string
+		allowed bool
+	}{
+		{name: "no binds", binds: nil, allowed: true},
+		{name: "plain data dir", binds: []string{"/data/alice:/config/data"}, allowed: true},
+		{name: "read only option", binds: []string{"/data/alice:/config/data:ro"}, allowed: true},
+		{name: "named volume", binds: []string{"alice-home:/config"}, allowed: true},
+		{name: "prefix is not a parent", binds: []string{"/etcetera:/x", "/homework:/y"}, allowed: true},
+		{name: "root", binds: []string{"/:/host"}, allowed: false},
+		{name: "denied path", binds: []string{"/etc:/host-etc"}, allowed: false},
+		{name: "under denied path", binds: []string{"/etc/ssl:/ssl"}, allowed: false},
+		{name: "parent of denied path", binds: []string{"/var:/host-var"}, allowed: false},
+		{name: "docker socket", binds: []string{"/var/run/docker.sock:/var/run/docker.sock"}, allowed: false},
+		{name: "dot dot escape", binds: []string{"/data/../etc:/x"}, allowed: false},
+		{name: "trailing slash", binds: []string{"/root/:/x"}, allowed: false},
+		{name: "configured denied path", binds: []string{"/home/bob:/config/bob"}, allowed: false},
+		{name: "configured with trailing slash", binds: []string{"/srv/secrets:/s"}, allowed: false},
+		{name: "parent of configured path", binds: []string{"/srv:/s"}, allowed: false},
+		{name: "second bind denied", binds: []string{"/data/alice:/a", "/proc:/p"}, allowed: false},
+	}
+	for _, tt := range tests {
+		err := s.checkBinds(tt.binds)
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("%s: checkBinds(%v) = %v, want allowed", tt.name, tt.binds, err)
+			}
+			continue
+		}
+		var notAllowed *xerror.ErrBindNotAllowed
+		if !errors.As(err, &notAllowed) {
+			t.Errorf("%s: checkBinds(%v) = %v, want ErrBindNotAllowed", tt.name, tt.binds, err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package xerror
 
+import "fmt"
+
 type ErrInvalidUsername struct{}
 
 func (e *ErrInvalidUsername) Error() string {
@@ -65,3 +67,39 @@ type ErrJWTRefreshTokenExpired struct{}
 func (e *ErrJWTRefreshTokenExpired) Error() string {
 	return "error code: 011 - message: invalid credentials"
 }
+
+type ErrPathNotAllowed struct{}
+
+func (e *ErrPathNotAllowed) Error() string {
+	return "error code: 012 - message: path not allowed"
+}
+
+type ErrUploadTooLarge struct{}
+
+func (e *ErrUploadTooLarge) Error() string {
+	return "error code: 013 - message: upload too large"
+}
+
+type ErrBindNotAllowed struct {
+	Path string
+}
+
+func (e *ErrBindNotAllowed) Error() string {
+	return fmt.Sprintf("error code: 014 - message: bind not allowed: %s", e.Path)
+}
+
+// AdmissionViolation is a rule of the admission policy broken by a create request
+type AdmissionViolation struct {
+	Rule    string `json:"rule"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+type ErrAdmissionDenied struct {
+	Violations []AdmissionViolation
+}
+
+func (e *ErrAdmissionDenied) Error() string {
+	return fmt.Sprintf("error code: 015 - message: admission denied: %d violation(s)", len(e.Violations))
+}
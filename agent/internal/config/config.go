@@ -2,6 +2,9 @@ package config
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/spf13/viper"
 )
 
@@ -17,20 +20,52 @@ type Config struct {
 
 	} `mapstructure:"server"`
 
-	ContainerTemplate struct {
-		ImageName     string         `mapstructure:"image_name"`
-		ContainerName string         `mapstructure:"container_name"`
-		Restart       string         `mapstructure:"restart"`
-		Environment   map[string]any `mapstructure:"environment"`
-		Sysctls       map[string]any `mapstructure:"sysctls"`
-		Expose        []int          `mapstructure:"expose"`
-		MemLimit      string         `mapstructure:"mem_limit"`
-		Cpus          int            `mapstructure:"cpus"`
-		ExtraHost     []string       `mapstructure:"extra_host"`
-		Volumes       []string       `mapstructure:"volumes"`
-		Networks      map[string]any `mapstructure:"networks"`
-		Ports         []string       `mapstructure:"ports"`
-	} `mapstructure:"container_template"`
+	// ContainerTemplates are named container profiles, e.g. "python", "jdk8"
+	ContainerTemplates map[string]ContainerTemplate `mapstructure:"container_template"`
+	DefaultProfile     string                       `mapstructure:"default_profile"`
+
+	FileTransfer struct {
+		AllowedPaths  []string `mapstructure:"allowed_paths"`
+		MaxUploadSize string   `mapstructure:"max_upload_size"`
+	} `mapstructure:"file_transfer"`
+
+	// Security holds agent wide restrictions on user supplied container settings
+	Security struct {
+		// DeniedBinds are host paths that can not be bind mounted, added to the built-in list
+		DeniedBinds       []string `mapstructure:"denied_binds"`
+		SeccompProfileDir string   `mapstructure:"seccomp_profile_dir"`
+	} `mapstructure:"security"`
+
+	// Admission is checked against the final container config before it is created, empty rules allow anything
+	Admission struct {
+		Images         []string `mapstructure:"images"`     // regexes the whole image reference must match
+		Registries     []string `mapstructure:"registries"` // e.g. docker.io, registry.local:5000
+		MaxCpus        int      `mapstructure:"max_cpus"`
+		MaxMemory      string   `mapstructure:"max_memory"`
+		VolumePrefixes []string `mapstructure:"volume_prefixes"`  // allowed host path prefixes of binds
+		HostPortRanges []string `mapstructure:"host_port_ranges"` // "30000-40000" or "8080"
+		Networks       []string `mapstructure:"networks"`
+		Sysctls        []string `mapstructure:"sysctls"` // allowed keys, "net.ipv4.*" allows a prefix
+	} `mapstructure:"admission"`
+
+	// PortAllocator hands out host ports for "${port:name}" placeholders in template ports and env
+	PortAllocator struct {
+		Range     string `mapstructure:"range"` // e.g. "40000-49999"
+		StateFile string `mapstructure:"state_file"`
+	} `mapstructure:"port_allocator"`
+
+	// RegistryAuth holds pull credentials per registry host as JSON {"host": {"username": "...", "password": "..."}},
+	// read from the env variable and the file, the file wins per host; proxy-backend can replace them at runtime,
+	// they are then written to file
+	RegistryAuth struct {
+		File string `mapstructure:"file"`
+		Env  string `mapstructure:"env"` // default AGENT_REGISTRY_AUTH
+	} `mapstructure:"registry_auth"`
+
+	MemoryWatch struct {
+		PressurePercent float64 `mapstructure:"pressure_percent"`
+		IntervalSeconds int     `mapstructure:"interval_seconds"`
+	} `mapstructure:"memory_watch"`
 
 	AgentMetadata struct {
 		InstanceID    string         `mapstructure:"instance_id"`
@@ -52,6 +87,8 @@ type Config struct {
 		JWTIssuer     string `mapstructure:"jwt_issuer"`
 		JWTAudience   string `mapstructure:"jwt_audience"`
 		SessionSecret string `mapstructure:"session_secret"`
+		// SessionCookie is the session cookie of proxy-backend (APP_SESSION_COOKIE), it is not passed on to containers
+		SessionCookie string `mapstructure:"session_cookie"`
 	} `mapstructure:"secrets"`
 
 	Redis struct {
@@ -62,6 +99,100 @@ type Config struct {
 	} `mapstructure:"redis"`
 }
 
+// ContainerTemplate is a single named container profile
+type ContainerTemplate struct {
+	Description   string         `mapstructure:"description"`
+	ImageName     string         `mapstructure:"image_name"`
+	ContainerName string         `mapstructure:"container_name"`
+	Restart       string         `mapstructure:"restart"`
+	Environment   map[string]any `mapstructure:"environment"`
+	Sysctls       map[string]any `mapstructure:"sysctls"`
+	Expose        []int          `mapstructure:"expose"`
+	MemLimit      string         `mapstructure:"mem_limit"`
+	Cpus          int            `mapstructure:"cpus"`
+	ExtraHost     []string       `mapstructure:"extra_host"`
+	Volumes       []string       `mapstructure:"volumes"`
+	Networks      map[string]any `mapstructure:"networks"`
+	Ports         []string       `mapstructure:"ports"`
+	// ForwardPorts are the container ports users can declare for forwarding, "8501" or "8500-8599"
+	ForwardPorts []string `mapstructure:"forward_ports"`
+
+	PidsLimit      int64             `mapstructure:"pids_limit"`
+	Ulimits        map[string]string `mapstructure:"ulimits"` // name: "soft[:hard]"
+	ShmSize        string            `mapstructure:"shm_size"`
+	MemswapLimit   string            `mapstructure:"memswap_limit"` // "-1" for unlimited swap
+	MemReservation string            `mapstructure:"mem_reservation"`
+	CpuShares      int64             `mapstructure:"cpu_shares"`
+	Cpuset         string            `mapstructure:"cpuset"`
+	StorageOpt     map[string]string `mapstructure:"storage_opt"`
+	Tmpfs          []string          `mapstructure:"tmpfs"` // "path[:options]"
+
+	Security SecurityProfile `mapstructure:"security"`
+}
+
+// SecurityProfile hardens the containers of a template
+type SecurityProfile struct {
+	CapDrop         []string `mapstructure:"cap_drop"` // e.g. ["ALL"]
+	CapAdd          []string `mapstructure:"cap_add"`
+	NoNewPrivileges bool     `mapstructure:"no_new_privileges"`
+	ReadOnly        bool     `mapstructure:"read_only"` // read-only root filesystem, /tmp and /run get a tmpfs
+	Seccomp         string   `mapstructure:"seccomp"`   // "unconfined" or a profile name in seccomp_profile_dir
+	AppArmor        string   `mapstructure:"apparmor"`  // profile name loaded on the host
+	UsernsMode      string   `mapstructure:"userns_mode"`
+}
+
+// ProfileNames returns the sorted names of the container profiles
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.ContainerTemplates))
+	for name := range c.ContainerTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Template returns the named container profile. Empty name resolves to default_profile,
+// or to the first profile by name when no default is configured.
+func (c *Config) Template(name string) (string, *ContainerTemplate, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = strings.ToLower(c.DefaultProfile)
+	}
+	if name == "" {
+		names := c.ProfileNames()
+		if len(names) == 0 {
+			return "", nil, fmt.Errorf("no container profiles configured")
+		}
+		name = names[0]
+	}
+	tpl, ok := c.ContainerTemplates[name]
+	if !ok {
+		return "", nil, fmt.Errorf("container profile %q not found", name)
+	}
+	return name, &tpl, nil
+}
+
+// legacyProfile names the single container_template of configs written before profiles existed
+const legacyProfile = "default"
+
+// wrapLegacyTemplate turns a flat container_template (image_name directly under it) into the legacyProfile profile
+func wrapLegacyTemplate(v *viper.Viper) (*viper.Viper, error) {
+	if _, ok := v.Get("container_template.image_name").(string); !ok {
+		return v, nil
+	}
+	settings := v.AllSettings()
+	settings["container_template"] = map[string]any{legacyProfile: settings["container_template"]}
+	if v.GetString("default_profile") == "" {
+		settings["default_profile"] = legacyProfile
+	}
+
+	wrapped := viper.New()
+	if err := wrapped.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("error wrapping legacy container_template: %w", err)
+	}
+	return wrapped, nil
+}
+
 func LoadConfig(path string) (*Config, error) {
 	v := viper.New()
 	v.SetConfigFile(path)
@@ -70,6 +201,10 @@ func LoadConfig(path string) (*Config, error) {
 	if err := v.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
+	v, err := wrapLegacyTemplate(v)
+	if err != nil {
+		return nil, err
+	}
 
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
@@ -40,7 +40,7 @@ func (h *AuthHandler) GetLogin(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/csplatform/home")
 	}
 	if err.Error() == "sid not found" {
-		security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie)
+		security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie, h.authService.SessionCookieDomain)
 		return c.Redirect(http.StatusTemporaryRedirect, "/auth/login")
 	}
 	data := make(map[string]any)
@@ -60,7 +60,7 @@ func (h *AuthHandler) PostLogin(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/csplatform/home")
 	}
 	if err.Error() == "sid not found" {
-		security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie)
+		security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie, h.authService.SessionCookieDomain)
 		return c.Redirect(http.StatusTemporaryRedirect, "/auth/login")
 	}
 	username := c.FormValue("username")
@@ -108,7 +108,7 @@ func (h *AuthHandler) PostLogout(c echo.Context) error {
 	if err := h.authService.SessionDriver.RevokeByUserIDAndSessionID(ctx, username, sessionID); err != nil {
 		return err
 	}
-	security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie)
+	security.ExpireSessionCookie(c, h.withTLs, h.authService.SessionCookie, h.authService.SessionCookieDomain)
 	registrySessId := getSessionID(c)
 	h.codeServerSessionRegistry.CancellAll(registrySessId, false)
 	return c.Redirect(http.StatusFound, "/auth/login")
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +20,7 @@ import (
 
 	"v0/internal/app/service"
 	"v0/internal/config"
+	"v0/internal/utils"
 )
 
 type UserInfo struct {
@@ -27,7 +30,11 @@ type UserInfo struct {
 }
 
 type ContainerFormData struct {
+	Username            string
+	Workspace           string
 	Agent               string
+	Profile             string
+	ProfileOptions      []service.ContainerProfile
 	Image               string
 	Name                string
 	Memory              string
@@ -50,6 +57,38 @@ type ContainerFormData struct {
 	AllowEditEnv        bool
 	AllowEditSysctls    bool
 	JSON                template.JS
+
+	PidsLimit                  string
+	ShmSize                    string
+	MemorySwap                 string
+	MemoryReservation          string
+	CPUShares                  string
+	CpusetCpus                 string
+	StorageSize                string
+	AllowEditPidsLimit         bool
+	AllowEditUlimits           bool
+	AllowEditShmSize           bool
+	AllowEditMemorySwap        bool
+	AllowEditMemoryReservation bool
+	AllowEditCPUShares         bool
+	AllowEditCpuset            bool
+	AllowEditStorageSize       bool
+	AllowEditTmpfs             bool
+
+	Violations     []service.AdmissionViolation
+	IdempotencyKey string
+}
+
+// memoryOptions and cpuOptions are the values users can pick in the create and resize forms
+var (
+	memoryOptions  = []string{"2g", "4g", "8g", "16g", "32g"}
+	cpuOptions     = []string{"2", "4", "8", "16", "32"}
+	restartOptions = []string{"no", "always", "on-failure", "unless-stopped"}
+)
+
+// workspaceParam returns the workspace a request addresses by its workspace form or query value, the default one if unset
+func workspaceParam(c echo.Context) string {
+	return service.NormalizeWorkspace(c.FormValue("workspace"))
 }
 
 type ContainerHandler struct {
@@ -58,6 +97,8 @@ type ContainerHandler struct {
 	agentService *service.AgentService
 	log          zerolog.Logger
 	reg          *service.ContainerRegistryService
+	activity     *service.ActivityService
+	jobs         *service.CreateJobService
 	config       *config.AppConfig
 }
 
@@ -67,25 +108,90 @@ func NewContainerHandler(
 	agentService *service.AgentService,
 	log zerolog.Logger,
 	reg *service.ContainerRegistryService,
+	activity *service.ActivityService,
+	jobs *service.CreateJobService,
 	config *config.AppConfig,
 ) *ContainerHandler {
-	return &ContainerHandler{userInfoService, tmpl, agentService, log, reg, config}
+	return &ContainerHandler{userInfoService, tmpl, agentService, log, reg, activity, jobs, config}
+}
+
+// workspaceLimit returns how many workspaces a member of the groups can keep, the most generous group wins
+func workspaceLimit(cfg *config.AppConfig, groups []string) int {
+	limit := cfg.ContainerWorkspaceLimit
+	groupLimits := utils.ParseToIntMap(cfg.ContainerWorkspaceGroupLimits)
+	for _, group := range groups {
+		if l, ok := groupLimits[group]; ok && l > limit {
+			limit = l
+		}
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// checkWorkspaceLimit fails if the current user already keeps as many workspaces as allowed
+func (h *ContainerHandler) checkWorkspaceLimit(c echo.Context) error {
+	username := c.Get("username").(string)
+	workspaces, err := h.reg.List(context.Background(), username)
+	if err != nil {
+		return err
+	}
+	groups, _ := c.Get("groups").([]string)
+	if limit := workspaceLimit(h.config, groups); len(workspaces) >= limit {
+		return fmt.Errorf("%s already has %d of %d allowed workspaces", username, len(workspaces), limit)
+	}
+	return nil
+}
+
+// nfsHomeVolume is the NFS home mounted at /config, workspaces other than the default one get a folder of their own
+func (h *ContainerHandler) nfsHomeVolume(username, workspace string) string {
+	if workspace == service.DefaultWorkspace {
+		return fmt.Sprintf("%s/%s:/config", h.config.AppNFSHome, username)
+	}
+	return fmt.Sprintf("%s/workspaces/%s/%s:/config", h.config.AppNFSHome, username, workspace)
 }
 
 func (h *ContainerHandler) ShowFormCreate(c echo.Context) error {
 
-	// Check user have container or not
-	if resp, err := h.reg.Get(context.Background(), c.Get("username").(string)); err == nil {
+	// Check user can have another workspace
+	if err := h.checkWorkspaceLimit(c); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":   fmt.Sprintf("%s already have container", resp.User),
-			"details": fmt.Sprintf("Agent Host: %s Container Name: %s -> Created At %s", resp.AgentHost, resp.ContainerName, resp.CreatedAt),
+			"error": err.Error(),
 		})
 	}
 
+	// a running create job is followed instead of starting another one
+	if job, err := h.jobs.Active(context.Background(), c.Get("username").(string)); err == nil {
+		return c.Redirect(http.StatusFound, "/csplatform/containers/create/progress/"+job.ID)
+	}
+
+	var (
+		data *ContainerFormData
+		err  error
+	)
+	if jobID := c.QueryParam("job"); jobID != "" {
+		// edit and resend the request of a failed job
+		data, err = h.failedJobFormData(c, jobID)
+	} else {
+		data, err = h.createFormData(c, c.QueryParam("profile"), nil)
+	}
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	data.IdempotencyKey = newIdempotencyKey()
+
+	return h.tmpl.ExecuteTemplate(c.Response(), "container-home.go.tmpl", data)
+}
+
+// createFormData loads agents, profiles, defaults and edit policies of the create form.
+// submitted, if set, replaces the defaults with the values of a rejected request.
+func (h *ContainerHandler) createFormData(c echo.Context, profile string, submitted func(*service.GetContainerDefaultsResponse)) (*ContainerFormData, error) {
+
 	// --- Agent LB Selector ---
 	agentInfo, err := h.agentService.AgentLBSelector()
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to select agent: %v", err))
+		return nil, fmt.Errorf("Failed to select agent: %v", err)
 	}
 	agentURL := agentInfo.URL
 
@@ -93,7 +199,7 @@ func (h *ContainerHandler) ShowFormCreate(c echo.Context) error {
 	ctx := context.Background()
 	var agentOptions []string
 	if agents, err := h.agentService.RetrieveAllAgentData(ctx); err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to fetch agents: %v", err))
+		return nil, fmt.Errorf("Failed to fetch agents: %v", err)
 	} else {
 		for _, data := range agents {
 			agentHost := fmt.Sprintf("%s://%s", data.MainHostProto, data.MainHost)
@@ -102,9 +208,14 @@ func (h *ContainerHandler) ShowFormCreate(c echo.Context) error {
 	}
 	agentOptions = append(agentOptions, "Auto")
 
-	defaults, err := h.agentService.GetContainerDefaults(agentURL)
+	profiles, err := h.agentService.ListProfiles(agentURL)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to fetch defaults: %v", err))
+		return nil, fmt.Errorf("Failed to fetch profiles: %v", err)
+	}
+
+	defaults, err := h.agentService.GetContainerDefaults(agentURL, profile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch defaults: %v", err)
 	}
 
 	// edit policies
@@ -120,26 +231,49 @@ func (h *ContainerHandler) ShowFormCreate(c echo.Context) error {
 	defaults.AllowEditRestart = h.config.ContainerAllowEditRestart
 	defaults.AllowEditSysctls = h.config.ContainerAllowEditSysctls
 	defaults.AllowEditVolumes = h.config.ContainerAllowEditVolumes
-
-	// add nfs volume
+	defaults.AllowEditPidsLimit = h.config.ContainerAllowEditPidsLimit
+	defaults.AllowEditUlimits = h.config.ContainerAllowEditUlimits
+	defaults.AllowEditShmSize = h.config.ContainerAllowEditShmSize
+	defaults.AllowEditMemorySwap = h.config.ContainerAllowEditMemorySwap
+	defaults.AllowEditMemoryReservation = h.config.ContainerAllowEditMemoryReservation
+	defaults.AllowEditCPUShares = h.config.ContainerAllowEditCPUShares
+	defaults.AllowEditCpuset = h.config.ContainerAllowEditCpuset
+	defaults.AllowEditStorageSize = h.config.ContainerAllowEditStorageSize
+	defaults.AllowEditTmpfs = h.config.ContainerAllowEditTmpfs
+
+	// add nfs volume, it is moved to the folder of the workspace on submit
 	if h.config.AppNFSHome != "" {
-		defaults.Volumes = append(defaults.Volumes, fmt.Sprintf("%s/%s:/config", h.config.AppNFSHome, c.Get("username").(string)))
+		defaults.Volumes = append(defaults.Volumes, h.nfsHomeVolume(c.Get("username").(string), service.DefaultWorkspace))
+	}
+
+	// the default workspace is proposed until the user has it
+	workspace, name := service.DefaultWorkspace, service.WorkspaceContainerName(c.Get("username").(string), service.DefaultWorkspace)
+	if _, err := h.reg.Get(ctx, c.Get("username").(string), service.DefaultWorkspace); err == nil {
+		workspace, name = "", ""
+	}
+
+	if submitted != nil {
+		submitted(defaults)
 	}
 
 	// marshall data
 	jsonData, _ := json.Marshal(defaults)
 
 	data := ContainerFormData{
+		Username:            c.Get("username").(string),
+		Workspace:           workspace,
 		Agent:               "Auto",
+		Profile:             defaults.Profile,
+		ProfileOptions:      profiles,
 		Image:               defaults.Image,
-		Name:                fmt.Sprintf("code-server-%s", c.Get("username").(string)),
+		Name:                name,
 		Memory:              defaults.Memory,
 		CPUQuota:            fmt.Sprintf("%d", defaults.CPUQuota/1_000_000_000), // nano cpus
 		Restart:             defaults.Restart,
 		Network:             defaults.Network,
 		AgentOptions:        agentOptions,
-		MemoryOptions:       []string{"2g", "4g", "8g", "16g", "32g"},
-		CPUOptions:          []string{"2", "4", "8", "16", "32"},
+		MemoryOptions:       memoryOptions,
+		CPUOptions:          cpuOptions,
 		JSON:                template.JS(jsonData),
 		AllowEditImage:      defaults.AllowEditImage,
 		AllowEditName:       defaults.AllowEditName,
@@ -153,14 +287,34 @@ func (h *ContainerHandler) ShowFormCreate(c echo.Context) error {
 		AllowEditExtraHosts: defaults.AllowEditExtraHosts,
 		AllowEditEnv:        defaults.AllowEditEnv,
 		AllowEditSysctls:    defaults.AllowEditSysctls,
+
+		ShmSize:                    defaults.ShmSize,
+		MemorySwap:                 defaults.MemorySwap,
+		MemoryReservation:          defaults.MemoryReservation,
+		CpusetCpus:                 defaults.CpusetCpus,
+		StorageSize:                defaults.StorageSize,
+		AllowEditPidsLimit:         defaults.AllowEditPidsLimit,
+		AllowEditUlimits:           defaults.AllowEditUlimits,
+		AllowEditShmSize:           defaults.AllowEditShmSize,
+		AllowEditMemorySwap:        defaults.AllowEditMemorySwap,
+		AllowEditMemoryReservation: defaults.AllowEditMemoryReservation,
+		AllowEditCPUShares:         defaults.AllowEditCPUShares,
+		AllowEditCpuset:            defaults.AllowEditCpuset,
+		AllowEditStorageSize:       defaults.AllowEditStorageSize,
+		AllowEditTmpfs:             defaults.AllowEditTmpfs,
+	}
+	if defaults.PidsLimit != 0 {
+		data.PidsLimit = strconv.FormatInt(defaults.PidsLimit, 10)
+	}
+	if defaults.CPUShares != 0 {
+		data.CPUShares = strconv.FormatInt(defaults.CPUShares, 10)
 	}
 
-	return h.tmpl.ExecuteTemplate(c.Response(), "container-home.go.tmpl", data)
+	return &data, nil
 }
 
 func (h *ContainerHandler) StopContainer(c echo.Context) error {
-	ctx := context.Background()
-	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string))
+	cntInfo, err := userWorkspace(c, h.reg, service.GrantRoleManage)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to stop container: %v", err))
 	}
@@ -174,8 +328,7 @@ func (h *ContainerHandler) StopContainer(c echo.Context) error {
 }
 
 func (h *ContainerHandler) RestartContainer(c echo.Context) error {
-	ctx := context.Background()
-	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string))
+	cntInfo, err := userWorkspace(c, h.reg, service.GrantRoleManage)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to stop container: %v", err))
 	}
@@ -188,20 +341,27 @@ func (h *ContainerHandler) RestartContainer(c echo.Context) error {
 
 func (h *ContainerHandler) StartContainer(c echo.Context) error {
 	ctx := context.Background()
-	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string))
+	cntInfo, err := userWorkspace(c, h.reg, service.GrantRoleManage)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to stop container: %v", err))
 	}
+	if cntInfo.StopReason == service.StopReasonMigrating {
+		return c.String(http.StatusConflict, "Your container is being migrated to another host, please wait")
+	}
+	if cntInfo.StopReason == service.StopReasonRecreating {
+		return c.String(http.StatusConflict, "Your container is being upgraded, please wait")
+	}
 	_, err = h.agentService.StartContainer(cntInfo.AgentHost, cntInfo.ContainerName)
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to stop container: %v", err))
 	}
+	h.markStarted(ctx, cntInfo.User, cntInfo.Workspace)
 	return c.Redirect(302, "/csplatform/home")
 }
 
 func (h *ContainerHandler) RemoveContainer(c echo.Context) error {
 	ctx := context.Background()
-	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string))
+	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string), workspaceParam(c))
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to stop container: %v", err))
 	}
@@ -209,7 +369,7 @@ func (h *ContainerHandler) RemoveContainer(c echo.Context) error {
 	if err != nil {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to remove container: %v", err))
 	}
-	h.reg.Remove(ctx, c.Get("username").(string))
+	h.reg.Remove(ctx, cntInfo.User, cntInfo.Workspace)
 	return c.Redirect(302, "/csplatform/home")
 }
 
@@ -221,11 +381,36 @@ func (h *ContainerHandler) CreateContainerRequest(c echo.Context) error {
 
 	agentForm := c.FormValue("agent")
 	name := c.FormValue("name")
+	profile := c.FormValue("profile")
+	workspace := workspaceParam(c)
 
 	ctx := context.Background()
-	if _, err := h.reg.Get(ctx, c.Get("username").(string)); err == nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("User already have container: %s", name))
-	} else if err.Error() != "container not found" {
+
+	// a double submitted form follows the job of the first submit
+	if job, err := h.jobs.Existing(ctx, c.Get("username").(string), workspace, createIdempotencyKey(c)); err == nil {
+		return h.createJobAccepted(c, job)
+	} else if errors.Is(err, service.ErrCreateJobConflict) {
+		return c.String(http.StatusConflict, err.Error())
+	}
+
+	if err := service.ValidateWorkspaceName(workspace); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if _, err := h.reg.Get(ctx, c.Get("username").(string), workspace); err == nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("User already have workspace: %s", workspace))
+	} else if !errors.Is(err, service.ErrContainerNotFound) {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to get registry: %v", err))
+	}
+	if err := h.checkWorkspaceLimit(c); err != nil {
+		return c.String(http.StatusForbidden, err.Error())
+	}
+	if name == "" || !h.config.ContainerAllowEditName {
+		name = service.WorkspaceContainerName(c.Get("username").(string), workspace)
+	}
+	// "code-server-alice--dev" is the default workspace of "alice--dev" and the workspace "dev" of "alice"
+	if other, err := h.reg.FindByContainerName(ctx, "", name); err == nil {
+		return c.String(http.StatusConflict, fmt.Sprintf("Container name %s is used by workspace %s of %s", name, other.Workspace, other.User))
+	} else if !errors.Is(err, service.ErrContainerNotFound) {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to get registry: %v", err))
 	}
 
@@ -246,6 +431,16 @@ func (h *ContainerHandler) CreateContainerRequest(c echo.Context) error {
 	volumes := c.Request().Form["volumes[]"]       // []string
 	extraHosts := c.Request().Form["extraHosts[]"] // []string
 
+	// every workspace keeps its own home on the nfs
+	if h.config.AppNFSHome != "" && workspace != service.DefaultWorkspace {
+		defaultHome := h.nfsHomeVolume(c.Get("username").(string), service.DefaultWorkspace)
+		for i := range volumes {
+			if volumes[i] == defaultHome {
+				volumes[i] = h.nfsHomeVolume(c.Get("username").(string), workspace)
+			}
+		}
+	}
+
 	envKeys := c.Request().Form["env_key[]"]
 	envVals := c.Request().Form["env_val[]"]
 	sysctlsKeys := c.Request().Form["sysctls_key[]"]
@@ -333,6 +528,10 @@ func (h *ContainerHandler) CreateContainerRequest(c echo.Context) error {
 	}
 
 	containerData := map[string]interface{}{
+		"owner":      c.Get("username").(string),
+		"workspace":  workspace,
+		"createdBy":  c.Get("username").(string),
+		"profile":    profile,
 		"image":      image,
 		"name":       name,
 		"memory":     memory,
@@ -347,6 +546,17 @@ func (h *ContainerHandler) CreateContainerRequest(c echo.Context) error {
 		"sysctls":    sysctls,
 	}
 
+	// extended resource knobs, values the admin did not open for editing are left to the template
+	resources, err := h.createResourceOverrides(c.Request().Form)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	for k, v := range resources {
+		containerData[k] = v
+	}
+
 	for k, v := range containerData {
 		switch val := v.(type) {
 		case string:
@@ -393,30 +603,45 @@ func (h *ContainerHandler) CreateContainerRequest(c echo.Context) error {
 		}
 	}
 
-	// Create container with API request on agent
-	_, err = h.agentService.CreateContainer(agentURL, containerData)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to create container: %v", err))
+	// the form lists the profiles of the agent picked when it was rendered, it may differ from the agent selected now
+	if err := h.checkProfile(agentURL, profile); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
-	// Save Container info to redis
-	containerInfo := &service.ContainerInfo{
+	// Creation runs as a job, the page follows its progress
+	job, _, err := h.jobs.Submit(ctx, &service.CreateJobRequest{
 		User:          c.Get("username").(string),
-		ContainerName: name,
+		Workspace:     workspace,
 		AgentHost:     agentURL,
-	}
-	if err := h.reg.Add(ctx, containerInfo); err != nil {
-		h.log.Error().Err(err).Msgf("failed to save container-agent info for %s", name)
-		// Attempt to delete the container on the agent
-		if delResp, delErr := h.agentService.RemoveContainer(agentURL, name); delErr != nil {
-			h.log.Error().Err(delErr).Msgf("failed to rollback container %s on agent %s", name, agentURL)
-		} else {
-			h.log.Info().Msgf("Rollback success: %v", delResp)
-		}
+		ContainerName: name,
+		Profile:       profile,
+		Spec:          containerData,
+		Form:          createJobForm(c.Request().Form),
+	}, createIdempotencyKey(c))
+	if errors.Is(err, service.ErrCreateJobConflict) {
+		return c.String(http.StatusConflict, err.Error())
+	} else if err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to create container: %v", err))
 	}
 
-	return c.Redirect(302, "/csplatform/home")
+	return h.createJobAccepted(c, job)
+}
 
+// checkProfile returns an error when the agent does not have the profile, empty profile is the agent default.
+func (h *ContainerHandler) checkProfile(agentURL, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	profiles, err := h.agentService.ListProfiles(agentURL)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch profiles of %s: %v", agentURL, err)
+	}
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, profile) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Profile %q is not available on agent %s", profile, agentURL)
 }
 
 func (h *ContainerHandler) RenderContainerManager(c echo.Context) error {
@@ -508,7 +733,7 @@ func (h *ContainerHandler) IsContainerRunning(c echo.Context) error {
 	ctx := context.Background()
 	username := c.Param("username")
 
-	cntInfo, err := h.reg.Get(ctx, username)
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to stop container: %v", err),
@@ -526,6 +751,317 @@ func (h *ContainerHandler) IsContainerRunning(c echo.Context) error {
 }
 
 
+// submittedCreateForm copies the values of a submitted create form over the profile defaults
+func submittedCreateForm(form url.Values) func(*service.GetContainerDefaultsResponse) {
+	keyValues := func(prefix string) map[string]string {
+		keys, vals := form[prefix+"_key[]"], form[prefix+"_val[]"]
+		out := map[string]string{}
+		for i := range keys {
+			if i < len(vals) {
+				out[keys[i]] = vals[i]
+			}
+		}
+		return out
+	}
+	str := func(key string, dst *string) {
+		if v, ok := form[key]; ok && len(v) > 0 {
+			*dst = v[0]
+		}
+	}
+	num := func(key string, dst *int64) {
+		if v, err := strconv.ParseInt(form.Get(key), 10, 64); err == nil {
+			*dst = v
+		}
+	}
+
+	return func(d *service.GetContainerDefaultsResponse) {
+		str("image", &d.Image)
+		str("memory", &d.Memory)
+		str("restart", &d.Restart)
+		str("network", &d.Network)
+		if cpus, err := strconv.ParseInt(form.Get("cpuQuota"), 10, 64); err == nil {
+			d.CPUQuota = cpus * 1_000_000_000
+		}
+		d.Ports = form["ports[]"]
+		d.Expose = form["expose[]"]
+		d.Volumes = form["volumes[]"]
+		d.ExtraHosts = form["extraHosts[]"]
+		d.Env = keyValues("env")
+		d.Sysctls = keyValues("sysctls")
+
+		num("pidsLimit", &d.PidsLimit)
+		d.Ulimits = keyValues("ulimits")
+		str("shmSize", &d.ShmSize)
+		str("memorySwap", &d.MemorySwap)
+		str("memoryReservation", &d.MemoryReservation)
+		num("cpuShares", &d.CPUShares)
+		str("cpusetCpus", &d.CpusetCpus)
+		str("storageSize", &d.StorageSize)
+		d.Tmpfs = form["tmpfs[]"]
+	}
+}
+
+// createResourceOverrides reads the extended resource fields of the create form,
+// only the fields allowed by the edit policies are passed to the agent.
+func (h *ContainerHandler) createResourceOverrides(form url.Values) (map[string]any, error) {
+	overrides := map[string]any{}
+
+	if v := strings.TrimSpace(form.Get("pidsLimit")); v != "" && h.config.ContainerAllowEditPidsLimit {
+		pids, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pidsLimit value")
+		}
+		overrides["pidsLimit"] = pids
+	}
+
+	if h.config.ContainerAllowEditUlimits {
+		keys, vals := form["ulimits_key[]"], form["ulimits_val[]"]
+		ulimits := map[string]string{}
+		for i := range keys {
+			if i < len(vals) && strings.TrimSpace(keys[i]) != "" {
+				ulimits[strings.TrimSpace(keys[i])] = strings.TrimSpace(vals[i])
+			}
+		}
+		if len(ulimits) > 0 {
+			overrides["ulimits"] = ulimits
+		}
+	}
+
+	if v := strings.TrimSpace(form.Get("shmSize")); v != "" && h.config.ContainerAllowEditShmSize {
+		overrides["shmSize"] = v
+	}
+	if v := strings.TrimSpace(form.Get("memorySwap")); v != "" && h.config.ContainerAllowEditMemorySwap {
+		overrides["memorySwap"] = v
+	}
+	if v := strings.TrimSpace(form.Get("memoryReservation")); v != "" && h.config.ContainerAllowEditMemoryReservation {
+		overrides["memoryReservation"] = v
+	}
+
+	if v := strings.TrimSpace(form.Get("cpuShares")); v != "" && h.config.ContainerAllowEditCPUShares {
+		shares, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || shares < 0 {
+			return nil, fmt.Errorf("invalid cpuShares value")
+		}
+		overrides["cpuShares"] = shares
+	}
+
+	if v := strings.TrimSpace(form.Get("cpusetCpus")); v != "" && h.config.ContainerAllowEditCpuset {
+		overrides["cpusetCpus"] = v
+	}
+	if v := strings.TrimSpace(form.Get("storageSize")); v != "" && h.config.ContainerAllowEditStorageSize {
+		overrides["storageSize"] = v
+	}
+
+	if h.config.ContainerAllowEditTmpfs {
+		tmpfs := []string{}
+		for _, t := range form["tmpfs[]"] {
+			if t = strings.TrimSpace(t); t != "" {
+				tmpfs = append(tmpfs, t)
+			}
+		}
+		if len(tmpfs) > 0 {
+			overrides["tmpfs"] = tmpfs
+		}
+	}
+
+	return overrides, nil
+}
+
+// resizeRequest builds the agent update request from the submitted values and applies the edit policies.
+func (h *ContainerHandler) resizeRequest(memory, memorySwap, cpuQuota, restart string) (map[string]any, error) {
+	req := map[string]any{}
+
+	if memory != "" {
+		if !h.config.ContainerAllowEditMemory {
+			return nil, fmt.Errorf("editing memory is not allowed")
+		}
+		if !slices.Contains(memoryOptions, memory) {
+			return nil, fmt.Errorf("memory must be one of %s", strings.Join(memoryOptions, ", "))
+		}
+		req["memory"] = memory
+	}
+
+	if memorySwap != "" {
+		if !h.config.ContainerAllowEditMemory {
+			return nil, fmt.Errorf("editing memory is not allowed")
+		}
+		req["memorySwap"] = memorySwap
+	}
+
+	if cpuQuota != "" {
+		if !h.config.ContainerAllowEditCPU {
+			return nil, fmt.Errorf("editing cpu is not allowed")
+		}
+		if !slices.Contains(cpuOptions, cpuQuota) {
+			return nil, fmt.Errorf("cpuQuota must be one of %s", strings.Join(cpuOptions, ", "))
+		}
+		cpus, _ := strconv.ParseInt(cpuQuota, 10, 64)
+		req["cpuQuota"] = cpus
+	}
+
+	if restart != "" {
+		if !h.config.ContainerAllowEditRestart {
+			return nil, fmt.Errorf("editing restart policy is not allowed")
+		}
+		if !slices.Contains(restartOptions, restart) {
+			return nil, fmt.Errorf("restart must be one of %s", strings.Join(restartOptions, ", "))
+		}
+		req["restart"] = restart
+	}
+
+	if len(req) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+	return req, nil
+}
+
+// ResizeContainer changes cpu/memory of the current user's container without recreating it.
+func (h *ContainerHandler) ResizeContainer(c echo.Context) error {
+	ctx := context.Background()
+	cntInfo, err := h.reg.Get(ctx, c.Get("username").(string), workspaceParam(c))
+	if err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to resize container: %v", err))
+	}
+
+	req, err := h.resizeRequest(c.FormValue("memory"), "", c.FormValue("cpuQuota"), c.FormValue("restart"))
+	if err != nil {
+		return c.String(http.StatusForbidden, fmt.Sprintf("Failed to resize container: %v", err))
+	}
+
+	if _, err := h.agentService.UpdateContainer(cntInfo.AgentHost, cntInfo.ContainerName, req); err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to resize container: %v", err))
+	}
+	h.log.Info().Msgf("container %s resized by %s: %v", cntInfo.ContainerName, c.Get("username"), req)
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// ResizeContainerAPI changes cpu/memory of any registered container, for admins.
+func (h *ContainerHandler) ResizeContainerAPI(c echo.Context) error {
+	ctx := context.Background()
+	username := c.Param("username")
+
+	var body struct {
+		Memory     string `json:"memory" form:"memory"`
+		MemorySwap string `json:"memorySwap" form:"memorySwap"`
+		CPUQuota   string `json:"cpuQuota" form:"cpuQuota"`
+		Restart    string `json:"restart" form:"restart"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to resize container: %v", err),
+		})
+	}
+
+	req, err := h.resizeRequest(body.Memory, body.MemorySwap, body.CPUQuota, body.Restart)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": fmt.Sprintf("Failed to resize container: %v", err),
+		})
+	}
+
+	if _, err := h.agentService.UpdateContainer(cntInfo.AgentHost, cntInfo.ContainerName, req); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": fmt.Sprintf("Failed to resize container: %v", err),
+		})
+	}
+	h.log.Info().Msgf("container %s resized by %s: %v", cntInfo.ContainerName, c.Get("username"), req)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Container resized successfully for %s", username),
+	})
+}
+
+// ContainerStatus reports whether a workspace of or shared with the current user is running and code-server answers.
+func (h *ContainerHandler) ContainerStatus(c echo.Context) error {
+	cntInfo, err := userWorkspace(c, h.reg, service.GrantRoleUse)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	result, err := h.agentService.IsContainerReady(cntInfo.AgentHost, cntInfo.ContainerName, h.config.CodeServerBasePort)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// StreamLogs relays the live log stream of the current user's container.
+func (h *ContainerHandler) StreamLogs(c echo.Context) error {
+	cntInfo, err := h.reg.Get(context.Background(), c.Get("username").(string), workspaceParam(c))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return h.relayLogs(c, cntInfo)
+}
+
+// StreamLogsAPI relays the live log stream of any registered container, for admins.
+func (h *ContainerHandler) StreamLogsAPI(c echo.Context) error {
+	cntInfo, err := h.reg.Get(context.Background(), c.Param("username"), workspaceParam(c))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return h.relayLogs(c, cntInfo)
+}
+
+// relayLogs copies the agent event stream to the client until either side disconnects.
+func (h *ContainerHandler) relayLogs(c echo.Context, cntInfo *service.ContainerInfo) error {
+	tail := c.QueryParam("tail")
+	if tail == "" {
+		tail = "100"
+	}
+
+	// request context is cancelled when the client disconnects, which closes the agent stream
+	ctx := c.Request().Context()
+	body, err := h.agentService.StreamContainerLogs(ctx, cntInfo.AgentHost, cntInfo.ContainerName, tail)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": fmt.Sprintf("Failed to stream logs: %v", err),
+		})
+	}
+	defer body.Close()
+
+	h.log.Info().Msgf("log stream opened for %s by %s", cntInfo.ContainerName, c.Get("username"))
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := res.Write(buf[:n]); werr != nil {
+				break
+			}
+			res.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	h.log.Info().Msgf("log stream closed for %s by %s", cntInfo.ContainerName, c.Get("username"))
+	return nil
+}
+
 func appendSparkDriverHost(env map[string]string, sparkDriverHost string, keys ...string) {
     for _, key := range keys {
         prev := env[key]
@@ -544,7 +1080,7 @@ func (h *ContainerHandler) StopContainerAPI(c echo.Context) error {
 	ctx := context.Background()
 	username := c.Param("username")
 
-	cntInfo, err := h.reg.Get(ctx, username)
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to stop container: %v", err),
@@ -567,7 +1103,7 @@ func (h *ContainerHandler) RestartContainerAPI(c echo.Context) error {
 	ctx := context.Background()
 	username := c.Param("username")
 
-	cntInfo, err := h.reg.Get(ctx, username)
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to restart container: %v", err),
@@ -590,12 +1126,17 @@ func (h *ContainerHandler) StartContainerAPI(c echo.Context) error {
 	ctx := context.Background()
 	username := c.Param("username")
 
-	cntInfo, err := h.reg.Get(ctx, username)
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to start container: %v", err),
 		})
 	}
+	if cntInfo.StopReason == service.StopReasonMigrating || cntInfo.StopReason == service.StopReasonRecreating {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Container of %s is %s, start it when that is done", username, cntInfo.StopReason),
+		})
+	}
 
 	_, err = h.agentService.StartContainer(cntInfo.AgentHost, cntInfo.ContainerName)
 	if err != nil {
@@ -603,6 +1144,7 @@ func (h *ContainerHandler) StartContainerAPI(c echo.Context) error {
 			"error": fmt.Sprintf("Failed to start container: %v", err),
 		})
 	}
+	h.markStarted(ctx, cntInfo.User, cntInfo.Workspace)
 
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": fmt.Sprintf("Container started successfully for %s", username),
@@ -613,7 +1155,7 @@ func (h *ContainerHandler) RemoveContainerAPI(c echo.Context) error {
 	ctx := context.Background()
 	username := c.Param("username")
 
-	cntInfo, err := h.reg.Get(ctx, username)
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to remove container: %v", err),
@@ -627,7 +1169,7 @@ func (h *ContainerHandler) RemoveContainerAPI(c echo.Context) error {
 		})
 	}
 
-	if err := h.reg.Remove(ctx, username); err != nil {
+	if err := h.reg.Remove(ctx, cntInfo.User, cntInfo.Workspace); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to remove container registry entry: %v", err),
 		})
@@ -637,3 +1179,13 @@ func (h *ContainerHandler) RemoveContainerAPI(c echo.Context) error {
 		"message": fmt.Sprintf("Container removed successfully for %s", username),
 	})
 }
+
+// markStarted clears the recorded stop reason of the workspace and restarts its idle timer.
+func (h *ContainerHandler) markStarted(ctx context.Context, username, workspace string) {
+	if err := h.reg.SetStopReason(ctx, username, workspace, ""); err != nil {
+		h.log.Error().Err(err).Msgf("failed to clear stop reason for %s/%s", username, workspace)
+	}
+	if err := h.activity.Reset(ctx, service.WorkspaceID(username, workspace)); err != nil {
+		h.log.Error().Err(err).Msgf("failed to reset activity for %s", service.WorkspaceID(username, workspace))
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"v0/internal/app/service"
+)
+
+// createJobKeepAlive keeps proxies from closing an idle job stream
+const createJobKeepAlive = 15 * time.Second
+
+type CreateJobPageData struct {
+	JobID     string
+	StatusURL string
+	StreamURL string
+	FormURL   string
+	ReturnURL string
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// createIdempotencyKey returns the key of a create request, from the Idempotency-Key header or the form
+func createIdempotencyKey(c echo.Context) string {
+	if key := c.Request().Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return c.FormValue("idempotency_key")
+}
+
+// createJobFormFields are the create form fields kept with a job to fill the form again when it fails
+var createJobFormFields = []string{
+	"agent", "image", "memory", "cpuQuota", "restart", "network",
+	"ports[]", "expose[]", "volumes[]", "extraHosts[]", "sysctls_key[]", "sysctls_val[]",
+	"pidsLimit", "ulimits_key[]", "ulimits_val[]", "shmSize", "memorySwap", "memoryReservation",
+	"cpuShares", "cpusetCpus", "storageSize", "tmpfs[]",
+}
+
+// createJobForm is the part of the submitted form kept with the job. Env values may hold secrets,
+// only their keys are kept and the user fills the values in again.
+func createJobForm(form url.Values) url.Values {
+	out := url.Values{}
+	for _, k := range createJobFormFields {
+		if v, ok := form[k]; ok {
+			out[k] = v
+		}
+	}
+	if keys, ok := form["env_key[]"]; ok {
+		out["env_key[]"] = keys
+		out["env_val[]"] = make([]string, len(keys))
+	}
+	return out
+}
+
+// userCreateJob returns the job if it belongs to the current user
+func (h *ContainerHandler) userCreateJob(c echo.Context, id string) (*service.CreateJob, error) {
+	job, err := h.jobs.Get(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	if job.User != c.Get("username").(string) {
+		return nil, fmt.Errorf("create job not found")
+	}
+	return job, nil
+}
+
+// failedJobFormData fills the create form with the request of a failed job and its violations
+func (h *ContainerHandler) failedJobFormData(c echo.Context, id string) (*ContainerFormData, error) {
+	job, err := h.userCreateJob(c, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.createFormData(c, job.Profile, submittedCreateForm(job.Form))
+	if err != nil {
+		return nil, err
+	}
+	if agent := job.Form.Get("agent"); agent != "" {
+		data.Agent = agent
+	}
+	if job.ContainerName != "" {
+		data.Name = job.ContainerName
+	}
+	if job.Workspace != "" {
+		data.Workspace = job.Workspace
+	}
+	data.Violations = job.Violations
+	return data, nil
+}
+
+// createJobAccepted answers a create request with its job, API clients get the job and browsers the progress page
+func (h *ContainerHandler) createJobAccepted(c echo.Context, job *service.CreateJob) error {
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON) {
+		return c.JSON(http.StatusAccepted, job)
+	}
+	return c.Redirect(http.StatusSeeOther, "/csplatform/containers/create/progress/"+job.ID)
+}
+
+// ShowCreateJob renders the progress page of a create job of the current user.
+func (h *ContainerHandler) ShowCreateJob(c echo.Context) error {
+	job, err := h.userCreateJob(c, c.Param("id"))
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/csplatform/home")
+	}
+	base := "/csplatform/containers/create/jobs/" + job.ID
+	return h.tmpl.ExecuteTemplate(c.Response(), "container-create-job.go.tmpl", CreateJobPageData{
+		JobID:     job.ID,
+		StatusURL: base,
+		StreamURL: base + "/stream",
+		FormURL:   "/csplatform/containers/create?job=" + job.ID,
+		ReturnURL: "/csplatform/home",
+	})
+}
+
+// GetCreateJob returns the status of a create job of the current user.
+func (h *ContainerHandler) GetCreateJob(c echo.Context) error {
+	job, err := h.userCreateJob(c, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// GetCreateJobAPI returns the status of any create job, for admins.
+func (h *ContainerHandler) GetCreateJobAPI(c echo.Context) error {
+	job, err := h.jobs.Get(context.Background(), c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// StreamCreateJob sends every update of a create job of the current user as server-sent events
+// until the job is done or the client disconnects.
+func (h *ContainerHandler) StreamCreateJob(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// subscribe before reading the job so no update between the two is lost
+	sub := h.jobs.Subscribe(ctx, c.Param("id"))
+	defer sub.Close()
+
+	job, err := h.userCreateJob(c, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	send := func(data []byte) error {
+		if _, err := fmt.Fprintf(res, "event: job\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil
+	}
+	if err := send(data); err != nil || job.Done() {
+		return nil
+	}
+
+	keepAlive := time.NewTicker(createJobKeepAlive)
+	defer keepAlive.Stop()
+	updates := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case msg, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			var update service.CreateJob
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+				h.log.Warn().Err(err).Msgf("invalid create job update on %s", msg.Channel)
+				continue
+			}
+			if err := send([]byte(msg.Payload)); err != nil || update.Done() {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type ContainerEventHandler struct {
+	events *service.ContainerEventService
+	alerts *service.ContainerAlertService
+	log    zerolog.Logger
+}
+
+func NewContainerEventHandler(events *service.ContainerEventService, alerts *service.ContainerAlertService, log zerolog.Logger) *ContainerEventHandler {
+	return &ContainerEventHandler{events, alerts, log}
+}
+
+// Push receives a container lifecycle event from an agent
+func (h *ContainerEventHandler) Push(c echo.Context) error {
+	var ev service.ContainerEvent
+	if err := c.Bind(&ev); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if ev.ContainerName == "" || ev.Action == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "containerName and action are required")
+	}
+	ev.User = ""
+
+	ctx := context.Background()
+	if err := h.events.Record(ctx, &ev); err != nil {
+		// containers not in the registry (e.g. destroy after removal) are not tracked
+		if errors.Is(err, service.ErrContainerNotFound) {
+			h.log.Debug().Msgf("ignoring %s event of unregistered container %s", ev.Action, ev.ContainerName)
+			return c.JSON(http.StatusAccepted, map[string]string{"status": "ignored"})
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	h.log.Info().Msgf("container event from %s: %s %s %s", ev.InstanceID, ev.ContainerName, ev.Action, ev.Status)
+	return c.JSON(http.StatusOK, map[string]string{"status": "recorded"})
+}
+
+// ListEventsAPI returns the event timeline of a user's container
+func (h *ContainerEventHandler) ListEventsAPI(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	ctx := context.Background()
+	events, err := h.events.List(ctx, c.Param("username"), limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// PushAlert receives an OOM kill or memory pressure alert from an agent
+func (h *ContainerEventHandler) PushAlert(c echo.Context) error {
+	var alert service.MemoryAlert
+	if err := c.Bind(&alert); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if alert.ContainerName == "" || alert.Kind == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "containerName and kind are required")
+	}
+	alert.User = ""
+
+	ctx := context.Background()
+	if err := h.alerts.Record(ctx, &alert); err != nil {
+		if errors.Is(err, service.ErrContainerNotFound) {
+			h.log.Debug().Msgf("ignoring %s alert of unregistered container %s", alert.Kind, alert.ContainerName)
+			return c.JSON(http.StatusAccepted, map[string]string{"status": "ignored"})
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	h.log.Warn().Msgf("memory alert from %s: %s %s peak %.3f/%.3f GB",
+		alert.InstanceID, alert.ContainerName, alert.Kind, alert.PeakUsage, alert.MemoryLimit)
+	return c.JSON(http.StatusOK, map[string]string{"status": "recorded"})
+}
+
+// ListAlertsAPI returns the latest memory alerts of all users
+func (h *ContainerEventHandler) ListAlertsAPI(c echo.Context) error {
+	ctx := context.Background()
+	alerts, err := h.alerts.ListRecent(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, alerts)
+}
+
+// DismissAlerts hides the memory alert banner of the current user
+func (h *ContainerEventHandler) DismissAlerts(c echo.Context) error {
+	ctx := context.Background()
+	if err := h.alerts.Dismiss(ctx, c.Get("username").(string)); err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to dismiss alerts: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
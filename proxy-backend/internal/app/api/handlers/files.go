@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+	"v0/internal/config"
+)
+
+// defaultTransferDir is used when the agent does not report its allowed paths.
+const defaultTransferDir = "/config"
+
+type FileTransferHandler struct {
+	reg          *service.ContainerRegistryService
+	agentService *service.AgentService
+	config       *config.AppConfig
+	log          zerolog.Logger
+}
+
+func NewFileTransferHandler(
+	reg *service.ContainerRegistryService,
+	agentService *service.AgentService,
+	config *config.AppConfig,
+	log zerolog.Logger,
+) *FileTransferHandler {
+	return &FileTransferHandler{reg, agentService, config, log}
+}
+
+// MaxUploadBytes returns the configured upload limit in bytes.
+func (h *FileTransferHandler) MaxUploadBytes() int64 {
+	if h.config.ContainerFileUploadMaxMB <= 0 {
+		return 512 * 1024 * 1024
+	}
+	return int64(h.config.ContainerFileUploadMaxMB) * 1024 * 1024
+}
+
+// Upload stores the uploaded file into the container of the current user.
+func (h *FileTransferHandler) Upload(c echo.Context) error {
+	username := c.Get("username").(string)
+	if err := h.upload(c, username); err != nil {
+		return c.String(err.Code, fmt.Sprintf("Failed to upload file: %v", err.Message))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// UploadAPI stores the uploaded file into the container of any registered user, for admins.
+func (h *FileTransferHandler) UploadAPI(c echo.Context) error {
+	username := c.Param("username")
+	if err := h.upload(c, username); err != nil {
+		return c.JSON(err.Code, map[string]string{
+			"error": fmt.Sprintf("Failed to upload file: %v", err.Message),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("File uploaded successfully for %s", username),
+	})
+}
+
+// Download streams a directory or file of the current user's container as tar.gz.
+func (h *FileTransferHandler) Download(c echo.Context) error {
+	return h.download(c, c.Get("username").(string))
+}
+
+// DownloadAPI streams a directory or file of any registered container as tar.gz, for admins.
+func (h *FileTransferHandler) DownloadAPI(c echo.Context) error {
+	return h.download(c, c.Param("username"))
+}
+
+func (h *FileTransferHandler) upload(c echo.Context, username string) *echo.HTTPError {
+	ctx := context.Background()
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "file is required")
+	}
+	if fileHeader.Size > h.MaxUploadBytes() {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d MB", h.MaxUploadBytes()/1024/1024))
+	}
+	allowed := h.transferPaths(cntInfo.AgentHost)
+	destDir, ok := cleanTransferPath(c.FormValue("path"), allowed)
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "path must be under "+strings.Join(allowed, ", "))
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	defer file.Close()
+
+	fileName := path.Base(fileHeader.Filename)
+	if err := h.agentService.UploadFileToContainer(c.Request().Context(), cntInfo.AgentHost, cntInfo.ContainerName, destDir, fileName, fileHeader.Size, file); err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	h.log.Info().Msgf("file %s (%d bytes) uploaded to %s:%s by %s", fileName, fileHeader.Size, cntInfo.ContainerName, destDir, c.Get("username"))
+	return nil
+}
+
+func (h *FileTransferHandler) download(c echo.Context, username string) error {
+	ctx := context.Background()
+	cntInfo, err := h.reg.Get(ctx, username, workspaceParam(c))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	allowed := h.transferPaths(cntInfo.AgentHost)
+	srcPath, ok := cleanTransferPath(c.QueryParam("path"), allowed)
+	if !ok {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "path must be under " + strings.Join(allowed, ", ")})
+	}
+
+	body, disposition, err := h.agentService.DownloadFromContainer(c.Request().Context(), cntInfo.AgentHost, cntInfo.ContainerName, srcPath)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error": fmt.Sprintf("Failed to download: %v", err),
+		})
+	}
+	defer body.Close()
+
+	h.log.Info().Msgf("%s:%s downloaded by %s", cntInfo.ContainerName, srcPath, c.Get("username"))
+
+	if disposition == "" {
+		disposition = `attachment; filename="archive.tar.gz"`
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/gzip")
+	res.Header().Set(echo.HeaderContentDisposition, disposition)
+	res.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(res, body)
+	return nil
+}
+
+// transferPaths asks the agent for its file transfer allow list, agents without the endpoint only allow /config.
+func (h *FileTransferHandler) transferPaths(agentHost string) []string {
+	allowed, err := h.agentService.ListTransferPaths(agentHost)
+	if err != nil || len(allowed) == 0 {
+		if err != nil {
+			h.log.Warn().Err(err).Msgf("failed to get transfer paths of %s, using %s", agentHost, defaultTransferDir)
+		}
+		return []string{defaultTransferDir}
+	}
+	return allowed
+}
+
+// cleanTransferPath resolves empty and relative paths against the first allowed path and rejects paths outside all of them.
+// The agent enforces the same allow list again.
+func cleanTransferPath(p string, allowed []string) (string, bool) {
+	if strings.TrimSpace(p) == "" {
+		return allowed[0], true
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = allowed[0] + "/" + p
+	}
+	clean := path.Clean(p)
+	for _, prefix := range allowed {
+		if clean == prefix || strings.HasPrefix(clean, prefix+"/") {
+			return clean, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+	"v0/internal/app/xsession"
+)
+
+// sharedConnPrefix marks connections into workspaces of other users in the code-server session registry,
+// it is followed by the WorkspaceID and " | "
+const sharedConnPrefix = "shared "
+
+func sharedConnID(info *service.ContainerInfo, redisSessionID string, req *http.Request) string {
+	return fmt.Sprintf("%s%s | %s | %s | %d", sharedConnPrefix, service.WorkspaceID(info.User, info.Workspace), redisSessionID, req.URL.RequestURI(), time.Now().UnixNano())
+}
+
+// userWorkspace returns the workspace a user request addresses: one of the user's own, or with the owner
+// form value one of another user that granted the user at least role. Workspaces without access are not found.
+func userWorkspace(c echo.Context, reg *service.ContainerRegistryService, role string) (*service.ContainerInfo, error) {
+	username := c.Get("username").(string)
+	owner := strings.TrimSpace(c.FormValue("owner"))
+	if owner == "" || owner == username {
+		return reg.Get(context.Background(), username, workspaceParam(c))
+	}
+	info, err := reg.Get(context.Background(), owner, workspaceParam(c))
+	if err != nil {
+		return nil, err
+	}
+	groups, _ := c.Get("groups").([]string)
+	if !info.HasRole(username, groups, role) {
+		return nil, service.ErrContainerNotFound
+	}
+	return info, nil
+}
+
+// grantee splits a grantee of a form, @name is a group and anything else a user
+func grantee(value string) (user, group string) {
+	value = strings.TrimSpace(value)
+	if g, ok := strings.CutPrefix(value, "@"); ok {
+		return "", g
+	}
+	return value, ""
+}
+
+type GrantHandler struct {
+	reg      *service.ContainerRegistryService
+	sessions *xsession.CodeServerSessionRegistry
+	log      zerolog.Logger
+}
+
+func NewGrantHandler(reg *service.ContainerRegistryService, sessions *xsession.CodeServerSessionRegistry, log zerolog.Logger) *GrantHandler {
+	return &GrantHandler{reg, sessions, log}
+}
+
+// cancelSharedConns closes the open connections into the workspace of the grantee of a revoked grant.
+// A group grant closes the connections of all grantees, the ones still allowed reconnect.
+func (h *GrantHandler) cancelSharedConns(owner, workspace string, grant *service.WorkspaceGrant) int {
+	prefix := sharedConnPrefix + service.WorkspaceID(owner, workspace) + " | "
+	return h.sessions.CancelMatching(func(sessionID, connID string) bool {
+		if grant.User != "" && sessionID != "u:"+grant.User {
+			return false
+		}
+		return strings.HasPrefix(connID, prefix)
+	})
+}
+
+func (h *GrantHandler) revoke(ctx context.Context, owner, workspace, user, group string) (*service.WorkspaceGrant, error) {
+	grant, err := h.reg.Revoke(ctx, owner, workspace, user, group)
+	if err != nil {
+		return nil, err
+	}
+	if n := h.cancelSharedConns(owner, workspace, grant); n > 0 {
+		h.log.Info().Msgf("closed %d connections of %s into %s", n, grant.Subject(), service.WorkspaceID(owner, workspace))
+	}
+	return grant, nil
+}
+
+// GrantWorkspace gives a user, or a group written as @group, a role on a workspace of the current user.
+func (h *GrantHandler) GrantWorkspace(c echo.Context) error {
+	username := c.Get("username").(string)
+	user, group := grantee(c.FormValue("grantee"))
+	_, err := h.reg.Grant(context.Background(), username, workspaceParam(c), service.WorkspaceGrant{
+		User:      user,
+		Group:     group,
+		Role:      c.FormValue("role"),
+		GrantedBy: username,
+	})
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to share workspace: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// RevokeWorkspace removes a grant from a workspace of the current user and closes the open connections of the grantee.
+func (h *GrantHandler) RevokeWorkspace(c echo.Context) error {
+	user, group := grantee(c.FormValue("grantee"))
+	if _, err := h.revoke(context.Background(), c.Get("username").(string), workspaceParam(c), user, group); err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to revoke access: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// ListGrantsAPI returns the grants of a workspace of :username, ?workspace= selects it
+func (h *GrantHandler) ListGrantsAPI(c echo.Context) error {
+	info, err := h.reg.Get(context.Background(), c.Param("username"), workspaceParam(c))
+	if err != nil {
+		if errors.Is(err, service.ErrContainerNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	grants := info.Grants
+	if grants == nil {
+		grants = []service.WorkspaceGrant{}
+	}
+	return c.JSON(http.StatusOK, grants)
+}
+
+// GrantAPI gives a user or a group a role on a workspace of :username, for admins.
+// Body: {"workspace": "<name>", "user": "<user>", "group": "<group>", "role": "use|manage"}, one of user and group
+func (h *GrantHandler) GrantAPI(c echo.Context) error {
+	var body struct {
+		Workspace string `json:"workspace" form:"workspace"`
+		User      string `json:"user" form:"user"`
+		Group     string `json:"group" form:"group"`
+		Role      string `json:"role" form:"role"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	grant, err := h.reg.Grant(context.Background(), c.Param("username"), body.Workspace, service.WorkspaceGrant{
+		User:      body.User,
+		Group:     body.Group,
+		Role:      body.Role,
+		GrantedBy: requestedBy,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, grant)
+}
+
+// RevokeAPI removes the grant of ?user= or ?group= from a workspace of :username and closes the open
+// connections of the grantee, for admins.
+func (h *GrantHandler) RevokeAPI(c echo.Context) error {
+	grant, err := h.revoke(context.Background(), c.Param("username"), workspaceParam(c), c.QueryParam("user"), c.QueryParam("group"))
+	if err != nil {
+		if errors.Is(err, service.ErrGrantNotFound) || errors.Is(err, service.ErrContainerNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, grant)
+}
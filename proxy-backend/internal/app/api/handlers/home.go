@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"html/template"
 	"net/http"
 
@@ -13,6 +14,23 @@ import (
 	"v0/internal/config"
 )
 
+// HomeWorkspace is a workspace of or shared with the user as listed on the home page
+type HomeWorkspace struct {
+	service.ContainerInfo
+	IsContainerRunning bool
+	Recreate           *service.Recreate
+	Role               string
+	Ports              []HomePort
+}
+
+// HomePort is a declared port of a workspace, Path is the URL the owner reaches it on and Link a new public link for public ones
+type HomePort struct {
+	service.ForwardedPort
+	Visibility service.PortVisibility
+	Path       string
+	Link       string
+}
+
 type HomePageHandler struct {
 	jwtService   *security.JWTService
 	tmpl         *template.Template
@@ -20,6 +38,10 @@ type HomePageHandler struct {
 	log          zerolog.Logger
 	agentService *service.AgentService
 	reg          *service.ContainerRegistryService
+	events       *service.ContainerEventService
+	alerts       *service.ContainerAlertService
+	recreates    *service.RecreateService
+	portTokens   *security.PortTokenService
 }
 
 func NewHomePageHandler(
@@ -29,8 +51,29 @@ func NewHomePageHandler(
 	log zerolog.Logger,
 	agentService *service.AgentService,
 	reg *service.ContainerRegistryService,
+	events *service.ContainerEventService,
+	alerts *service.ContainerAlertService,
+	recreates *service.RecreateService,
+	portTokens *security.PortTokenService,
 ) *HomePageHandler {
-	return &HomePageHandler{jwtService, tmpl, config, log, agentService, reg}
+	return &HomePageHandler{jwtService, tmpl, config, log, agentService, reg, events, alerts, recreates, portTokens}
+}
+
+// forwardedPortURL is where the owner reaches a declared port, on its subdomain when subdomain routing is on
+// and the user name fits into a host
+func (h *HomePageHandler) forwardedPortURL(info *service.ContainerInfo, fp service.ForwardedPort) string {
+	if h.config.PortSubdomainBaseDomain == "" {
+		return info.ForwardedPortPath(fp)
+	}
+	host, ok := info.ForwardedPortHost(fp, h.config.PortSubdomainBaseDomain)
+	if !ok {
+		return info.ForwardedPortPath(fp)
+	}
+	scheme := "http"
+	if h.config.AppWithTLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/", scheme, host)
 }
 
 func (h *HomePageHandler) RenderHomePage(c echo.Context) error {
@@ -38,26 +81,60 @@ func (h *HomePageHandler) RenderHomePage(c echo.Context) error {
 	data["Username"] = c.Get("username").(string)
 	data["CSRFToken"] = c.Get("csrf").(string)
 	data["AdminGroup"] = h.config.AuthAdminRoles
+	data["AllowEditMemory"] = h.config.ContainerAllowEditMemory
+	data["AllowEditCPU"] = h.config.ContainerAllowEditCPU
+	data["MemoryOptions"] = memoryOptions
+	data["CPUOptions"] = cpuOptions
 	groupsFromCtx := c.Get("groups")
-	if groups, err := h.jwtService.ClaimToStringSlice(groupsFromCtx); err == nil {
+	groups, err := h.jwtService.ClaimToStringSlice(groupsFromCtx)
+	if err == nil {
 		data["Groups"] = groups
 	}
 
-	// check has container and is running
+	// list the workspaces and whether they are running
 	ctx := context.Background()
-	if resp, err := h.reg.Get(ctx, data["Username"].(string)); err == nil {
-		data["HasContainer"] = true
-		data["AgentHost"] = resp.AgentHost
-		data["CreatedAt"] = resp.CreatedAt
-		if resp, err := h.agentService.IsContainerRunning(resp.AgentHost, resp.ContainerName); err == nil {
-			data["IsContainerRunning"] = resp.Running
+	containers, err := h.reg.List(ctx, data["Username"].(string))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	workspaces := []HomeWorkspace{}
+	for _, cnt := range containers {
+		ws := HomeWorkspace{ContainerInfo: cnt}
+		if resp, err := h.agentService.IsContainerRunning(cnt.AgentHost, cnt.ContainerName); err == nil {
+			ws.IsContainerRunning = resp.Running
+		}
+		if recreate, err := h.recreates.Get(ctx, cnt.User, cnt.Workspace); err == nil {
+			ws.Recreate = recreate
+		}
+		for _, fp := range cnt.ForwardedPorts {
+			ws.Ports = append(ws.Ports, HomePort{fp, cnt.PortVisibilityOf(fp.Port), h.forwardedPortURL(&cnt, fp), publicPortLink(h.portTokens, &cnt, fp.Port)})
 		}
+		workspaces = append(workspaces, ws)
+	}
+	data["Workspaces"] = workspaces
 
-	} else if err.Error() == "container not found" {
-		data["HasContainer"] = false
-		data["IsContainerRunning"] = false
-	} else {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	shared := []HomeWorkspace{}
+	if containers, err := h.reg.SharedWith(ctx, data["Username"].(string), groups); err == nil {
+		for _, cnt := range containers {
+			ws := HomeWorkspace{ContainerInfo: cnt, Role: cnt.RoleOf(data["Username"].(string), groups)}
+			if resp, err := h.agentService.IsContainerRunning(cnt.AgentHost, cnt.ContainerName); err == nil {
+				ws.IsContainerRunning = resp.Running
+			}
+			shared = append(shared, ws)
+		}
+	}
+	data["SharedWorkspaces"] = shared
+	data["HasContainer"] = len(workspaces) > 0
+	data["CanCreateWorkspace"] = len(workspaces) < workspaceLimit(h.config, groups)
+	data["PublicLinksEnabled"] = h.portTokens.LinksEnabled()
+
+	if len(workspaces) > 0 {
+		if events, err := h.events.List(ctx, data["Username"].(string), 10); err == nil {
+			data["Events"] = events
+		}
+		if alerts, err := h.alerts.Active(ctx, data["Username"].(string)); err == nil {
+			data["Alerts"] = alerts
+		}
 	}
 
 	return h.tmpl.ExecuteTemplate(c.Response(), "home.go.tmpl", data)
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type ImageHandler struct {
+	prepull      *service.ImagePrepullService
+	registryAuth *service.RegistryAuthService
+	log          zerolog.Logger
+}
+
+func NewImageHandler(prepull *service.ImagePrepullService, registryAuth *service.RegistryAuthService, log zerolog.Logger) *ImageHandler {
+	return &ImageHandler{prepull, registryAuth, log}
+}
+
+// PrepullAPI starts pulling images on agents ahead of use.
+// Body: {"images": ["repo/image:tag"], "agents": ["http://agent:port"]}, no images pulls the
+// profile images of each agent and no agents uses all registered agents.
+func (h *ImageHandler) PrepullAPI(c echo.Context) error {
+	var body struct {
+		Images []string `json:"images" form:"images"`
+		Agents []string `json:"agents" form:"agents"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	p, err := h.prepull.Start(context.Background(), body.Images, body.Agents, requestedBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, p)
+}
+
+// PrepullStatusAPI returns the status table of pre-pull :id, "latest" is the last started run
+func (h *ImageHandler) PrepullStatusAPI(c echo.Context) error {
+	ctx := context.Background()
+	var (
+		p   *service.Prepull
+		err error
+	)
+	if id := c.Param("id"); id == "latest" {
+		p, err = h.prepull.Latest(ctx)
+	} else {
+		p, err = h.prepull.Get(ctx, id)
+	}
+	if err != nil {
+		if err.Error() == "prepull not found" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// RegistryCredentialsAPI lists the registry hosts every agent has credentials for
+func (h *ImageHandler) RegistryCredentialsAPI(c echo.Context) error {
+	results, err := h.registryAuth.List(context.Background(), c.QueryParams()["agent"])
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// PushRegistryCredentialsAPI replaces the registry credentials of agents without restarting them.
+// Body: {"registries": {"registry.local:5000": {"username": "...", "password": "..."}}, "agents": [...]},
+// no registries pushes REGISTRY_AUTH_FILE and no agents pushes to all registered agents.
+func (h *ImageHandler) PushRegistryCredentialsAPI(c echo.Context) error {
+	var body struct {
+		Registries map[string]service.RegistryCredential `json:"registries"`
+		Agents     []string                              `json:"agents"`
+	}
+	// bind errors are not passed on, they may quote the credentials
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid body"})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	results, err := h.registryAuth.Push(context.Background(), body.Registries, body.Agents, requestedBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type MigrationHandler struct {
+	migrations *service.MigrationService
+	log        zerolog.Logger
+}
+
+func NewMigrationHandler(migrations *service.MigrationService, log zerolog.Logger) *MigrationHandler {
+	return &MigrationHandler{migrations, log}
+}
+
+// MigrateAPI starts moving a workspace of :username to another agent.
+// Body: {"target": "<agent url>|auto", "keep_source": false, "workspace": "<name>"}, empty workspace is the default one
+func (h *MigrationHandler) MigrateAPI(c echo.Context) error {
+	var body struct {
+		Target     string `json:"target" form:"target"`
+		KeepSource bool   `json:"keep_source" form:"keep_source"`
+		Workspace  string `json:"workspace" form:"workspace"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	ctx := context.Background()
+	m, err := h.migrations.Start(ctx, c.Param("username"), body.Workspace, body.Target, body.KeepSource, requestedBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, m)
+}
+
+// MigrationStatusAPI returns the progress of the last migration of a workspace of :username, ?workspace= selects it
+func (h *MigrationHandler) MigrationStatusAPI(c echo.Context) error {
+	ctx := context.Background()
+	m, err := h.migrations.Get(ctx, c.Param("username"), workspaceParam(c))
+	if err != nil {
+		if err.Error() == "migration not found" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, m)
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/security"
+	"v0/internal/app/service"
+	"v0/internal/app/xsession"
+	"v0/internal/config"
+	"v0/internal/utils"
+)
+
+// portConnPrefix marks connections to forwarded ports of other users reached through the port visibility,
+// it is followed by the WorkspaceID, " | ", the port and " | "
+const portConnPrefix = "port "
+
+func portConnID(info *service.ContainerInfo, port int, redisSessionID string, req *http.Request) string {
+	return fmt.Sprintf("%s%s | %d | %s | %s | %d", portConnPrefix, service.WorkspaceID(info.User, info.Workspace), port, redisSessionID, req.URL.RequestURI(), time.Now().UnixNano())
+}
+
+// publicPortLink returns the path of a new public link to a port, empty if the port is not declared and public or links are disabled
+func publicPortLink(portTokens *security.PortTokenService, info *service.ContainerInfo, port int) string {
+	fp := info.ForwardedPortOf(port)
+	v := info.PortVisibilityOf(port)
+	if fp == nil || v.Mode != service.PortPublic || !portTokens.LinksEnabled() {
+		return ""
+	}
+	token, _, err := portTokens.CreatePortLink(security.PortLink{
+		User:      info.User,
+		Workspace: info.Workspace,
+		Protocol:  fp.Protocol,
+		Port:      port,
+		Version:   v.UpdatedAt,
+	})
+	if err != nil {
+		return ""
+	}
+	return "/public/" + token + "/"
+}
+
+type PortHandler struct {
+	reg          *service.ContainerRegistryService
+	agentService *service.AgentService
+	portTokens   *security.PortTokenService
+	sessions     *xsession.CodeServerSessionRegistry
+	config       *config.AppConfig
+	log          zerolog.Logger
+}
+
+func NewPortHandler(
+	reg *service.ContainerRegistryService,
+	agentService *service.AgentService,
+	portTokens *security.PortTokenService,
+	sessions *xsession.CodeServerSessionRegistry,
+	config *config.AppConfig,
+	log zerolog.Logger,
+) *PortHandler {
+	return &PortHandler{reg, agentService, portTokens, sessions, config, log}
+}
+
+// allowedPort checks a port a user wants to declare against the forward_ports of the container template
+// of the workspace and the CONTAINER_FORWARD_PORT_GROUP_RANGES of the user's groups
+func (h *PortHandler) allowedPort(info *service.ContainerInfo, groups []string, port int) error {
+	groupRanges := utils.ParseToListMap(h.config.ContainerForwardPortGroupRanges)
+	for _, g := range groups {
+		if service.PortInRanges(port, groupRanges[g]) {
+			return nil
+		}
+	}
+	profiles, err := h.agentService.ListProfiles(info.AgentHost)
+	if err != nil {
+		return fmt.Errorf("failed to list profiles: %w", err)
+	}
+	for _, p := range profiles {
+		if (p.Name == info.Profile || (info.Profile == "" && p.Default)) && service.PortInRanges(port, p.ForwardPorts) {
+			return nil
+		}
+	}
+	return fmt.Errorf("port %d can not be forwarded", port)
+}
+
+// declare declares a port unless it is the code-server port, which is never forwarded
+func (h *PortHandler) declare(ctx context.Context, owner, workspace string, fp service.ForwardedPort) (*service.ForwardedPort, error) {
+	if fp.Port == h.config.CodeServerBasePort {
+		return nil, fmt.Errorf("port %d is the code-server port", fp.Port)
+	}
+	return h.reg.DeclarePort(ctx, owner, workspace, fp)
+}
+
+// cancelPortConns closes the connections of other users to a port opened through its visibility
+func (h *PortHandler) cancelPortConns(owner, workspace string, port int) {
+	prefix := fmt.Sprintf("%s%s | %d | ", portConnPrefix, service.WorkspaceID(owner, workspace), port)
+	if n := h.sessions.CancelMatching(func(_, connID string) bool { return strings.HasPrefix(connID, prefix) }); n > 0 {
+		h.log.Info().Msgf("closed %d connections to port %d of %s", n, port, service.WorkspaceID(owner, workspace))
+	}
+}
+
+func (h *PortHandler) undeclare(ctx context.Context, owner, workspace string, port int) (*service.ForwardedPort, error) {
+	fp, err := h.reg.UndeclarePort(ctx, owner, workspace, port)
+	if err != nil {
+		return nil, err
+	}
+	h.cancelPortConns(owner, workspace, port)
+	return fp, nil
+}
+
+// setVisibility changes the visibility of a port and closes the connections opened through the old one,
+// the users still allowed reconnect. Older public links stop working.
+func (h *PortHandler) setVisibility(ctx context.Context, owner, workspace string, port int, v service.PortVisibility) (*service.PortVisibility, error) {
+	if v.Mode == service.PortPublic && !h.portTokens.LinksEnabled() {
+		return nil, fmt.Errorf("public links are disabled")
+	}
+	updated, err := h.reg.SetPortVisibility(ctx, owner, workspace, port, v)
+	if err != nil {
+		return nil, err
+	}
+	h.cancelPortConns(owner, workspace, port)
+	return updated, nil
+}
+
+// DeclarePort declares a port of a workspace of the current user for forwarding with a label and protocol,
+// the port must be allowed by the container template or one of the user's groups.
+func (h *PortHandler) DeclarePort(c echo.Context) error {
+	username := c.Get("username").(string)
+	groups, _ := c.Get("groups").([]string)
+	port, err := strconv.Atoi(strings.TrimSpace(c.FormValue("port")))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid port")
+	}
+	info, err := h.reg.Get(context.Background(), username, workspaceParam(c))
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to get workspace: %v", err))
+	}
+	if err := h.allowedPort(info, groups, port); err != nil {
+		return c.String(http.StatusForbidden, fmt.Sprintf("Failed to forward port: %v", err))
+	}
+	_, err = h.declare(context.Background(), username, info.Workspace, service.ForwardedPort{
+		Port:       port,
+		Label:      c.FormValue("label"),
+		Protocol:   c.FormValue("protocol"),
+		DeclaredBy: username,
+	})
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to forward port: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// RemovePort stops forwarding a port of a workspace of the current user
+func (h *PortHandler) RemovePort(c echo.Context) error {
+	port, err := strconv.Atoi(strings.TrimSpace(c.FormValue("port")))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid port")
+	}
+	if _, err := h.undeclare(context.Background(), c.Get("username").(string), workspaceParam(c), port); err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to remove port: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// SetPortVisibility changes who can reach a declared port of a workspace of the current user:
+// private, or group with the group form value, or public.
+func (h *PortHandler) SetPortVisibility(c echo.Context) error {
+	username := c.Get("username").(string)
+	port, err := strconv.Atoi(strings.TrimSpace(c.FormValue("port")))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "Invalid port")
+	}
+	_, err = h.setVisibility(context.Background(), username, workspaceParam(c), port, service.PortVisibility{
+		Mode:      c.FormValue("mode"),
+		Group:     c.FormValue("group"),
+		UpdatedBy: username,
+	})
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("Failed to change port visibility: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// ForwardedPortResponse is a declared port with its visibility and, for public ports, a new link to it
+type ForwardedPortResponse struct {
+	service.ForwardedPort
+	Visibility service.PortVisibility `json:"visibility"`
+	Link       string                 `json:"link,omitempty"`
+}
+
+func forwardedPortResponse(portTokens *security.PortTokenService, info *service.ContainerInfo, fp service.ForwardedPort) ForwardedPortResponse {
+	return ForwardedPortResponse{fp, info.PortVisibilityOf(fp.Port), publicPortLink(portTokens, info, fp.Port)}
+}
+
+// ListPortsAPI returns the declared ports of a workspace of :username, ?workspace= selects it
+func (h *PortHandler) ListPortsAPI(c echo.Context) error {
+	info, err := h.reg.Get(context.Background(), c.Param("username"), workspaceParam(c))
+	if err != nil {
+		if errors.Is(err, service.ErrContainerNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	ports := []ForwardedPortResponse{}
+	for _, fp := range info.ForwardedPorts {
+		ports = append(ports, forwardedPortResponse(h.portTokens, info, fp))
+	}
+	return c.JSON(http.StatusOK, ports)
+}
+
+// DeclarePortAPI declares a port of a workspace of :username for forwarding, for admins. The allowlists
+// of templates and groups do not apply, the code-server port is still refused.
+// Body: {"workspace": "<name>", "port": 8501, "label": "<label>", "protocol": "http|https|sse|sse-https"}
+func (h *PortHandler) DeclarePortAPI(c echo.Context) error {
+	var body struct {
+		Workspace string `json:"workspace" form:"workspace"`
+		Port      int    `json:"port" form:"port"`
+		Label     string `json:"label" form:"label"`
+		Protocol  string `json:"protocol" form:"protocol"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	fp, err := h.declare(context.Background(), c.Param("username"), body.Workspace, service.ForwardedPort{
+		Port:       body.Port,
+		Label:      body.Label,
+		Protocol:   body.Protocol,
+		DeclaredBy: requestedBy,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrContainerNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, fp)
+}
+
+// RemovePortAPI stops forwarding ?port= of a workspace of :username, for admins
+func (h *PortHandler) RemovePortAPI(c echo.Context) error {
+	port, err := strconv.Atoi(c.QueryParam("port"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid port"})
+	}
+	fp, err := h.undeclare(context.Background(), c.Param("username"), workspaceParam(c), port)
+	if err != nil {
+		if errors.Is(err, service.ErrPortNotFound) || errors.Is(err, service.ErrContainerNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, fp)
+}
+
+// SetPortVisibilityAPI changes the visibility of a declared port of a workspace of :username, for admins.
+// Body: {"workspace": "<name>", "port": 8501, "mode": "private|group|public", "group": "<group>"}
+func (h *PortHandler) SetPortVisibilityAPI(c echo.Context) error {
+	var body struct {
+		Workspace string `json:"workspace" form:"workspace"`
+		Port      int    `json:"port" form:"port"`
+		Mode      string `json:"mode" form:"mode"`
+		Group     string `json:"group" form:"group"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	v, err := h.setVisibility(context.Background(), c.Param("username"), body.Workspace, body.Port, service.PortVisibility{
+		Mode:      body.Mode,
+		Group:     body.Group,
+		UpdatedBy: requestedBy,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrContainerNotFound) || errors.Is(err, service.ErrPortNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	info, err := h.reg.Get(context.Background(), c.Param("username"), body.Workspace)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	fp := info.ForwardedPortOf(body.Port)
+	if fp == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": service.ErrPortNotFound.Error()})
+	}
+	resp := forwardedPortResponse(h.portTokens, info, *fp)
+	resp.Visibility = *v
+	return c.JSON(http.StatusOK, resp)
+}
@@ -3,9 +3,11 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -26,14 +28,29 @@ func getSessionID(c echo.Context) string {
 	return ""
 }
 
+// codeServerConnID identifies a code-server connection into an own workspace in the session registry,
+// it starts with the WorkspaceID like the connections into shared workspaces and ports
+func codeServerConnID(info *service.ContainerInfo, redisSessionID, query string) string {
+	if info == nil {
+		return fmt.Sprintf("%s | %s", redisSessionID, query)
+	}
+	return fmt.Sprintf("%s | %s | %s", service.WorkspaceID(info.User, info.Workspace), redisSessionID, query)
+}
+
 type ProxyHandler struct {
-	agentKey     string
-	proxy        *httputil.ReverseProxy
-	proxyService *service.ProxyService
-	reg          *service.ContainerRegistryService
-	jwtService   *security.JWTService
-	log          zerolog.Logger
-	withTLS      bool
+	agentKey      string
+	proxy         *httputil.ReverseProxy
+	proxyService  *service.ProxyService
+	reg           *service.ContainerRegistryService
+	activity      *service.ActivityService
+	agentService  *service.AgentService
+	tmpl          *template.Template
+	jwtService    *security.JWTService
+	portTokens    *security.PortTokenService
+	sessionCookie string
+	log           zerolog.Logger
+	withTLS       bool
+	wakeOnRequest bool
 }
 
 func NewProxyHandler(
@@ -41,10 +58,192 @@ func NewProxyHandler(
 	proxy *httputil.ReverseProxy,
 	proxyService *service.ProxyService,
 	reg *service.ContainerRegistryService,
+	activity *service.ActivityService,
+	agentService *service.AgentService,
+	tmpl *template.Template,
 	jwtService *security.JWTService,
+	portTokens *security.PortTokenService,
+	sessionCookie string,
 	log zerolog.Logger,
-	withTLS bool) *ProxyHandler {
-	return &ProxyHandler{agentKey, proxy, proxyService, reg, jwtService, log, withTLS}
+	withTLS bool,
+	wakeOnRequest bool) *ProxyHandler {
+	return &ProxyHandler{agentKey, proxy, proxyService, reg, activity, agentService, tmpl, jwtService, portTokens, sessionCookie, log, withTLS, wakeOnRequest}
+}
+
+// isNavigation reports whether the request is a browser page load (not an asset, xhr or websocket).
+func isNavigation(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		req.Header.Get("Upgrade") == "" &&
+		strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// workspaceAddress parses the workspace prefix of a path, /code-server/<workspace>/<rest> for a workspace of
+// the user and /code-server/@<owner>/<workspace>/<rest> for one shared with the user. rest starts with /.
+func workspaceAddress(p string) (owner, workspace, rest string, ok bool) {
+	rest, ok = strings.CutPrefix(p, "/code-server/")
+	if !ok {
+		return "", "", "", false
+	}
+	workspace, rest, _ = strings.Cut(rest, "/")
+	if o, shared := strings.CutPrefix(workspace, "@"); shared {
+		owner = o
+		workspace, rest, _ = strings.Cut(rest, "/")
+	}
+	return owner, workspace, "/" + rest, workspace != ""
+}
+
+// withoutCookie removes a cookie from the Cookie header of req
+func withoutCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// hostOnlyCookies drops the Domain of the cookies an app on a port subdomain sets, a cookie for the base domain
+// would be sent to the apps of other users and to the platform. The session cookie can not be set at all.
+// Cookies set by scripts of the app are not seen here, the base domain is best a registrable domain of its own.
+func hostOnlyCookies(resp *http.Response, sessionCookie string) {
+	lines := resp.Header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, line := range lines {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil || cookie.Name == sessionCookie {
+			continue
+		}
+		if cookie.Domain == "" {
+			resp.Header.Add("Set-Cookie", line)
+			continue
+		}
+		cookie.Domain = ""
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+}
+
+// forwardedPort parses a forwarded port path /code-server/request/<user>/<protocol>/<port>/...
+func forwardedPort(p string) (user string, port int, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(p, "/code-server"), "/"), "/")
+	if len(parts) < 4 || parts[0] != "request" {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.ToLower(parts[1]), port, true
+}
+
+// resolveWorkspace returns the workspace a /code-server/ request goes to and strips its address from the path.
+// Without an address in the path the workspace of the page the request comes from is used, then the default
+// workspace of the user a forwarded port path names if shared with the user, then the user's default one.
+// Workspaces of other users need a grant, addressing one without fails. Forwarded ports are the exception, whether
+// their visibility lets the user in is checked by the caller. nil if the user has no workspace.
+func (h *ProxyHandler) resolveWorkspace(c echo.Context, username string, groups []string) (*service.ContainerInfo, error) {
+	ctx := c.Request().Context()
+	workspaces, err := h.reg.List(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	req := c.Request()
+	portPath := req.URL.Path
+	if _, _, rest, ok := workspaceAddress(req.URL.Path); ok {
+		portPath = "/code-server" + rest
+	}
+	portUser, _, forwarded := forwardedPort(portPath)
+
+	find := func(owner, name string) *service.ContainerInfo {
+		if owner == "" || owner == username {
+			for i := range workspaces {
+				if workspaces[i].Workspace == name {
+					return &workspaces[i]
+				}
+			}
+			return nil
+		}
+		info, err := h.reg.Get(ctx, owner, name)
+		if err != nil || (!forwarded && !info.HasRole(username, groups, service.GrantRoleUse)) {
+			return nil
+		}
+		return info
+	}
+
+	if owner, name, rest, ok := workspaceAddress(req.URL.Path); ok {
+		info := find(owner, name)
+		if info == nil && owner != "" {
+			return nil, fmt.Errorf("%s has no access to workspace %s of %s", username, name, owner)
+		}
+		if info != nil {
+			req.URL.Path = "/code-server" + rest
+			if _, _, rawRest, ok := workspaceAddress(req.URL.RawPath); ok {
+				req.URL.RawPath = "/code-server" + rawRest
+			}
+			return info, nil
+		}
+	}
+	if referer, err := url.Parse(req.Referer()); err == nil {
+		if owner, name, _, ok := workspaceAddress(referer.Path); ok {
+			// a forwarded port goes to a workspace of the user it names
+			if info := find(owner, name); info != nil && (!forwarded || info.User == portUser) {
+				return info, nil
+			}
+		}
+	}
+	// /code-server/request/<user>/<protocol>/<port>/ of a forwarded port of another user
+	if forwarded && portUser != username {
+		if info := find(portUser, service.DefaultWorkspace); info != nil {
+			return info, nil
+		}
+	}
+	if info := find(username, service.DefaultWorkspace); info != nil {
+		return info, nil
+	}
+	if len(workspaces) > 0 {
+		return &workspaces[0], nil
+	}
+	return nil, nil
+}
+
+// wakeIfStopped starts the stopped container of the workspace and renders the "starting your workspace" page.
+// Returns handled=false when the request should be proxied as usual.
+func (h *ProxyHandler) wakeIfStopped(c echo.Context, info *service.ContainerInfo) (handled bool, err error) {
+	ctx := c.Request().Context()
+	if info.StopReason == service.StopReasonMigrating || info.StopReason == service.StopReasonRecreating {
+		return false, nil
+	}
+	username := info.User
+	running, err := h.agentService.IsContainerRunning(info.AgentHost, info.ContainerName)
+	if err != nil || running.Running {
+		return false, nil
+	}
+
+	h.log.Info().Msgf("wake on request: starting %s for %s", info.ContainerName, username)
+	if _, err := h.agentService.StartContainer(info.AgentHost, info.ContainerName); err != nil {
+		h.log.Error().Err(err).Msgf("wake on request: failed to start %s", info.ContainerName)
+		return false, nil
+	}
+	if err := h.reg.SetStopReason(ctx, username, info.Workspace, ""); err != nil {
+		h.log.Error().Err(err).Msgf("failed to clear stop reason for %s/%s", username, info.Workspace)
+	}
+	if err := h.activity.Reset(ctx, service.WorkspaceID(username, info.Workspace)); err != nil {
+		h.log.Error().Err(err).Msgf("failed to reset activity for %s", service.WorkspaceID(username, info.Workspace))
+	}
+
+	data := map[string]any{
+		"Username":  username,
+		"StatusURL": "/csplatform/containers/status?" + url.Values{"workspace": {info.Workspace}, "owner": {info.User}}.Encode(),
+		"ReturnURL": c.Request().URL.RequestURI(),
+	}
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().WriteHeader(http.StatusAccepted)
+	return true, h.tmpl.ExecuteTemplate(c.Response(), "workspace-starting.go.tmpl", data)
 }
 
 func (h *ProxyHandler) EchoHandler(CodeServerSessionRegistry *xsession.CodeServerSessionRegistry) echo.HandlerFunc {
@@ -62,20 +261,90 @@ func (h *ProxyHandler) EchoHandler(CodeServerSessionRegistry *xsession.CodeServe
 			redisSessionID = redisSessionIDCtx
 		}
 
-		if strings.Contains(c.QueryString(), "reconnectionToken") && strings.Contains(c.QueryString(), "skipWebSocketFrames") {
+		// the workspace is resolved before globalReq is cloned, it strips the workspace from the path
+		var info *service.ContainerInfo
+		// grantRole is the role of the user on a workspace of another user, empty for own workspaces
+		grantRole := ""
+
+		// idle stop activity
+		if username, ok := c.Get("username").(string); ok && username != "" {
+			groups, _ := c.Get("groups").([]string)
+			var err error
+			if info, err = h.resolveWorkspace(c, username, groups); err != nil {
+				h.log.Warn().Err(err).Msg("rejected workspace request")
+				return c.String(http.StatusForbidden, "access denied")
+			}
+			if info != nil && info.User != username {
+				grantRole = info.RoleOf(username, groups)
+			}
+
+			if info != nil {
+				// the idle timeout follows the groups of the owner, a grantee keeps them as they are
+				ownerGroups := groups
+				if info.User != username {
+					ownerGroups = nil
+				}
+				h.activity.Touch(c.Request().Context(), service.WorkspaceID(info.User, info.Workspace), ownerGroups)
+			}
+
+			// the starting page polls a relative URL, which would go to the app on a port subdomain
+			viaSubdomain, _ := c.Get("portSubdomain").(bool)
+			if h.wakeOnRequest && !viaSubdomain && info != nil && info.HasRole(username, groups, service.GrantRoleManage) && isNavigation(c.Request()) {
+				if handled, err := h.wakeIfStopped(c, info); handled {
+					return err
+				}
+			}
+		}
+
+		// forwarded ports are only proxied when their visibility lets the user in, the agent
+		// requires the assertion signed here
+		assertion := ""
+		portAccess := false
+		if portUser, port, ok := forwardedPort(c.Request().URL.Path); ok {
+			username, _ := c.Get("username").(string)
+			groups, _ := c.Get("groups").([]string)
+			if info == nil || info.User != portUser || !info.CanReachPort(username, groups, port) {
+				h.log.Warn().Msgf("rejected request of %s to port %d of %s", username, port, portUser)
+				return c.String(http.StatusForbidden, "access denied")
+			}
+			if info.ForwardedPortOf(port) == nil {
+				return c.String(http.StatusNotFound, fmt.Sprintf("port %d is not forwarded", port))
+			}
+			var err error
+			if assertion, err = h.portTokens.CreateRequestAssertion(info.User, info.ContainerName, port); err != nil {
+				h.log.Error().Err(err).Msg("failed to sign request assertion")
+				return c.String(http.StatusInternalServerError, "failed to sign request")
+			}
+			portAccess = grantRole == "" && info.User != username
+		}
+
+		// requests into shared workspaces are tracked so revoking the grant can close them,
+		// requests to ports opened to a group so narrowing the visibility can
+		if grantRole != "" {
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			globalReq = c.Request().Clone(ctx)
+			CodeServerSessionRegistry.AddConn(getSessionID(c), sharedConnID(info, redisSessionID, globalReq), ctx, cancel, nil)
+		} else if portAccess {
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			globalReq = c.Request().Clone(ctx)
+			_, port, _ := forwardedPort(globalReq.URL.Path)
+			CodeServerSessionRegistry.AddConn(getSessionID(c), portConnID(info, port, redisSessionID, globalReq), ctx, cancel, nil)
+		} else if strings.Contains(c.QueryString(), "reconnectionToken") && strings.Contains(c.QueryString(), "skipWebSocketFrames") {
 
 			sessionID := getSessionID(c)
 
 			ctx, cancel := context.WithCancel(c.Request().Context())
 			globalReq = c.Request().Clone(ctx)
 
-			connID := fmt.Sprintf("%s | %s", redisSessionID, c.QueryString())
-			CodeServerSessionRegistry.AddConn(sessionID, connID, ctx, cancel, baseTransport)
+			CodeServerSessionRegistry.AddConn(sessionID, codeServerConnID(info, redisSessionID, c.QueryString()), ctx, cancel, baseTransport)
 		}
 
 		h.proxyService.SetProxyErrorHandler(&rp, c)
 
 		rp.ModifyResponse = func(resp *http.Response) error {
+			if viaSubdomain, _ := c.Get("portSubdomain").(bool); viaSubdomain {
+				hostOnlyCookies(resp, h.sessionCookie)
+			}
 			path := resp.Request.URL.Path
 			status := resp.StatusCode
 
@@ -107,9 +376,10 @@ func (h *ProxyHandler) EchoHandler(CodeServerSessionRegistry *xsession.CodeServe
 
 		rp.Director = func(req *http.Request) {
 
-			ctx := context.Background()
-			info, err := h.reg.Get(ctx, c.Get("username").(string))
-			if err != nil {
+			req.Header.Del("X-Target-Container")
+			req.Header.Del("X-Workspace-Grant")
+			req.Header.Del("X-Request-Assertion")
+			if info == nil {
 				return
 			}
 			targetHost := info.AgentHost
@@ -128,6 +398,17 @@ func (h *ProxyHandler) EchoHandler(CodeServerSessionRegistry *xsession.CodeServe
 			req.Header.Set("X-Forwarded-Proto", "http")
 			req.Header.Set("User-Agent", c.Request().Header.Get("User-Agent"))
 			req.Header.Set("X-Agent-Key", h.agentKey)
+			// the agent checks the container belongs to the user before proxying to it
+			req.Header.Set("X-Target-Container", info.ContainerName)
+			if grantRole != "" {
+				req.Header.Set("X-Workspace-Grant", grantRole)
+			}
+			if assertion != "" {
+				req.Header.Set("X-Request-Assertion", assertion)
+			}
+			// the agent authenticates with X-Session-ID, the session cookie is not passed on. Containers of
+			// shared workspaces and apps on forwarded ports, with subdomains of other users too, would get it
+			withoutCookie(req, h.sessionCookie)
 			if redisSessionID != "" {
 				req.Header.Set("X-Session-ID", redisSessionID)
 			}
@@ -138,6 +419,53 @@ func (h *ProxyHandler) EchoHandler(CodeServerSessionRegistry *xsession.CodeServe
 	}
 }
 
+// PublicPortHandler proxies /public/<token>/<rest> to the forwarded port a public link points to. It needs no
+// login, the link is refused once expired, once the port is not public anymore or its visibility changed.
+func (h *ProxyHandler) PublicPortHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		link, err := h.portTokens.ParsePortLink(c.Param("token"))
+		if err != nil {
+			return c.String(http.StatusNotFound, "link is invalid or expired")
+		}
+		info, err := h.reg.Get(c.Request().Context(), link.User, link.Workspace)
+		if err != nil {
+			return c.String(http.StatusNotFound, "link is invalid or expired")
+		}
+		if v := info.PortVisibilityOf(link.Port); v.Mode != service.PortPublic || v.UpdatedAt != link.Version || info.ForwardedPortOf(link.Port) == nil {
+			return c.String(http.StatusNotFound, "link is invalid or expired")
+		}
+		assertion, err := h.portTokens.CreateRequestAssertion(info.User, info.ContainerName, link.Port)
+		if err != nil {
+			h.log.Error().Err(err).Msg("failed to sign request assertion")
+			return c.String(http.StatusInternalServerError, "failed to sign request")
+		}
+
+		rp := *h.proxy
+		rp.Transport = h.proxyService.BaseTransportInit(true)
+		h.proxyService.SetProxyErrorHandler(&rp, c)
+		rp.ModifyResponse = nil
+		rp.Director = func(req *http.Request) {
+			targetHost := strings.TrimPrefix(strings.TrimPrefix(info.AgentHost, "http://"), "https://")
+			req.URL.Scheme = "http"
+			req.URL.Host = targetHost
+			req.URL.Path = fmt.Sprintf("/code-server/request/%s/%s/%d/%s", info.User, link.Protocol, link.Port, c.Param("*"))
+			req.URL.RawPath = ""
+			req.Header.Set("Host", targetHost)
+			req.Header.Set("X-Real-IP", req.RemoteAddr)
+			req.Header.Set("X-Forwarded-For", req.RemoteAddr)
+			req.Header.Set("X-Forwarded-Proto", "http")
+			req.Header.Set("X-Agent-Key", h.agentKey)
+			req.Header.Set("X-Target-Container", info.ContainerName)
+			req.Header.Set("X-Request-Assertion", assertion)
+			req.Header.Del("X-Workspace-Grant")
+			req.Header.Del("X-Session-ID")
+			withoutCookie(req, h.sessionCookie)
+		}
+		rp.ServeHTTP(c.Response().Writer, c.Request())
+		return nil
+	}
+}
+
 func (h *ProxyHandler) setCodeServerProxyHeaders(req *http.Request, targetURL *url.URL, reqPath, originalPath string) {
 	req.URL.Scheme = targetURL.Scheme
 	req.URL.Host = targetURL.Host
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type ReconcileHandler struct {
+	reconciler *service.Reconciler
+	log        zerolog.Logger
+}
+
+func NewReconcileHandler(reconciler *service.Reconciler, log zerolog.Logger) *ReconcileHandler {
+	return &ReconcileHandler{reconciler, log}
+}
+
+// LastReport returns the diff of the last reconcile run
+func (h *ReconcileHandler) LastReport(c echo.Context) error {
+	report := h.reconciler.Last()
+	if report == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "reconcile has not run yet"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Run reconciles now, only reporting the diff unless dry_run=false
+func (h *ReconcileHandler) Run(c echo.Context) error {
+	dryRun := c.QueryParam("dry_run") != "false"
+	ctx := context.Background()
+	report, err := h.reconciler.Reconcile(ctx, dryRun)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	h.log.Info().Msgf("reconcile run by %v (dry run: %t): %d issue(s)", c.Get("username"), dryRun, len(report.Issues))
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type RecreateHandler struct {
+	recreates *service.RecreateService
+	log       zerolog.Logger
+}
+
+func NewRecreateHandler(recreates *service.RecreateService, log zerolog.Logger) *RecreateHandler {
+	return &RecreateHandler{recreates, log}
+}
+
+// RecreateContainer upgrades a workspace of the current user to the current image of its template.
+func (h *RecreateHandler) RecreateContainer(c echo.Context) error {
+	username := c.Get("username").(string)
+	if _, err := h.recreates.Start(context.Background(), username, workspaceParam(c), "", username); err != nil {
+		return c.String(http.StatusConflict, fmt.Sprintf("Failed to upgrade container: %v", err))
+	}
+	return c.Redirect(302, "/csplatform/home")
+}
+
+// RecreateAPI starts recreating a workspace of :username with a new image, keeping its settings and home.
+// Body: {"image": "<image>", "workspace": "<name>"}, empty image uses the current image of the container template
+// and empty workspace is the default one
+func (h *RecreateHandler) RecreateAPI(c echo.Context) error {
+	var body struct {
+		Image     string `json:"image" form:"image"`
+		Workspace string `json:"workspace" form:"workspace"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	r, err := h.recreates.Start(context.Background(), c.Param("username"), body.Workspace, body.Image, requestedBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, r)
+}
+
+// RecreateStatusAPI returns the progress of the last recreate of a workspace of :username, ?workspace= selects it
+func (h *RecreateHandler) RecreateStatusAPI(c echo.Context) error {
+	r, err := h.recreates.Get(context.Background(), c.Param("username"), workspaceParam(c))
+	if err != nil {
+		if err.Error() == "recreate not found" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// RecreateByImageAPI recreates the containers of all users of an image.
+// Body: {"from_image": "<image>", "to_image": "<image>"}, empty to_image re-pulls from_image
+func (h *RecreateHandler) RecreateByImageAPI(c echo.Context) error {
+	var body struct {
+		FromImage string `json:"from_image" form:"from_image"`
+		ToImage   string `json:"to_image" form:"to_image"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	requestedBy, _ := c.Get("username").(string)
+	b, err := h.recreates.StartBulk(context.Background(), body.FromImage, body.ToImage, requestedBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, b)
+}
+
+// RecreateByImageStatusAPI returns the bulk recreate with :id, "latest" for the last started one
+func (h *RecreateHandler) RecreateByImageStatusAPI(c echo.Context) error {
+	b, err := h.recreates.GetBulk(context.Background(), c.Param("id"))
+	if err != nil {
+		if err.Error() == "recreate not found" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, b)
+}
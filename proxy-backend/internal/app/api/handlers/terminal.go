@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/service"
+)
+
+type TerminalHandler struct {
+	reg          *service.ContainerRegistryService
+	agentService *service.AgentService
+	proxyService *service.ProxyService
+	tmpl         *template.Template
+	agentKey     string
+	log          zerolog.Logger
+}
+
+func NewTerminalHandler(
+	reg *service.ContainerRegistryService,
+	agentService *service.AgentService,
+	proxyService *service.ProxyService,
+	tmpl *template.Template,
+	agentKey string,
+	log zerolog.Logger,
+) *TerminalHandler {
+	return &TerminalHandler{reg, agentService, proxyService, tmpl, agentKey, log}
+}
+
+// RenderTerminal renders the terminal page for a workspace of the current user.
+func (h *TerminalHandler) RenderTerminal(c echo.Context) error {
+	username := c.Get("username").(string)
+	workspace := workspaceParam(c)
+	data := map[string]any{
+		"Username": username,
+		"Target":   service.WorkspaceID(username, workspace),
+		"WSPath":   "/csplatform/containers/terminal/ws?workspace=" + url.QueryEscape(workspace),
+	}
+	return h.tmpl.ExecuteTemplate(c.Response(), "terminal.go.tmpl", data)
+}
+
+// RenderTerminalAdmin renders the terminal page for any registered container.
+func (h *TerminalHandler) RenderTerminalAdmin(c echo.Context) error {
+	target := c.Param("username")
+	workspace := workspaceParam(c)
+	data := map[string]any{
+		"Username": c.Get("username").(string),
+		"Target":   service.WorkspaceID(target, workspace),
+		"WSPath":   fmt.Sprintf("/api/v1/containers/terminal/%s/ws?workspace=%s", url.PathEscape(target), url.QueryEscape(workspace)),
+	}
+	return h.tmpl.ExecuteTemplate(c.Response(), "terminal.go.tmpl", data)
+}
+
+// TerminalWS opens a terminal into the container registered for the current user only.
+func (h *TerminalHandler) TerminalWS(c echo.Context) error {
+	username := c.Get("username").(string)
+	return h.proxyTerminal(c, username, workspaceParam(c), username)
+}
+
+// TerminalWSAPI opens a terminal into the container of any registered user, for admins.
+func (h *TerminalHandler) TerminalWSAPI(c echo.Context) error {
+	return h.proxyTerminal(c, c.Param("username"), workspaceParam(c), c.Get("username").(string))
+}
+
+// proxyTerminal forwards the websocket upgrade to the agent exec endpoint of the target container.
+func (h *TerminalHandler) proxyTerminal(c echo.Context, target, workspace string, requestedBy string) error {
+	req := c.Request()
+	if !sameOrigin(req) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "cross origin terminal request"})
+	}
+
+	cntInfo, err := h.reg.Get(context.Background(), target, workspace)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	idResp, err := h.agentService.GetContainerIDByName(cntInfo.AgentHost, cntInfo.ContainerName)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	agentURL, err := url.Parse(cntInfo.AgentHost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	rp := &httputil.ReverseProxy{
+		Transport: h.proxyService.BaseTransportInit(true),
+		Director: func(r *http.Request) {
+			r.URL.Scheme = agentURL.Scheme
+			r.URL.Host = agentURL.Host
+			r.URL.Path = fmt.Sprintf("/api/v1/containers/%s/exec", idResp.ID)
+			r.URL.RawQuery = ""
+			r.Host = agentURL.Host
+			r.Header.Del("Cookie")
+			r.Header.Set("X-Agent-Key", h.agentKey)
+			r.Header.Set("X-Exec-Requested-By", requestedBy)
+		},
+	}
+
+	started := time.Now()
+	h.log.Info().
+		Str("requested_by", requestedBy).
+		Str("target", target).
+		Str("container", cntInfo.ContainerName).
+		Str("agent", cntInfo.AgentHost).
+		Str("remote_ip", c.RealIP()).
+		Msg("terminal session started")
+
+	rp.ServeHTTP(c.Response(), req)
+
+	h.log.Info().
+		Str("requested_by", requestedBy).
+		Str("target", target).
+		Str("container", cntInfo.ContainerName).
+		Dur("duration", time.Since(started)).
+		Msg("terminal session ended")
+	return nil
+}
+
+// sameOrigin rejects cross-site websocket hijacking: browsers always send Origin on websocket upgrades.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == req.Host
+}
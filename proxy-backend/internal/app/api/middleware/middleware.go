@@ -140,7 +140,7 @@ func JWTAuthMiddleware(
 				return next(c)
 			}
 			//log.Info().Msgf("%v")
-			return c.Redirect(http.StatusFound, "/auth/login")
+			return c.Redirect(http.StatusFound, loginURL(c))
 		}
 	}
 }
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"v0/internal/app/service"
+)
+
+// loginURLKey holds the login page for requests that can not use the relative /auth/login, like the ones of port subdomains
+const loginURLKey = "loginURL"
+
+func loginURL(c echo.Context) string {
+	if u, ok := c.Get(loginURLKey).(string); ok && u != "" {
+		return u
+	}
+	return "/auth/login"
+}
+
+// portSubdomain parses <port>-<workspace>-<user>.<baseDomain>. Workspace names have no "-", user names can.
+// Hosts ForwardedPortHost does not build, like ones with a user name with ".", are not port subdomains.
+func portSubdomain(host, baseDomain string) (port int, workspace, user string, ok bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, found := strings.CutSuffix(strings.ToLower(host), "."+baseDomain)
+	if !found || !service.ValidPortHostLabel(label) {
+		return 0, "", "", false
+	}
+	portStr, rest, ok1 := strings.Cut(label, "-")
+	workspace, user, ok2 := strings.Cut(rest, "-")
+	port, err := strconv.Atoi(portStr)
+	if !ok1 || !ok2 || err != nil || port < 1 || port > 65535 || user == "" || (workspace != service.DefaultWorkspace && service.ValidateWorkspaceName(workspace) != nil) {
+		return 0, "", "", false
+	}
+	return port, workspace, user, true
+}
+
+// PortSubdomainMiddleware rewrites requests to <port>-<workspace>-<user>.<baseDomain> to the path the forwarded port has
+// without subdomains, /code-server/@<user>/<workspace>/request/<user>/<protocol>/<port>/..., so they are routed and
+// authorized the same way. The protocol is the declared one of the port. Other hosts are not changed.
+func PortSubdomainMiddleware(baseDomain, loginPage string, reg *service.ContainerRegistryService) echo.MiddlewareFunc {
+	baseDomain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(baseDomain)), ".")
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			port, workspace, user, ok := portSubdomain(req.Host, baseDomain)
+			if !ok {
+				return next(c)
+			}
+			protocol := "http"
+			if info, err := reg.Get(req.Context(), user, workspace); err == nil {
+				if fp := info.ForwardedPortOf(port); fp != nil {
+					protocol = fp.Protocol
+				}
+			} else if errors.Is(err, service.ErrContainerNotFound) {
+				return c.String(http.StatusNotFound, "workspace not found")
+			}
+
+			prefix := fmt.Sprintf("/code-server/@%s/%s/request/%s/%s/%d", user, workspace, user, protocol, port)
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = prefix + req.URL.RawPath
+			}
+			req.URL.Path = prefix + req.URL.Path
+			c.Set(loginURLKey, loginPage)
+			c.Set("portSubdomain", true)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"testing"
+
+	"v0/internal/app/service"
+)
+
+func TestPortSubdomain(t *testing.T) {
+	const base = "apps.example.com"
+	tests := []struct {
+		host      string
+		port      int
+		workspace string
+		user      string
+		ok        bool
+	}{
+		{host: "8501-default-alice.apps.example.com", port: 8501, workspace: "default", user: "alice", ok: true},
+		{host: "8501-ml_lab-alice.apps.example.com", port: 8501, workspace: "ml_lab", user: "alice", ok: true},
+		{host: "3000-default-jean-luc.apps.example.com", port: 3000, workspace: "default", user: "jean-luc", ok: true},
+		{host: "8501-default-alice.apps.example.com:8443", port: 8501, workspace: "default", user: "alice", ok: true},
+		{host: "8501-Default-Alice.Apps.Example.com", port: 8501, workspace: "default", user: "alice", ok: true},
+		{host: "apps.example.com"},
+		{host: "8501-default-alice.other.example.com"},
+		{host: "8501-default-alice.evilapps.example.com"},
+		{host: "8501-default-alice.smith.apps.example.com"},
+		{host: "x-default-alice.apps.example.com"},
+		{host: "0-default-alice.apps.example.com"},
+		{host: "70000-default-alice.apps.example.com"},
+		{host: "8501-default.apps.example.com"},
+		{host: "8501-default-.apps.example.com"},
+		{host: "8501-static-alice.apps.example.com"},
+		{host: "8501-bad!-alice.apps.example.com"},
+	}
+	for _, tt := range tests {
+		port, workspace, user, ok := portSubdomain(tt.host, base)
+		if ok != tt.ok || port != tt.port || workspace != tt.workspace || user != tt.user {
+			t.Errorf("portSubdomain(%q) = %d, %q, %q, %v, want %d, %q, %q, %v",
+				tt.host, port, workspace, user, ok, tt.port, tt.workspace, tt.user, tt.ok)
+		}
+	}
+}
+
+func TestForwardedPortHostRoundTrip(t *testing.T) {
+	const base = "apps.example.com"
+	tests := []struct {
+		user      string
+		workspace string
+		port      int
+		ok        bool
+	}{
+		{user: "alice", workspace: "", port: 8501, ok: true},
+		{user: "alice", workspace: "ml_lab", port: 80, ok: true},
+		{user: "jean-luc", workspace: "default", port: 65535, ok: true},
+		{user: "a-b-c", workspace: "x1", port: 3000, ok: true},
+		{user: "alice.smith", workspace: "default", port: 8501, ok: false},
+		{user: "Alice", workspace: "default", port: 8501, ok: false},
+		{user: "alice", workspace: "default", port: 8501, ok: true},
+	}
+	for _, tt := range tests {
+		info := &service.ContainerInfo{User: tt.user, Workspace: tt.workspace}
+		host, ok := info.ForwardedPortHost(service.ForwardedPort{Port: tt.port}, "."+base)
+		if ok != tt.ok {
+			t.Errorf("ForwardedPortHost of %s/%s = %q, %v, want ok %v", tt.user, tt.workspace, host, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		port, workspace, user, ok := portSubdomain(host, base)
+		if !ok || port != tt.port || workspace != service.NormalizeWorkspace(tt.workspace) || user != tt.user {
+			t.Errorf("portSubdomain(%q) = %d, %q, %q, %v, want %d, %q, %q",
+				host, port, workspace, user, ok, tt.port, service.NormalizeWorkspace(tt.workspace), tt.user)
+		}
+	}
+}
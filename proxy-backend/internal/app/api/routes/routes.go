@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/labstack/echo/v4"
+	echomw "github.com/labstack/echo/v4/middleware"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 
@@ -85,14 +86,47 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 	}
 
 	jwtService := security.NewJWTService(config.JWTAccessSecret, config.JWTRefreshSecret, config.JWTIssuer, config.JWTAudience, log)
-	authService := service.NewAuthService(jwtService, tmpl, config.AppWithTLS, log, sessionDriver, config.AppSessionCookie)
+	portTokens := security.NewPortTokenService(config.AppAgentKey, config.PortLinkSecret, config.PortLinkTTLHours)
+	// the session cookie is sent to the port subdomains too
+	sessionCookieDomain := ""
+	if config.PortSubdomainBaseDomain != "" {
+		sessionCookieDomain = config.PortSubdomainCookieDomain
+		if sessionCookieDomain == "" {
+			sessionCookieDomain = config.PortSubdomainBaseDomain
+		}
+	}
+	authService := service.NewAuthService(jwtService, tmpl, config.AppWithTLS, log, sessionDriver, config.AppSessionCookie, sessionCookieDomain)
 	notFoundPageService := service.NewNotFoundPageService(tmpl)
 
-	revoker := xsession.NewRevoker(sessionDriver, log, config.AppSessionCookie)
+	revoker := xsession.NewRevoker(sessionDriver, log, config.AppSessionCookie, sessionCookieDomain)
 	codeServerSessions := xsession.NewSessionRegistry(config.AppWithTLS, revoker, log)
 	restyAdapter := adapters.NewRestyClientAdapter()
 	agentService := service.NewAgentService(restyAdapter, log, config.AppAgentKey, redisClient)
 	containerRegService := service.NewContainerRegistryService(redisClient, log)
+	// entries saved before users had several workspaces move into their default workspace
+	if _, err := containerRegService.MigrateLegacyEntries(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to migrate container registry entries")
+	}
+	if err := containerRegService.RebuildNameIndex(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to index container registry entries")
+	}
+	if err := containerRegService.SyncForwardedPorts(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to sync forwarded ports")
+	}
+	activityService := service.NewActivityService(redisClient, log)
+
+	// forwarded ports on <port>-<workspace>-<user>.<base domain>
+	if config.PortSubdomainBaseDomain != "" {
+		loginPage := config.PortSubdomainLoginURL
+		if loginPage == "" {
+			scheme := "http"
+			if config.AppWithTLS {
+				scheme = "https"
+			}
+			loginPage = fmt.Sprintf("%s://%s/auth/login", scheme, config.PortSubdomainBaseDomain)
+		}
+		e.Pre(middleware.PortSubdomainMiddleware(config.PortSubdomainBaseDomain, loginPage, containerRegService))
+	}
 
 	agentKeyMiddleware := middleware.AgentKeyMiddleware(config.AppAgentKey, log)
 	csrfMiddleware := middleware.CustomCSRFMiddleware(config.AppWithTLS, "form:_csrf")
@@ -107,10 +141,32 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 	e.HTTPErrorHandler = errorHandlerSvc.GlobalHTTPErrorHandler()
 
 	userInfoSvc := service.NewUserInfoService()
-	containerHandler := handlers.NewContainerHandler(userInfoSvc, tmpl, agentService, log, containerRegService, config)
+	proxyService := service.NewProxyService(notFoundPageService, log)
+	terminalHandler := handlers.NewTerminalHandler(containerRegService, agentService, proxyService, tmpl, config.AppAgentKey, log)
+	fileTransferHandler := handlers.NewFileTransferHandler(containerRegService, agentService, config, log)
+	uploadBodyLimit := echomw.BodyLimit(fmt.Sprintf("%dK", fileTransferHandler.MaxUploadBytes()/1024+64))
+	createJobService := service.NewCreateJobService(redisClient, containerRegService, agentService, activityService, config.CodeServerBasePort, log)
+	containerHandler := handlers.NewContainerHandler(userInfoSvc, tmpl, agentService, log, containerRegService, activityService, createJobService, config)
+
+	reconciler := service.NewReconciler(containerRegService, agentService, log)
+	migrationService := service.NewMigrationService(redisClient, containerRegService, agentService, log)
+	migrationHandler := handlers.NewMigrationHandler(migrationService, log)
+	recreateService := service.NewRecreateService(redisClient, containerRegService, agentService, log)
+	recreateHandler := handlers.NewRecreateHandler(recreateService, log)
+	grantHandler := handlers.NewGrantHandler(containerRegService, codeServerSessions, log)
+	portHandler := handlers.NewPortHandler(containerRegService, agentService, portTokens, codeServerSessions, config, log)
+	reconcileHandler := handlers.NewReconcileHandler(reconciler, log)
+	imageHandler := handlers.NewImageHandler(
+		service.NewImagePrepullService(redisClient, agentService, log),
+		service.NewRegistryAuthService(agentService, config.RegistryAuthFile, log),
+		log,
+	)
 
 	discoveryRegistry := xdiscovery.NewRegistry(redisClient, time.Second*30, log)
 	discoveryHandler := handlers.NewDiscoveryHandler(discoveryRegistry, log)
+	containerEventService := service.NewContainerEventService(redisClient, containerRegService, log)
+	containerAlertService := service.NewContainerAlertService(redisClient, containerRegService, log)
+	containerEventHandler := handlers.NewContainerEventHandler(containerEventService, containerAlertService, log)
 
 	// /api/v1
 	codeServerSessionHandler := handlers.NewCodeServerSessionHandler(codeServerSessions, tmpl)
@@ -125,31 +181,80 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 	apiGroup.POST("/containers/restart/:username", containerHandler.RestartContainerAPI)
 	apiGroup.POST("/containers/start/:username", containerHandler.StartContainerAPI)
 	apiGroup.POST("/containers/delete/:username", containerHandler.RemoveContainerAPI)
+	apiGroup.POST("/containers/resize/:username", containerHandler.ResizeContainerAPI)
+	apiGroup.POST("/containers/migrate/:username", migrationHandler.MigrateAPI)
+	apiGroup.GET("/containers/migrate/:username", migrationHandler.MigrationStatusAPI)
+	apiGroup.POST("/containers/recreate/:username", recreateHandler.RecreateAPI)
+	apiGroup.GET("/containers/recreate/:username", recreateHandler.RecreateStatusAPI)
+	apiGroup.POST("/containers/recreate-by-image", recreateHandler.RecreateByImageAPI)
+	apiGroup.GET("/containers/recreate-by-image/:id", recreateHandler.RecreateByImageStatusAPI)
 	apiGroup.GET("/containers/is-running/:username", containerHandler.IsContainerRunning)
+	apiGroup.GET("/containers/grants/:username", grantHandler.ListGrantsAPI)
+	apiGroup.POST("/containers/grants/:username", grantHandler.GrantAPI)
+	apiGroup.DELETE("/containers/grants/:username", grantHandler.RevokeAPI)
+	apiGroup.GET("/containers/ports/:username", portHandler.ListPortsAPI)
+	apiGroup.PUT("/containers/ports/:username", portHandler.DeclarePortAPI)
+	apiGroup.POST("/containers/ports/:username", portHandler.SetPortVisibilityAPI)
+	apiGroup.DELETE("/containers/ports/:username", portHandler.RemovePortAPI)
+	apiGroup.GET("/containers/events/:username", containerEventHandler.ListEventsAPI)
+	apiGroup.GET("/containers/alerts", containerEventHandler.ListAlertsAPI)
+	apiGroup.GET("/containers/logs/:username/stream", containerHandler.StreamLogsAPI)
+	apiGroup.GET("/containers/terminal/:username/ws", terminalHandler.TerminalWSAPI)
+	apiGroup.GET("/containers/files/:username/download", fileTransferHandler.DownloadAPI)
 	apiGroup.POST("/discovery/deregister", discoveryHandler.Deregister)
 
 	apiGroup.GET("/containers", containerHandler.GetContainers)
 	apiGroup.GET("/agents", containerHandler.GetAgents)
+	apiGroup.GET("/reconcile", reconcileHandler.LastReport)
+	apiGroup.POST("/reconcile", reconcileHandler.Run)
 
 
 	apiGroup.POST("/containers/create", containerHandler.CreateContainerRequest)
+	apiGroup.GET("/containers/create/jobs/:id", containerHandler.GetCreateJobAPI)
+	apiGroup.POST("/images/prepull", imageHandler.PrepullAPI)
+	apiGroup.GET("/images/prepull/:id", imageHandler.PrepullStatusAPI)
+	apiGroup.GET("/registry/credentials", imageHandler.RegistryCredentialsAPI)
+	apiGroup.POST("/registry/credentials/push", imageHandler.PushRegistryCredentialsAPI)
 
 	// /admin
 	adminGroup := e.Group("/admin", csrfMiddleware, jwtMiddlewareForAdmins, standardCORSMiddleware)
 	adminGroup.GET("/code-server-sessions", codeServerSessionHandler.RenderPage)
 	adminGroup.GET("/containers/manager", containerHandler.RenderContainerManager)
+	adminGroup.GET("/containers/terminal/:username", terminalHandler.RenderTerminalAdmin)
 
 	// /csplatform
-	homePageHandler := handlers.NewHomePageHandler(jwtService, tmpl, config, log, agentService, containerRegService)
+	homePageHandler := handlers.NewHomePageHandler(jwtService, tmpl, config, log, agentService, containerRegService, containerEventService, containerAlertService, recreateService, portTokens)
 	notFoundHandler := handlers.NewNotFoundPageHandler(tmpl)
 	csplatformGroup := e.Group("/csplatform", csrfMiddleware, jwtMiddlewareForUsers, standardCORSMiddleware)
 	csplatformGroup.GET("/home", homePageHandler.RenderHomePage)
 	csplatformGroup.GET("/404", notFoundHandler.Render404)
 	csplatformGroup.GET("/containers/create", containerHandler.ShowFormCreate)
+	csplatformGroup.GET("/containers/create/progress/:id", containerHandler.ShowCreateJob)
+	csplatformGroup.GET("/containers/create/jobs/:id", containerHandler.GetCreateJob)
+	csplatformGroup.GET("/containers/create/jobs/:id/stream", containerHandler.StreamCreateJob)
 	csplatformGroup.POST("/containers/stop", containerHandler.StopContainer)
 	csplatformGroup.POST("/containers/restart", containerHandler.RestartContainer)
 	csplatformGroup.POST("/containers/start", containerHandler.StartContainer)
 	csplatformGroup.POST("/containers/delete", containerHandler.RemoveContainer)
+	csplatformGroup.POST("/containers/resize", containerHandler.ResizeContainer)
+	csplatformGroup.POST("/containers/recreate", recreateHandler.RecreateContainer)
+	csplatformGroup.GET("/containers/status", containerHandler.ContainerStatus)
+	csplatformGroup.POST("/containers/grants", grantHandler.GrantWorkspace)
+	csplatformGroup.POST("/containers/grants/revoke", grantHandler.RevokeWorkspace)
+	csplatformGroup.POST("/containers/ports", portHandler.SetPortVisibility)
+	csplatformGroup.POST("/containers/ports/declare", portHandler.DeclarePort)
+	csplatformGroup.POST("/containers/ports/remove", portHandler.RemovePort)
+	csplatformGroup.POST("/containers/alerts/dismiss", containerEventHandler.DismissAlerts)
+	csplatformGroup.GET("/containers/logs/stream", containerHandler.StreamLogs)
+	csplatformGroup.GET("/containers/terminal", terminalHandler.RenderTerminal)
+	csplatformGroup.GET("/containers/terminal/ws", terminalHandler.TerminalWS)
+	csplatformGroup.GET("/containers/files/download", fileTransferHandler.Download)
+
+	// uploads: body limit must run before the csrf middleware parses the multipart form
+	userUploadGroup := e.Group("/csplatform/containers/files", uploadBodyLimit, csrfMiddleware, jwtMiddlewareForUsers, standardCORSMiddleware)
+	userUploadGroup.POST("/upload", fileTransferHandler.Upload)
+	adminUploadGroup := e.Group("/api/v1/containers/files", uploadBodyLimit, jwtMiddlewareForAdmins, standardCORSMiddleware)
+	adminUploadGroup.POST("/:username/upload", fileTransferHandler.UploadAPI)
 	csplatformGroup.GET("/containers/agent/:url/metrics", containerHandler.FetchMetrics)
 	csplatformGroup.GET("/containers/container/:name/:url/metrics", containerHandler.FetchContainerStats)
 
@@ -159,6 +264,8 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 	discoveryGroup.POST("/deregister", discoveryHandler.Deregister)
 	discoveryGroup.POST("/healthcheck", discoveryHandler.HealthCheck)
 	discoveryGroup.GET("/discover/:serviceName", discoveryHandler.Discover)
+	discoveryGroup.POST("/events", containerEventHandler.Push)
+	discoveryGroup.POST("/alerts", containerEventHandler.PushAlert)
 
 	// /auth
 	authHandler := handlers.NewAuthHandler(authService, codeServerSessions, tmpl, config.AppWithTLS, log)
@@ -168,8 +275,6 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 	authGroup.GET("/logout", authHandler.PostLogout, jwtMiddlewareForUsers)
 	authGroup.POST("/logout", authHandler.PostLogout, jwtMiddlewareForUsers)
 
-	proxyService := service.NewProxyService(notFoundPageService, log)
-
 	// /redisinsight
 	if config.RedisInsightEnabled {
 		redisInsightProxy := httputil.NewSingleHostReverseProxy(&url.URL{
@@ -194,18 +299,54 @@ func RegisterRoutes(log zerolog.Logger, config *config.AppConfig, tmpl *template
 		dummyProxy,
 		proxyService,
 		containerRegService,
+		activityService,
+		agentService,
+		tmpl,
 		jwtService,
+		portTokens,
+		config.AppSessionCookie,
 		log,
 		config.AppWithTLS,
+		config.ContainerWakeOnRequest,
 	)
 	e.Any("/code-server/*", ph.EchoHandler(codeServerSessions), jwtMiddlewareForProxy)
+	// public links to forwarded ports, the signed link is the authorization
+	e.Any("/public/:token/*", ph.PublicPortHandler())
 
 	e.GET("/", func(c echo.Context) error {
 		return c.Redirect(http.StatusMovedPermanently, "/csplatform/home")
 	})
 
-	janitorCtx, _ := context.WithCancel(context.Background())
-	codeServerSessions.StartJanitor(janitorCtx, 10*time.Second)
+	codeServerSessions.StartJanitor(context.Background(), 10*time.Second)
+
+	// idle stop
+	if config.ContainerIdleStopEnabled {
+		idleStopController := service.NewIdleStopController(
+			containerRegService,
+			agentService,
+			activityService,
+			codeServerSessions,
+			config.ContainerIdleTimeoutMinutes,
+			utils.ParseToIntMap(config.ContainerIdleGroupTimeouts),
+			log,
+		)
+		checkInterval := time.Duration(config.ContainerIdleCheckIntervalSeconds) * time.Second
+		if checkInterval <= 0 {
+			checkInterval = time.Minute
+		}
+		idleStopController.Start(context.Background(), checkInterval)
+		log.Info().Msgf("Idle stop enabled: timeout %d minutes, check interval %s", config.ContainerIdleTimeoutMinutes, checkInterval)
+	}
+
+	// registry/agent reconciliation
+	if config.ContainerReconcileEnabled {
+		reconcileInterval := time.Duration(config.ContainerReconcileIntervalSeconds) * time.Second
+		if reconcileInterval <= 0 {
+			reconcileInterval = 5 * time.Minute
+		}
+		reconciler.Start(context.Background(), reconcileInterval, !config.ContainerReconcileRepair)
+		log.Info().Msgf("Reconciler enabled: interval %s, repair %t", reconcileInterval, config.ContainerReconcileRepair)
+	}
 
 	return e
 }
@@ -7,7 +7,9 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func ExpireSessionCookie(c echo.Context, withTLS bool, sessionCookie string) {
+// ExpireSessionCookie deletes the session cookie. cookieDomain is the parent domain the cookie was scoped to
+// so it is also sent to port subdomains, empty if it is kept on the host that set it.
+func ExpireSessionCookie(c echo.Context, withTLS bool, sessionCookie, cookieDomain string) {
 	del := &http.Cookie{
 		Name:     sessionCookie,
 		Value:    "",
@@ -24,4 +26,10 @@ func ExpireSessionCookie(c echo.Context, withTLS bool, sessionCookie string) {
 
 	del2.Path = c.Request().URL.Path
 	c.SetCookie(&del2)
+
+	if cookieDomain != "" {
+		del3 := *del
+		del3.Domain = cookieDomain
+		c.SetCookie(&del3)
+	}
 }
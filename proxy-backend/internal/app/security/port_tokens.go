@@ -0,0 +1,126 @@
+package security
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// requestAssertionAudience must match the agent, it keeps other tokens signed with the agent key from passing as assertions
+	requestAssertionAudience = "csplatform-request"
+	// requestAssertionTTL only has to cover the way to the agent, long lived connections are checked when they open
+	requestAssertionTTL = time.Minute
+
+	portLinkAudience   = "csplatform-port-link"
+	defaultPortLinkTTL = 7 * 24 * time.Hour
+)
+
+// PortLink is the forwarded port a public link points to. Version is the UpdatedAt of the port visibility
+// the link was created for, changing the visibility invalidates older links.
+type PortLink struct {
+	User      string
+	Workspace string
+	Protocol  string
+	Port      int
+	Version   string
+	ExpiresAt time.Time
+}
+
+// PortTokenService signs the request assertions the agent requires on forwarded port traffic
+// and the public links to forwarded ports
+type PortTokenService struct {
+	agentKey   []byte
+	linkSecret []byte
+	linkTTL    time.Duration
+}
+
+func NewPortTokenService(agentKey string, linkSecret string, linkTTLHours int) *PortTokenService {
+	linkTTL := time.Duration(linkTTLHours) * time.Hour
+	if linkTTL <= 0 {
+		linkTTL = defaultPortLinkTTL
+	}
+	return &PortTokenService{[]byte(agentKey), []byte(linkSecret), linkTTL}
+}
+
+// CreateRequestAssertion signs that a request to port of the container of user was allowed
+func (s *PortTokenService) CreateRequestAssertion(user, container string, port int) (string, error) {
+	ct := time.Now()
+	claims := jwt.MapClaims{
+		"sub":  user,
+		"ctr":  container,
+		"port": port,
+		"exp":  ct.Add(requestAssertionTTL).Unix(),
+		"iat":  ct.Unix(),
+		"aud":  requestAssertionAudience,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(s.agentKey)
+}
+
+// LinksEnabled reports whether public links can be signed
+func (s *PortTokenService) LinksEnabled() bool {
+	return len(s.linkSecret) > 0
+}
+
+// CreatePortLink signs a public link token to a forwarded port
+func (s *PortTokenService) CreatePortLink(link PortLink) (string, time.Time, error) {
+	if !s.LinksEnabled() {
+		return "", time.Time{}, fmt.Errorf("public links are disabled")
+	}
+	ct := time.Now()
+	expiresAt := ct.Add(s.linkTTL)
+	claims := jwt.MapClaims{
+		"sub":   link.User,
+		"ws":    link.Workspace,
+		"proto": link.Protocol,
+		"port":  link.Port,
+		"ver":   link.Version,
+		"exp":   expiresAt.Unix(),
+		"iat":   ct.Unix(),
+		"aud":   portLinkAudience,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString(s.linkSecret)
+	return signed, expiresAt, err
+}
+
+// ParsePortLink validates a public link token and returns the port it points to
+func (s *PortTokenService) ParsePortLink(tokenString string) (*PortLink, error) {
+	if !s.LinksEnabled() {
+		return nil, fmt.Errorf("public links are disabled")
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.linkSecret, nil
+	},
+		jwt.WithAudience(portLinkAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	link := &PortLink{}
+	link.User, _ = claims["sub"].(string)
+	link.Workspace, _ = claims["ws"].(string)
+	link.Protocol, _ = claims["proto"].(string)
+	link.Version, _ = claims["ver"].(string)
+	port, _ := claims["port"].(float64)
+	link.Port = int(port)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		link.ExpiresAt = exp.Time
+	}
+	if link.User == "" || link.Protocol == "" || link.Port <= 0 {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return link, nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// activityWriteInterval throttles redis writes for proxied requests to the same workspace.
+const activityWriteInterval = 30 * time.Second
+
+// ErrActivityNotFound is returned by Get when no activity was recorded yet.
+var ErrActivityNotFound = errors.New("activity not found")
+
+// UserActivity is the last activity in a workspace, ID is its WorkspaceID. Groups are the groups of the owner,
+// they pick the idle timeout.
+type UserActivity struct {
+	ID       string    `json:"id"`
+	Groups   []string  `json:"groups,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type ActivityService struct {
+	rdb       *redis.Client
+	log       zerolog.Logger
+	mu        sync.Mutex
+	lastWrite map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewActivityService(rdb *redis.Client, log zerolog.Logger) *ActivityService {
+	return &ActivityService{rdb: rdb, log: log, lastWrite: make(map[string]time.Time)}
+}
+
+// Touch marks the workspace with the WorkspaceID id as active now. Groups are kept from the previous write when nil.
+func (s *ActivityService) Touch(ctx context.Context, id string, groups []string) {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	if last, ok := s.lastWrite[id]; ok && now.Sub(last) < activityWriteInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastWrite[id] = now
+	s.prune(now)
+	s.mu.Unlock()
+
+	if err := s.save(ctx, id, groups, now); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save activity for %s", id)
+	}
+}
+
+// Reset marks the workspace with the WorkspaceID id as active now, bypassing the write throttle.
+func (s *ActivityService) Reset(ctx context.Context, id string) error {
+	now := time.Now().UTC()
+	s.mu.Lock()
+	s.lastWrite[id] = now
+	s.prune(now)
+	s.mu.Unlock()
+	return s.save(ctx, id, nil, now)
+}
+
+// prune drops the write times that no longer throttle anything, at most once per interval. Callers hold s.mu.
+func (s *ActivityService) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < activityWriteInterval {
+		return
+	}
+	s.lastPrune = now
+	for id, last := range s.lastWrite {
+		if now.Sub(last) >= activityWriteInterval {
+			delete(s.lastWrite, id)
+		}
+	}
+}
+
+func (s *ActivityService) save(ctx context.Context, id string, groups []string, at time.Time) error {
+	fields := map[string]any{"last_seen": at.Format(time.RFC3339)}
+	if groups != nil {
+		data, err := json.Marshal(groups)
+		if err != nil {
+			return fmt.Errorf("failed to marshal groups: %w", err)
+		}
+		fields["groups"] = string(data)
+	}
+	return s.rdb.HSet(ctx, fmt.Sprintf("activity:%s", id), fields).Err()
+}
+
+// Get returns the last known activity of the workspace with the WorkspaceID id.
+func (s *ActivityService) Get(ctx context.Context, id string) (*UserActivity, error) {
+	vals, err := s.rdb.HGetAll(ctx, fmt.Sprintf("activity:%s", id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get activity: %w", err)
+	}
+	if len(vals) == 0 {
+		return nil, ErrActivityNotFound
+	}
+
+	activity := &UserActivity{ID: id}
+	if activity.LastSeen, err = time.Parse(time.RFC3339, vals["last_seen"]); err != nil {
+		return nil, fmt.Errorf("failed to parse last_seen: %w", err)
+	}
+	if raw := vals["groups"]; raw != "" {
+		_ = json.Unmarshal([]byte(raw), &activity.Groups)
+	}
+	return activity, nil
+}
@@ -1,10 +1,16 @@
 package service
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
@@ -50,6 +56,12 @@ type IsContainerRunningResponse struct {
 	Running bool   `  json:"running"`
 }
 
+type IsContainerReadyResponse struct {
+	Name  string `json:"name"`
+	Port  int    `json:"port"`
+	Ready bool   `json:"ready"`
+}
+
 type StartContainerResponse struct {
 	Status string `json:"status"`
 }
@@ -62,13 +74,43 @@ type RestartContainerResponse struct {
 	Status string `json:"status"`
 }
 
+type UpdateContainerResponse struct {
+	Status   string   `json:"status"`
+	Warnings []string `json:"warnings"`
+}
+
 type RemoveContainerResponse struct {
 	Status string `json:"status"`
 }
 
+// ManagedContainer is a platform container listed by an agent
+type ManagedContainer struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	ImageID string            `json:"ImageID"`
+	State   string            `json:"State"`
+	Status  string            `json:"Status"`
+	Created int64             `json:"Created"`
+	Labels  map[string]string `json:"Labels"`
+	Owner     string            `json:"owner"`
+	Profile   string            `json:"profile,omitempty"`
+	Workspace string            `json:"workspace,omitempty"`
+	Legacy    bool              `json:"legacy,omitempty"`
+}
+
+// Name returns the container name without the leading slash
+func (c *ManagedContainer) Name() string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
 type CreateContainerResponse struct {
-	ID       string   `json:"Id"`
-	Warnings []string `json:"Warnings"`
+	ID       string         `json:"Id"`
+	Warnings []string       `json:"Warnings"`
+	Ports    map[string]int `json:"ports,omitempty"` // host ports reserved by the agent for "${port:name}" placeholders
 }
 
 type FetchMetricsResponse struct {
@@ -80,7 +122,20 @@ type FetchMetricsResponse struct {
 	Total  uint64  `json:"total"`
 }
 
+// ContainerProfile is a named container template of an agent
+type ContainerProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image"`
+	Cpus        int    `json:"cpus,omitempty"`
+	Memory      string `json:"memory,omitempty"`
+	Default     bool   `json:"default"`
+	// ForwardPorts are the ports users can declare for forwarding, "8501" or "8500-8599"
+	ForwardPorts []string `json:"forwardPorts,omitempty"`
+}
+
 type GetContainerDefaultsResponse struct {
+	Profile    string            `json:"profile"`
 	Image      string            `json:"image"`
 	Name       string            `json:"name"`
 	Env        map[string]string `json:"env"`
@@ -94,6 +149,16 @@ type GetContainerDefaultsResponse struct {
 	Restart    string            `json:"restart"`
 	ExtraHosts []string          `json:"extra_hosts"`
 
+	PidsLimit         int64             `json:"pidsLimit"`
+	Ulimits           map[string]string `json:"ulimits"`
+	ShmSize           string            `json:"shmSize"`
+	MemorySwap        string            `json:"memorySwap"`
+	MemoryReservation string            `json:"memoryReservation"`
+	CPUShares         int64             `json:"cpuShares"`
+	CpusetCpus        string            `json:"cpusetCpus"`
+	StorageSize       string            `json:"storageSize"`
+	Tmpfs             []string          `json:"tmpfs"`
+
 	// Allow
 	AllowEditImage      bool `json:"allowEditImage"`
 	AllowEditName       bool `json:"allowEditName"`
@@ -107,6 +172,16 @@ type GetContainerDefaultsResponse struct {
 	AllowEditExtraHosts bool `json:"allowEditExtraHosts"`
 	AllowEditEnv        bool `json:"allowEditEnv"`
 	AllowEditSysctls    bool `json:"allowEditSysctls"`
+
+	AllowEditPidsLimit         bool `json:"allowEditPidsLimit"`
+	AllowEditUlimits           bool `json:"allowEditUlimits"`
+	AllowEditShmSize           bool `json:"allowEditShmSize"`
+	AllowEditMemorySwap        bool `json:"allowEditMemorySwap"`
+	AllowEditMemoryReservation bool `json:"allowEditMemoryReservation"`
+	AllowEditCPUShares         bool `json:"allowEditCPUShares"`
+	AllowEditCpuset            bool `json:"allowEditCpuset"`
+	AllowEditStorageSize       bool `json:"allowEditStorageSize"`
+	AllowEditTmpfs             bool `json:"allowEditTmpfs"`
 }
 
 type AgentService struct {
@@ -193,6 +268,31 @@ func (s *AgentService) IsContainerRunning(agentURL string, containerName string)
 	return &result, nil
 }
 
+func (s *AgentService) IsContainerReady(agentURL string, containerName string, port int) (*IsContainerReadyResponse, error) {
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/ready", containerName)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetQueryParam("port", fmt.Sprintf("%d", port)).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		var bodyStr string
+		if resp.Body() != nil {
+			bodyStr = string(resp.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), bodyStr)
+	}
+	var result IsContainerReadyResponse
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func (s *AgentService) StartContainer(agentURL string, containerName string) (*StartContainerResponse, error) {
 	resp, err := s.GetContainerIDByName(agentURL, containerName)
 	if err != nil {
@@ -263,9 +363,16 @@ func (s *AgentService) RestartContainer(agentURL string, containerName string) (
 		SetHeader("Accept", "application/json").
 		SetHeader("X-Agent-Key", s.agentKey).
 		Post(agentAPI)
-	if err != nil || respF.StatusCode() != 200 {
+	if err != nil {
 		return nil, err
 	}
+	if respF.StatusCode() != 200 {
+		var bodyStr string
+		if respF.Body() != nil {
+			bodyStr = string(respF.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
 	var result RestartContainerResponse
 	if err := json.Unmarshal(respF.Body(), &result); err != nil {
 		return nil, err
@@ -273,6 +380,37 @@ func (s *AgentService) RestartContainer(agentURL string, containerName string) (
 	return &result, nil
 
 }
+
+func (s *AgentService) UpdateContainer(agentURL string, containerName string, req map[string]any) (*UpdateContainerResponse, error) {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/update", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetBody(req).
+		Post(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if respF.StatusCode() != 200 {
+		var bodyStr string
+		if respF.Body() != nil {
+			bodyStr = string(respF.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+	var result UpdateContainerResponse
+	if err := json.Unmarshal(respF.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func (s *AgentService) RemoveContainer(agentURL string, containerName string) (*RemoveContainerResponse, error) {
 	resp, err := s.GetContainerIDByName(agentURL, containerName)
 	if err != nil {
@@ -286,9 +424,16 @@ func (s *AgentService) RemoveContainer(agentURL string, containerName string) (*
 		SetHeader("X-Agent-Key", s.agentKey).
 		SetQueryParam("force", "true").
 		Delete(agentAPI)
-	if err != nil || respF.StatusCode() != 200 {
+	if err != nil {
 		return nil, err
 	}
+	if respF.StatusCode() != 200 {
+		var bodyStr string
+		if respF.Body() != nil {
+			bodyStr = string(respF.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
 	var result RemoveContainerResponse
 	if err := json.Unmarshal(respF.Body(), &result); err != nil {
 		return nil, err
@@ -297,17 +442,159 @@ func (s *AgentService) RemoveContainer(agentURL string, containerName string) (*
 
 }
 
-func (s *AgentService) GetContainerDefaults(agentURL string) (*GetContainerDefaultsResponse, error) {
+// StreamContainerLogs opens the agent follow-mode log stream (server-sent events).
+// The caller must close the returned body; cancelling ctx stops the stream on the agent.
+func (s *AgentService) StreamContainerLogs(ctx context.Context, agentURL string, containerName string, tail string) (io.ReadCloser, error) {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/logs", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("Accept", "text/event-stream").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetQueryParam("follow", "true").
+		SetQueryParam("tail", tail).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	body := respF.RawBody()
+	if respF.StatusCode() != 200 {
+		defer body.Close()
+		bodyStr, _ := io.ReadAll(body)
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+	return body, nil
+}
+
+// UploadFileToContainer streams a single file of the given size into destDir of the container.
+func (s *AgentService) UploadFileToContainer(ctx context.Context, agentURL, containerName, destDir, fileName string, size int64, body io.Reader) error {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return err
+	}
+
+	// plain net/http so the body is streamed with a known length instead of buffered by resty
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/archive", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s?%s", agentURL, endpoint, url.Values{"path": {destDir}, "name": {fileName}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, agentAPI, io.LimitReader(body, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Agent-Key", s.agentKey)
+
+	respF, err := s.restyAdapter.Client.GetClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer respF.Body.Close()
+	if respF.StatusCode != 200 {
+		bodyStr, _ := io.ReadAll(respF.Body)
+		return fmt.Errorf("request failed with status %d: %s", respF.StatusCode, bodyStr)
+	}
+	return nil
+}
+
+// DownloadFromContainer opens srcPath of the container as a tar.gz stream. The caller must close it.
+// Returns the agent suggested Content-Disposition as well.
+func (s *AgentService) DownloadFromContainer(ctx context.Context, agentURL, containerName, srcPath string) (io.ReadCloser, string, error) {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/archive", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetQueryParam("path", srcPath).
+		Get(agentAPI)
+	if err != nil {
+		return nil, "", err
+	}
+	body := respF.RawBody()
+	if respF.StatusCode() != 200 {
+		defer body.Close()
+		bodyStr, _ := io.ReadAll(body)
+		return nil, "", fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+	return body, respF.Header().Get("Content-Disposition"), nil
+}
+
+// ListTransferPaths returns the paths the agent allows file transfers under, the first one is its default.
+func (s *AgentService) ListTransferPaths(agentURL string) ([]string, error) {
+	endpoint := "/api/v1/containers/archive/paths"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), string(resp.Body()))
+	}
+
+	var result struct {
+		AllowedPaths []string `json:"allowedPaths"`
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return result.AllowedPaths, nil
+}
+
+// ImageID returns the ID of the local image behind an image reference on the agent
+func (s *AgentService) ImageID(agentURL string, image string) (string, error) {
+	endpoint := "/api/v1/images/id"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetQueryParam("image", image).
+		Get(agentAPI)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return "", fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), string(resp.Body()))
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+func (s *AgentService) GetContainerDefaults(agentURL string, profile string) (*GetContainerDefaultsResponse, error) {
 
 	endpoint := "/api/v1/containers/defaults"
 	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
 	resp, err := s.restyAdapter.R().
 		SetHeader("Accept", "application/json").
 		SetHeader("X-Agent-Key", s.agentKey).
+		SetQueryParam("profile", profile).
 		Get(agentAPI)
-	if err != nil || resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), string(resp.Body()))
+	}
 
 	var result GetContainerDefaultsResponse
 	if err := json.Unmarshal(resp.Body(), &result); err != nil {
@@ -317,6 +604,158 @@ func (s *AgentService) GetContainerDefaults(agentURL string) (*GetContainerDefau
 
 }
 
+func (s *AgentService) ListProfiles(agentURL string) ([]ContainerProfile, error) {
+	endpoint := "/api/v1/containers/profiles"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), string(resp.Body()))
+	}
+
+	var result []ContainerProfile
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *AgentService) ListManagedContainers(agentURL string) ([]ManagedContainer, error) {
+	endpoint := "/api/v1/containers/code-server"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		var bodyStr string
+		if resp.Body() != nil {
+			bodyStr = string(resp.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), bodyStr)
+	}
+	var result []ManagedContainer
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetContainerSpec returns the create request the agent would need to recreate the container
+func (s *AgentService) GetContainerSpec(agentURL string, containerName string) (map[string]any, error) {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/spec", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if respF.StatusCode() != 200 {
+		var bodyStr string
+		if respF.Body() != nil {
+			bodyStr = string(respF.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+	var result map[string]any
+	if err := json.Unmarshal(respF.Body(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExportContainerState opens the tar.gz stream of the container workspace home. The caller must close it.
+func (s *AgentService) ExportContainerState(ctx context.Context, agentURL string, containerName string) (io.ReadCloser, error) {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/state", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	body := respF.RawBody()
+	if respF.StatusCode() != 200 {
+		defer body.Close()
+		bodyStr, _ := io.ReadAll(body)
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+	return body, nil
+}
+
+// ImportContainerState streams a tar.gz made by ExportContainerState into the container workspace home
+func (s *AgentService) ImportContainerState(ctx context.Context, agentURL string, containerName string, body io.Reader) error {
+	resp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return err
+	}
+
+	// plain net/http so the archive is streamed instead of buffered by resty
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/state", resp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, agentAPI, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Agent-Key", s.agentKey)
+
+	respF, err := s.restyAdapter.Client.GetClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer respF.Body.Close()
+	if respF.StatusCode != 200 {
+		bodyStr, _ := io.ReadAll(respF.Body)
+		return fmt.Errorf("request failed with status %d: %s", respF.StatusCode, bodyStr)
+	}
+	return nil
+}
+
+// AdmissionViolation is a rule of the agent admission policy broken by a create request
+type AdmissionViolation struct {
+	Rule    string `json:"rule"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// AdmissionError is returned by CreateContainer when the agent rejects the request with its admission policy
+type AdmissionError struct {
+	Violations []AdmissionViolation `json:"violations"`
+}
+
+func (e *AdmissionError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Message)
+	}
+	return "admission denied: " + strings.Join(msgs, "; ")
+}
+
 func (s *AgentService) CreateContainer(agentURL string, req map[string]any) (*CreateContainerResponse, error) {
 
 	endpoint := "/api/v1/containers"
@@ -330,6 +769,12 @@ func (s *AgentService) CreateContainer(agentURL string, req map[string]any) (*Cr
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode() == http.StatusUnprocessableEntity {
+		admissionErr := &AdmissionError{}
+		if err := json.Unmarshal(resp.Body(), admissionErr); err == nil && len(admissionErr.Violations) > 0 {
+			return nil, admissionErr
+		}
+	}
 	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
 		var bodyStr string
 		if resp.Body() != nil {
@@ -346,6 +791,216 @@ func (s *AgentService) CreateContainer(agentURL string, req map[string]any) (*Cr
 
 }
 
+type RecreateContainerResponse struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	OldImage    string         `json:"oldImage"`
+	Image       string         `json:"image"`
+	Ports       map[string]int `json:"ports,omitempty"`
+	StateCopied bool           `json:"stateCopied"`
+}
+
+// RecreateContainer replaces the container with an equivalent one running image, empty image re-pulls the current one.
+// The agent rolls back to the old container if the new one does not start. Rejections of the admission policy
+// are returned as AdmissionError.
+func (s *AgentService) RecreateContainer(ctx context.Context, agentURL string, containerName string, image string) (*RecreateContainerResponse, error) {
+	idResp, err := s.GetContainerIDByName(agentURL, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/api/v1/containers/%s/recreate", idResp.ID)
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetBody(map[string]string{"image": image}).
+		Post(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() == http.StatusUnprocessableEntity {
+		admissionErr := &AdmissionError{}
+		if err := json.Unmarshal(resp.Body(), admissionErr); err == nil && len(admissionErr.Violations) > 0 {
+			return nil, admissionErr
+		}
+	}
+	if resp.StatusCode() != 200 {
+		var bodyStr string
+		if resp.Body() != nil {
+			bodyStr = string(resp.Body())
+		}
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), bodyStr)
+	}
+
+	var result RecreateContainerResponse
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+type PullImageResponse struct {
+	Image  string `json:"image"`
+	Status string `json:"status"` // "pulled" or "present"
+}
+
+// PullImage makes sure the image is present on the agent, pulling it if needed. With always the agent pulls
+// even if it has the image, so a moving tag gets its new image. It blocks until the pull is done, cancelling ctx aborts it.
+func (s *AgentService) PullImage(ctx context.Context, agentURL string, image string, always bool) (*PullImageResponse, error) {
+	endpoint := "/api/v1/images/pull"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetBody(map[string]any{"image": image, "always": always}).
+		Post(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+	var result PullImageResponse
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ImagePullProgress is one per-layer progress event of an image pull on an agent
+type ImagePullProgress struct {
+	Image   string `json:"image"`
+	Layer   string `json:"layer,omitempty"`
+	Status  string `json:"status"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
+
+// PullImageStream is PullImage with the pull progress of the agent reported to onProgress.
+func (s *AgentService) PullImageStream(ctx context.Context, agentURL string, image string, always bool, onProgress func(ImagePullProgress)) (*PullImageResponse, error) {
+	endpoint := "/api/v1/images/pull"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	respF, err := s.restyAdapter.R().
+		SetContext(ctx).
+		SetDoNotParseResponse(true).
+		SetHeader("Accept", "text/event-stream").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetBody(map[string]any{"image": image, "always": always}).
+		Post(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	body := respF.RawBody()
+	defer body.Close()
+	if respF.StatusCode() != 200 {
+		bodyStr, _ := io.ReadAll(body)
+		return nil, fmt.Errorf("request failed with status %d: %s", respF.StatusCode(), bodyStr)
+	}
+
+	// server-sent events: "event: <name>" and "data: <json>" lines, a blank line ends an event
+	event := ""
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "progress":
+			var p ImagePullProgress
+			if err := json.Unmarshal([]byte(data), &p); err == nil && onProgress != nil {
+				onProgress(p)
+			}
+		case "done":
+			var result PullImageResponse
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				return nil, err
+			}
+			return &result, nil
+		case "error":
+			var result struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to pull image %s: %s", image, result.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("pull stream of %s ended without result", image)
+}
+
+// RegistryCredential authenticates image pulls from one private registry
+type RegistryCredential struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// String never prints the secret, in case a credential ends up in a log statement
+func (c RegistryCredential) String() string {
+	return fmt.Sprintf("RegistryCredential{username: %q, secret: [redacted]}", c.Username)
+}
+
+type RegistryCredentialsResponse struct {
+	Registries []string `json:"registries"`
+}
+
+// ListRegistryCredentials returns the registry hosts the agent has credentials for
+func (s *AgentService) ListRegistryCredentials(agentURL string) (*RegistryCredentialsResponse, error) {
+	endpoint := "/api/v1/registry/credentials"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		Get(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+	var result RegistryCredentialsResponse
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PushRegistryCredentials replaces all registry credentials of the agent, it applies them without a restart
+func (s *AgentService) PushRegistryCredentials(agentURL string, creds map[string]RegistryCredential) (*RegistryCredentialsResponse, error) {
+	endpoint := "/api/v1/registry/credentials"
+	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
+	resp, err := s.restyAdapter.R().
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Agent-Key", s.agentKey).
+		SetBody(map[string]any{"registries": creds}).
+		Put(agentAPI)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+	var result RegistryCredentialsResponse
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 func (s *AgentService) FetchMetrics(agentURL string) (*FetchMetricsResponse, error) {
 
 	endpoint := "/api/v1/metrics"
@@ -501,6 +1156,37 @@ func (s *AgentService) RetrieveAllAgentData(ctx context.Context) ([]AgentService
 	return agentServices, nil
 }
 
+// ResolveAgentURLs validates the requested agent urls, no request resolves to all registered agents
+func (s *AgentService) ResolveAgentURLs(ctx context.Context, requested []string) ([]string, error) {
+	agents, err := s.RetrieveAllAgentData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	registered := []string{}
+	for _, agent := range agents {
+		registered = append(registered, fmt.Sprintf("%s://%s", agent.MainHostProto, agent.MainHost))
+	}
+	if len(requested) == 0 {
+		if len(registered) == 0 {
+			return nil, fmt.Errorf("no agents found")
+		}
+		return registered, nil
+	}
+
+	out := []string{}
+	for _, agent := range requested {
+		agent = strings.TrimSpace(agent)
+		if agent == "" || slices.Contains(out, agent) {
+			continue
+		}
+		if !slices.Contains(registered, agent) {
+			return nil, fmt.Errorf("agent %s is not registered", agent)
+		}
+		out = append(out, agent)
+	}
+	return out, nil
+}
+
 func (s *AgentService) GetAgentTags(agentURL string) (map[string]any, error) {
 	endpoint := "/api/v1/tags"
 	agentAPI := fmt.Sprintf("%s%s", agentURL, endpoint)
@@ -29,6 +29,9 @@ type AuthService struct {
 	log           zerolog.Logger
 	SessionDriver *xsession.RedisSessionManager
 	SessionCookie string
+	// SessionCookieDomain scopes the session cookie to a parent domain so it is also sent to port subdomains,
+	// empty keeps it on the host that set it
+	SessionCookieDomain string
 }
 
 func NewAuthService(
@@ -38,8 +41,9 @@ func NewAuthService(
 	log zerolog.Logger,
 	sm *xsession.RedisSessionManager,
 	sessionCookie string,
+	sessionCookieDomain string,
 ) *AuthService {
-	return &AuthService{jwtService, tmpl, withTLS, log, sm, sessionCookie}
+	return &AuthService{jwtService, tmpl, withTLS, log, sm, sessionCookie, sessionCookieDomain}
 }
 
 func (s *AuthService) IsLoggedIn(c echo.Context) (string, string, []string, bool, error) {
@@ -77,7 +81,7 @@ func (s *AuthService) IsLoggedIn(c echo.Context) (string, string, []string, bool
 			return uname, sessionID, groups, true, nil
 		} else if !errors.Is(err, jwt.ErrTokenExpired) {
 			s.log.Warn().Msgf("Possible Theft access token detected for session: %s", sessionID)
-			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie)
+			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie, s.SessionCookieDomain)
 			return "", "", []string{}, false, &xerror.ErrJWTAccessTokenValidationError{}
 		} else {
 			s.log.Debug().Msgf("Access token validation err: %s, %v, %v", uname, groups, err)
@@ -87,7 +91,7 @@ func (s *AuthService) IsLoggedIn(c echo.Context) (string, string, []string, bool
 	refreshToken, err := s.SessionDriver.GetRefreshBySID(ctx, userID, sessionID)
 	if err != nil {
 		if err.Error() == "not found" {
-			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie)
+			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie, s.SessionCookieDomain)
 			return "", "", []string{}, false, &xerror.ErrJWTRefreshTokenNotFound{}
 		} else {
 			return "", "", []string{}, false, err
@@ -97,11 +101,11 @@ func (s *AuthService) IsLoggedIn(c echo.Context) (string, string, []string, bool
 	uname, groups, verr := s.jwtService.JWTValidateRefreshToken(refreshToken, ip, ua)
 	if verr != nil {
 		if errors.Is(verr, jwt.ErrTokenExpired) {
-			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie)
+			security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie, s.SessionCookieDomain)
 			return "", "", []string{}, false, &xerror.ErrJWTRefreshTokenExpired{}
 		}
 		s.log.Warn().Msgf("Possible Theft refresh token detected for session: %s", sessionID)
-		security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie)
+		security.ExpireSessionCookie(c, s.withTLS, s.SessionCookie, s.SessionCookieDomain)
 		return "", "", []string{}, false, &xerror.ErrJWTRefreshTokenValidationError{}
 	}
 
@@ -153,8 +157,8 @@ func (s *AuthService) GenTokensAndSave(username string, groups []string, c echo.
 		c.String(500, "500")
 	}
 	sessionCookie := &http.Cookie{
-		Name: s.SessionCookie,
-		//Domain:   "localhost",
+		Name:     s.SessionCookie,
+		Domain:   s.SessionCookieDomain,
 		Value:    sessionID,
 		Path:     "/",
 		HttpOnly: true,
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// Memory alert kinds reported by agents
+const (
+	MemoryAlertOOMKilled = "oom_killed"
+	MemoryAlertOOM       = "oom"
+	MemoryAlertPressure  = "memory_pressure"
+)
+
+const (
+	// containerAlertsMax is the length of the per user alert list kept in redis
+	containerAlertsMax = 20
+	// recentAlertsKey holds the latest alerts of all users for the admin view
+	recentAlertsKey = "alerts-recent"
+	recentAlertsMax = 100
+)
+
+// MemoryAlert is an OOM kill or memory pressure report of an agent, memory values are GB
+type MemoryAlert struct {
+	User          string  `json:"user,omitempty"`
+	InstanceID    string  `json:"instanceID"`
+	AgentHost     string  `json:"agentHost,omitempty"`
+	ContainerID   string  `json:"containerID"`
+	ContainerName string  `json:"containerName"`
+	Kind          string  `json:"kind"`
+	MemoryUsage   float64 `json:"memory_usage"`
+	MemoryLimit   float64 `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	PeakUsage     float64 `json:"peak_usage"`
+	Time          string  `json:"time"`
+}
+
+type ContainerAlertService struct {
+	rdb *redis.Client
+	reg *ContainerRegistryService
+	log zerolog.Logger
+}
+
+func NewContainerAlertService(rdb *redis.Client, reg *ContainerRegistryService, log zerolog.Logger) *ContainerAlertService {
+	return &ContainerAlertService{rdb, reg, log}
+}
+
+func (s *ContainerAlertService) alertsKey(user string) string {
+	return "alerts:" + user
+}
+
+func (s *ContainerAlertService) dismissedKey(user string) string {
+	return "alerts-dismissed:" + user
+}
+
+// Record stores the alert for its owner and in the recent list, then publishes it
+func (s *ContainerAlertService) Record(ctx context.Context, alert *MemoryAlert) error {
+	cntInfo, err := s.reg.FindByContainerName(ctx, alert.AgentHost, alert.ContainerName)
+	if err != nil {
+		return err
+	}
+	alert.User = cntInfo.User
+	if alert.Time == "" {
+		alert.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory alert: %w", err)
+	}
+
+	key := s.alertsKey(alert.User)
+	pipe := s.rdb.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, containerAlertsMax-1)
+	pipe.LPush(ctx, recentAlertsKey, data)
+	pipe.LTrim(ctx, recentAlertsKey, 0, recentAlertsMax-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save memory alert: %w", err)
+	}
+
+	if err := s.rdb.Publish(ctx, ContainerEventsChannel, data).Err(); err != nil {
+		s.log.Warn().Err(err).Msgf("failed to publish %s alert of %s", alert.Kind, alert.ContainerName)
+	}
+	return nil
+}
+
+func (s *ContainerAlertService) list(ctx context.Context, key string, limit int64) ([]MemoryAlert, error) {
+	vals, err := s.rdb.LRange(ctx, key, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory alerts: %w", err)
+	}
+	alerts := []MemoryAlert{}
+	for _, val := range vals {
+		var a MemoryAlert
+		if err := json.Unmarshal([]byte(val), &a); err == nil {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts, nil
+}
+
+// List returns the alerts of the user, newest first
+func (s *ContainerAlertService) List(ctx context.Context, user string) ([]MemoryAlert, error) {
+	return s.list(ctx, s.alertsKey(user), containerAlertsMax)
+}
+
+// ListRecent returns the latest alerts of all users, newest first
+func (s *ContainerAlertService) ListRecent(ctx context.Context) ([]MemoryAlert, error) {
+	return s.list(ctx, recentAlertsKey, recentAlertsMax)
+}
+
+// Active returns the alerts the user has not dismissed yet
+func (s *ContainerAlertService) Active(ctx context.Context, user string) ([]MemoryAlert, error) {
+	alerts, err := s.List(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	dismissedAt := time.Time{}
+	if val, err := s.rdb.Get(ctx, s.dismissedKey(user)).Result(); err == nil {
+		dismissedAt, _ = time.Parse(time.RFC3339Nano, val)
+	} else if err != redis.Nil {
+		return nil, fmt.Errorf("failed to get dismissed alerts: %w", err)
+	}
+
+	// alert times have second precision, an alert of the second the user dismissed in may be newer and stays
+	dismissedAt = dismissedAt.Truncate(time.Second)
+	active := []MemoryAlert{}
+	for _, a := range alerts {
+		t, err := time.Parse(time.RFC3339, a.Time)
+		if err == nil && t.Before(dismissedAt) {
+			break
+		}
+		active = append(active, a)
+	}
+	return active, nil
+}
+
+// Dismiss hides the current alerts of the user from the home page
+func (s *ContainerAlertService) Dismiss(ctx context.Context, user string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if err := s.rdb.Set(ctx, s.dismissedKey(user), now, 0).Err(); err != nil {
+		return fmt.Errorf("failed to dismiss alerts: %w", err)
+	}
+	return nil
+}
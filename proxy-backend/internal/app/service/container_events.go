@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// ContainerEventsChannel is the pub/sub channel container lifecycle events are published on
+const ContainerEventsChannel = "container-events"
+
+// containerEventsMax is the length of the per user event timeline kept in redis
+const containerEventsMax = 50
+
+// ContainerEvent is a docker lifecycle event reported by an agent
+type ContainerEvent struct {
+	User          string `json:"user,omitempty"`
+	InstanceID    string `json:"instanceID"`
+	AgentHost     string `json:"agentHost,omitempty"`
+	ContainerID   string `json:"containerID"`
+	ContainerName string `json:"containerName"`
+	Action        string `json:"action"`
+	Status        string `json:"status,omitempty"`
+	ExitCode      string `json:"exitCode,omitempty"`
+	Image         string `json:"image,omitempty"`
+	Time          string `json:"time"`
+}
+
+type ContainerEventService struct {
+	rdb *redis.Client
+	reg *ContainerRegistryService
+	log zerolog.Logger
+}
+
+func NewContainerEventService(rdb *redis.Client, reg *ContainerRegistryService, log zerolog.Logger) *ContainerEventService {
+	return &ContainerEventService{rdb, reg, log}
+}
+
+func (s *ContainerEventService) eventsKey(user string) string {
+	return "events:" + user
+}
+
+// Record appends the event to the owner's timeline and publishes it
+func (s *ContainerEventService) Record(ctx context.Context, ev *ContainerEvent) error {
+	cntInfo, err := s.reg.FindByContainerName(ctx, ev.AgentHost, ev.ContainerName)
+	if err != nil {
+		return err
+	}
+	ev.User = cntInfo.User
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal container event: %w", err)
+	}
+
+	key := s.eventsKey(ev.User)
+	pipe := s.rdb.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, containerEventsMax-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save container event: %w", err)
+	}
+
+	if err := s.rdb.Publish(ctx, ContainerEventsChannel, data).Err(); err != nil {
+		s.log.Warn().Err(err).Msgf("failed to publish %s event of %s", ev.Action, ev.ContainerName)
+	}
+	return nil
+}
+
+// List returns the latest events of the user, newest first
+func (s *ContainerEventService) List(ctx context.Context, user string, limit int) ([]ContainerEvent, error) {
+	if limit <= 0 || limit > containerEventsMax {
+		limit = containerEventsMax
+	}
+	vals, err := s.rdb.LRange(ctx, s.eventsKey(user), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container events: %w", err)
+	}
+	events := []ContainerEvent{}
+	for _, val := range vals {
+		var ev ContainerEvent
+		if err := json.Unmarshal([]byte(val), &ev); err == nil {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
+}
@@ -3,7 +3,11 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,11 +16,96 @@ import (
 
 type ContainerInfo struct {
 	User          string `json:"user"`
+	Workspace     string `json:"workspace,omitempty"`
 	ContainerName string `json:"container_name"`
 	AgentHost     string `json:"agent_host"`
+	Profile       string `json:"profile,omitempty"`
 	CreatedAt     string `json:"created_at"`
+	StopReason    string `json:"stop_reason,omitempty"`
+	StoppedAt     string `json:"stopped_at,omitempty"`
+
+	// Ports are the host ports the agent reserved for the container by placeholder name
+	Ports map[string]int `json:"ports,omitempty"`
+
+	// Grants give other users or groups access to the workspace
+	Grants []WorkspaceGrant `json:"grants,omitempty"`
+
+	// ForwardedPorts are the container ports declared for forwarding, the agent only proxies these
+	ForwardedPorts []ForwardedPort `json:"forwarded_ports,omitempty"`
+
+	// PortVisibility opens forwarded ports to groups or public links by port number, other ports are private
+	PortVisibility map[string]PortVisibility `json:"port_visibility,omitempty"`
+}
+
+// Stop reasons recorded in ContainerInfo.StopReason.
+const (
+	// the idle stop controller stopped the container
+	StopReasonIdle = "idle"
+	// the container is being moved to another agent, it must not be started meanwhile
+	StopReasonMigrating = "migrating"
+	// the container is being replaced by one with a new image, it must not be started meanwhile
+	StopReasonRecreating = "recreating"
+)
+
+// DefaultWorkspace is the workspace of requests that do not name one. Containers registered before
+// users could have several workspaces belong to it.
+const DefaultWorkspace = "default"
+
+var workspaceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,31}$`)
+
+// reservedWorkspaceNames are first path segments of code-server and of the agent proxy,
+// a workspace with one of these names would shadow them under /code-server/
+var reservedWorkspaceNames = []string{"request", "update", "login", "logout", "healthz", "proxy", "absproxy", "static"}
+
+// NormalizeWorkspace lower-cases the workspace name, empty is the default workspace
+func NormalizeWorkspace(workspace string) string {
+	workspace = strings.ToLower(strings.TrimSpace(workspace))
+	if workspace == "" {
+		return DefaultWorkspace
+	}
+	return workspace
+}
+
+// ValidateWorkspaceName checks a name for a new workspace
+func ValidateWorkspaceName(workspace string) error {
+	if !workspaceNamePattern.MatchString(workspace) {
+		return fmt.Errorf("workspace name must be 1-32 lower case letters, digits or underscores, starting with a letter or digit")
+	}
+	if slices.Contains(reservedWorkspaceNames, workspace) {
+		return fmt.Errorf("workspace name %s is reserved", workspace)
+	}
+	return nil
+}
+
+// WorkspaceContainerName is the container name of a workspace, the default workspace keeps the name of before workspaces.
+// A user name with "--" can give the name of a workspace of another user, creates check the name is free.
+func WorkspaceContainerName(user, workspace string) string {
+	if workspace == DefaultWorkspace {
+		return fmt.Sprintf("code-server-%s", user)
+	}
+	return fmt.Sprintf("code-server-%s--%s", user, workspace)
+}
+
+// WorkspaceID identifies a workspace in the keys of other services, the default workspace keeps the plain user name
+func WorkspaceID(user, workspace string) string {
+	workspace = NormalizeWorkspace(workspace)
+	if workspace == DefaultWorkspace {
+		return user
+	}
+	return user + ":" + workspace
 }
 
+// ErrContainerNotFound is returned when the registry has no entry for a workspace or container.
+var ErrContainerNotFound = errors.New("container not found")
+
+// containerNamesKey indexes the entries by container, "<agent host>|<container name>" -> "<user>/<workspace>"
+const containerNamesKey = "container-names"
+
+func containerNameField(agentHost, containerName string) string {
+	return agentHost + "|" + containerName
+}
+
+// ContainerRegistryService keeps the workspaces of every user in the hash workspaces:<user>, workspace -> ContainerInfo
 type ContainerRegistryService struct {
 	rdb *redis.Client
 	log zerolog.Logger
@@ -26,32 +115,100 @@ func NewContainerRegistryService(rdb *redis.Client, log zerolog.Logger) *Contain
 	return &ContainerRegistryService{rdb, log}
 }
 
+func (s *ContainerRegistryService) workspacesKey(user string) string {
+	return "workspaces:" + user
+}
+
+// scanKeys returns the keys matching pattern once each, SCAN does not block redis like KEYS on a large keyspace
+func (s *ContainerRegistryService) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	keys := []string{}
+	iter := s.rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+// MigrateLegacyEntries moves the container:<user> entries of before workspaces into the default workspace of their user.
+// An existing default workspace is kept.
+func (s *ContainerRegistryService) MigrateLegacyEntries(ctx context.Context) (int, error) {
+	legacyKeys, err := s.scanKeys(ctx, "container:*")
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range legacyKeys {
+		val, err := s.rdb.Get(ctx, key).Result()
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			return migrated, err
+		}
+		var containerInfo ContainerInfo
+		if err := json.Unmarshal([]byte(val), &containerInfo); err != nil {
+			s.log.Warn().Err(err).Msgf("skipping invalid legacy registry entry %s", key)
+			continue
+		}
+		if containerInfo.User == "" {
+			containerInfo.User = strings.TrimPrefix(key, "container:")
+		}
+		containerInfo.Workspace = DefaultWorkspace
+		data, err := json.Marshal(&containerInfo)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to marshal container info: %w", err)
+		}
+		_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSetNX(ctx, s.workspacesKey(containerInfo.User), DefaultWorkspace, data)
+			pipe.Del(ctx, key)
+			return nil
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("failed to migrate %s: %w", key, err)
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		s.log.Info().Msgf("Moved %d container entries into default workspaces", migrated)
+	}
+	return migrated, nil
+}
+
 func (s *ContainerRegistryService) Add(ctx context.Context, containerInfo *ContainerInfo) error {
 	if containerInfo.CreatedAt == "" {
 		containerInfo.CreatedAt = time.Now().UTC().Format(time.RFC3339)
 	}
+	containerInfo.Workspace = NormalizeWorkspace(containerInfo.Workspace)
 
 	data, err := json.Marshal(containerInfo)
 	if err != nil {
 		return fmt.Errorf("failed to marshal container info: %w", err)
 	}
 
-	containerKey := fmt.Sprintf("container:%s", containerInfo.User)
-	if err := s.rdb.Set(ctx, containerKey, data, 0).Err(); err != nil {
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.workspacesKey(containerInfo.User), containerInfo.Workspace, data)
+		pipe.HSet(ctx, containerNamesKey, containerNameField(containerInfo.AgentHost, containerInfo.ContainerName), containerInfo.User+"/"+containerInfo.Workspace)
+		mirrorForwardedPorts(ctx, pipe, containerInfo.User, containerInfo)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to save container info to Redis: %w", err)
 	}
 
-	s.log.Info().Msgf("Saved container info for %s -> %s -> %s", containerInfo.ContainerName, containerInfo.AgentHost, containerInfo.User)
+	s.log.Info().Msgf("Saved container info for %s -> %s -> %s/%s", containerInfo.ContainerName, containerInfo.AgentHost, containerInfo.User, containerInfo.Workspace)
 	return nil
 }
 
-// Get container agent info
-func (s *ContainerRegistryService) Get(ctx context.Context, user string) (*ContainerInfo, error) {
-	containerKey := fmt.Sprintf("container:%s", user)
-	val, err := s.rdb.Get(ctx, containerKey).Result()
+// Get returns the container of a workspace of the user, empty workspace is the default one
+func (s *ContainerRegistryService) Get(ctx context.Context, user, workspace string) (*ContainerInfo, error) {
+	val, err := s.rdb.HGet(ctx, s.workspacesKey(user), NormalizeWorkspace(workspace)).Result()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, fmt.Errorf("container not found")
+			return nil, ErrContainerNotFound
 		}
 		return nil, fmt.Errorf("failed to get container info: %w", err)
 	}
@@ -64,38 +221,202 @@ func (s *ContainerRegistryService) Get(ctx context.Context, user string) (*Conta
 	return &containerInfo, nil
 }
 
-// Remove container agent info
-func (s *ContainerRegistryService) Remove(ctx context.Context, user string) error {
-	containerKey := fmt.Sprintf("container:%s", user)
-	n, err := s.rdb.Del(ctx, containerKey).Result()
+// List returns the workspaces of the user, the default workspace first and the others by name
+func (s *ContainerRegistryService) List(ctx context.Context, user string) ([]ContainerInfo, error) {
+	vals, err := s.rdb.HGetAll(ctx, s.workspacesKey(user)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container info: %w", err)
+	}
+	containers := []ContainerInfo{}
+	for _, val := range vals {
+		var c ContainerInfo
+		if err := json.Unmarshal([]byte(val), &c); err == nil {
+			containers = append(containers, c)
+		}
+	}
+	sortWorkspaces(containers)
+	return containers, nil
+}
+
+func sortWorkspaces(containers []ContainerInfo) {
+	slices.SortFunc(containers, func(a, b ContainerInfo) int {
+		if a.User != b.User {
+			return strings.Compare(a.User, b.User)
+		}
+		if a.Workspace == DefaultWorkspace || b.Workspace == DefaultWorkspace {
+			if a.Workspace == b.Workspace {
+				return 0
+			}
+			if a.Workspace == DefaultWorkspace {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Workspace, b.Workspace)
+	})
+}
+
+// Remove deletes the registry entry of a workspace of the user
+func (s *ContainerRegistryService) Remove(ctx context.Context, user, workspace string) error {
+	workspace = NormalizeWorkspace(workspace)
+	containerInfo, err := s.Get(ctx, user, workspace)
+	if err != nil {
+		return err
+	}
+	var removed *redis.IntCmd
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.HDel(ctx, s.workspacesKey(user), workspace)
+		pipe.HDel(ctx, containerNamesKey, containerNameField(containerInfo.AgentHost, containerInfo.ContainerName))
+		pipe.Del(ctx, forwardedPortsKey(user, containerInfo.ContainerName))
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("failed to remove container info: %w", err)
 	}
-	if n == 0 {
-		return fmt.Errorf("container not found")
+	if removed.Val() == 0 {
+		return ErrContainerNotFound
 	}
 
-	s.log.Info().Msgf("Removed container info for %s", user)
+	s.log.Info().Msgf("Removed container info for %s/%s", user, workspace)
 	return nil
 }
 
-// Get All Containers
+// GetAll returns the workspaces of all users
 func (s *ContainerRegistryService) GetAll(ctx context.Context) ([]ContainerInfo, error) {
-	containerKeys, err := s.rdb.Keys(ctx, "container:*").Result()
+	workspaceKeys, err := s.scanKeys(ctx, "workspaces:*")
 	if err != nil {
 		return nil, err
 	}
 	containers := []ContainerInfo{}
-	for _, key := range containerKeys {
-		val, err := s.rdb.Get(ctx, key).Result()
+	for _, key := range workspaceKeys {
+		vals, err := s.rdb.HGetAll(ctx, key).Result()
 		if err != nil {
 			return nil, err
 		}
-		var c ContainerInfo
-		if err := json.Unmarshal([]byte(val), &c); err == nil {
-			containers = append(containers, c)
+		for _, val := range vals {
+			var c ContainerInfo
+			if err := json.Unmarshal([]byte(val), &c); err == nil {
+				containers = append(containers, c)
+			}
 		}
 	}
+	sortWorkspaces(containers)
 	return containers, nil
 
 }
+
+// SetStopReason records why and when a container was stopped. Empty reason clears it.
+func (s *ContainerRegistryService) SetStopReason(ctx context.Context, user, workspace, reason string) error {
+	return s.update(ctx, user, workspace, func(c *ContainerInfo) error {
+		c.StopReason = reason
+		c.StoppedAt = ""
+		if reason != "" {
+			c.StoppedAt = time.Now().UTC().Format(time.RFC3339)
+		}
+		return nil
+	})
+}
+
+// restoreStop puts back a stop reason and time saved before SetStopReason replaced them
+func (s *ContainerRegistryService) restoreStop(ctx context.Context, user, workspace, reason, stoppedAt string) error {
+	return s.update(ctx, user, workspace, func(c *ContainerInfo) error {
+		c.StopReason = reason
+		c.StoppedAt = stoppedAt
+		return nil
+	})
+}
+
+// RebuildNameIndex indexes every registry entry by agent host and container name, entries saved before the
+// index existed are found by FindByContainerName afterwards
+func (s *ContainerRegistryService) RebuildNameIndex(ctx context.Context) error {
+	containers, err := s.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	fields := make(map[string]any, len(containers))
+	for _, c := range containers {
+		fields[containerNameField(c.AgentHost, c.ContainerName)] = c.User + "/" + c.Workspace
+	}
+	return s.rdb.HSet(ctx, containerNamesKey, fields).Err()
+}
+
+// FindByContainerName returns the registry entry owning the container of the agent.
+// Agents that do not report their host fall back to a scan of all entries.
+func (s *ContainerRegistryService) FindByContainerName(ctx context.Context, agentHost, name string) (*ContainerInfo, error) {
+	if agentHost == "" {
+		containers, err := s.GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range containers {
+			if containers[i].ContainerName == name {
+				return &containers[i], nil
+			}
+		}
+		return nil, ErrContainerNotFound
+	}
+
+	val, err := s.rdb.HGet(ctx, containerNamesKey, containerNameField(agentHost, name)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrContainerNotFound
+		}
+		return nil, fmt.Errorf("failed to get container index: %w", err)
+	}
+	i := strings.LastIndex(val, "/")
+	if i < 0 {
+		return nil, ErrContainerNotFound
+	}
+	containerInfo, err := s.Get(ctx, val[:i], val[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	// the index entry outlives a workspace that was overwritten with another container
+	if containerInfo.ContainerName != name || containerInfo.AgentHost != agentHost {
+		return nil, ErrContainerNotFound
+	}
+	return containerInfo, nil
+}
+
+// SwapAgentHost atomically moves a workspace from one agent to another together with the host ports reserved there.
+// It fails if the entry changed or does not point to from anymore.
+func (s *ContainerRegistryService) SwapAgentHost(ctx context.Context, user, workspace, from, to string, ports map[string]int) error {
+	workspacesKey := s.workspacesKey(user)
+	workspace = NormalizeWorkspace(workspace)
+	return s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		val, err := tx.HGet(ctx, workspacesKey, workspace).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return ErrContainerNotFound
+			}
+			return fmt.Errorf("failed to get container info: %w", err)
+		}
+		var containerInfo ContainerInfo
+		if err := json.Unmarshal([]byte(val), &containerInfo); err != nil {
+			return fmt.Errorf("failed to unmarshal container info: %w", err)
+		}
+		if containerInfo.AgentHost != from {
+			return fmt.Errorf("container of %s/%s is on %s, expected %s", user, workspace, containerInfo.AgentHost, from)
+		}
+
+		containerInfo.AgentHost = to
+		containerInfo.Ports = ports
+		data, err := json.Marshal(&containerInfo)
+		if err != nil {
+			return fmt.Errorf("failed to marshal container info: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, workspacesKey, workspace, data)
+			pipe.HDel(ctx, containerNamesKey, containerNameField(from, containerInfo.ContainerName))
+			pipe.HSet(ctx, containerNamesKey, containerNameField(to, containerInfo.ContainerName), user+"/"+workspace)
+			return nil
+		})
+		if err == nil {
+			s.log.Info().Msgf("Moved container info of %s/%s from %s to %s", user, workspace, from, to)
+		}
+		return err
+	}, workspacesKey)
+}
@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// Create job states, in the order a job goes through them
+const (
+	CreateJobQueued   = "queued"
+	CreateJobPulling  = "pulling"
+	CreateJobCreating = "creating"
+	CreateJobStarting = "starting"
+	CreateJobReady    = "ready"
+	CreateJobFailed   = "failed"
+)
+
+const (
+	// createJobTTL is how long a job is kept after its last update
+	createJobTTL = 24 * time.Hour
+	// createJobLockTTL guards against a crashed proxy-backend holding the per-user lock forever
+	createJobLockTTL = 30 * time.Minute
+	// createJobIdempotencyTTL is how long a repeated submit with the same key returns the same job
+	createJobIdempotencyTTL = time.Hour
+	createJobPullTimeout    = 20 * time.Minute
+	createJobReadyTimeout   = 2 * time.Minute
+	createJobReadyInterval  = 2 * time.Second
+	// createJobProgressInterval limits how often pull progress is saved and published
+	createJobProgressInterval = time.Second
+)
+
+// ErrCreateJobConflict is returned for a create while the user has a running job for another workspace
+var ErrCreateJobConflict = errors.New("another workspace is being created")
+
+type CreateJobStep struct {
+	State   string `json:"state"`
+	Message string `json:"message"`
+	At      string `json:"at"`
+}
+
+// CreateJob is the progress of creating and starting a user's container
+type CreateJob struct {
+	ID            string               `json:"id"`
+	User          string               `json:"user"`
+	Workspace     string               `json:"workspace"`
+	ContainerName string               `json:"container_name"`
+	AgentHost     string               `json:"agent_host"`
+	Profile       string               `json:"profile,omitempty"`
+	Image         string               `json:"image,omitempty"`
+	State         string               `json:"state"`
+	Message       string               `json:"message"`
+	Progress      int                  `json:"progress,omitempty"` // pull progress in percent
+	Error         string               `json:"error,omitempty"`
+	Violations    []AdmissionViolation `json:"violations,omitempty"`
+	Ports         map[string]int       `json:"ports,omitempty"`
+	Steps         []CreateJobStep      `json:"steps"`
+	CreatedAt     string               `json:"created_at"`
+	UpdatedAt     string               `json:"updated_at"`
+	FinishedAt    string               `json:"finished_at,omitempty"`
+
+	// Form holds the create form fields needed to edit and send a rejected request again, env without values
+	Form url.Values `json:"form,omitempty"`
+}
+
+// Done reports whether the job reached a final state
+func (j *CreateJob) Done() bool {
+	return j.State == CreateJobReady || j.State == CreateJobFailed
+}
+
+// CreateJobRequest is the validated create request prepared by the handler
+type CreateJobRequest struct {
+	User          string
+	Workspace     string
+	AgentHost     string
+	ContainerName string
+	Profile       string
+	Spec          map[string]any
+	Form          url.Values
+}
+
+// CreateJobService runs container creation in background and records its progress in redis
+type CreateJobService struct {
+	rdb          *redis.Client
+	reg          *ContainerRegistryService
+	agentService *AgentService
+	activity     *ActivityService
+	readyPort    int
+	log          zerolog.Logger
+}
+
+func NewCreateJobService(rdb *redis.Client, reg *ContainerRegistryService, agentService *AgentService, activity *ActivityService, readyPort int, log zerolog.Logger) *CreateJobService {
+	return &CreateJobService{rdb, reg, agentService, activity, readyPort, log}
+}
+
+func (s *CreateJobService) jobKey(id string) string {
+	return "createjob:" + id
+}
+
+func (s *CreateJobService) lockKey(user string) string {
+	return "createjob-active:" + user
+}
+
+func (s *CreateJobService) idempotencyKey(user, key string) string {
+	return "createjob-idem:" + user + ":" + key
+}
+
+// UpdatesChannel is the pub/sub channel every update of the job is published to
+func (s *CreateJobService) UpdatesChannel(id string) string {
+	return "createjob-updates:" + id
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Get returns the job with the given id
+func (s *CreateJobService) Get(ctx context.Context, id string) (*CreateJob, error) {
+	val, err := s.rdb.Get(ctx, s.jobKey(id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("create job not found")
+		}
+		return nil, fmt.Errorf("failed to get create job: %w", err)
+	}
+	var job CreateJob
+	if err := json.Unmarshal([]byte(val), &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal create job: %w", err)
+	}
+	return &job, nil
+}
+
+// Active returns the running job of the user, if any
+func (s *CreateJobService) Active(ctx context.Context, user string) (*CreateJob, error) {
+	id, err := s.rdb.Get(ctx, s.lockKey(user)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("create job not found")
+		}
+		return nil, fmt.Errorf("failed to get create job: %w", err)
+	}
+	return s.Get(ctx, id)
+}
+
+// activeFor returns the running job of the user for the workspace. The user has one job at a time,
+// a running job for another workspace gives ErrCreateJobConflict.
+func (s *CreateJobService) activeFor(ctx context.Context, user, workspace string) (*CreateJob, error) {
+	job, err := s.Active(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if NormalizeWorkspace(job.Workspace) != NormalizeWorkspace(workspace) {
+		return nil, fmt.Errorf("%w: workspace %s is being created, try again when it is done", ErrCreateJobConflict, job.Workspace)
+	}
+	return job, nil
+}
+
+// Existing returns the job already submitted with the idempotency key, or else the running job of the user for the workspace
+func (s *CreateJobService) Existing(ctx context.Context, user, workspace, idempotencyKey string) (*CreateJob, error) {
+	if idempotencyKey != "" {
+		if id, err := s.rdb.Get(ctx, s.idempotencyKey(user, idempotencyKey)).Result(); err == nil {
+			return s.Get(ctx, id)
+		}
+	}
+	return s.activeFor(ctx, user, workspace)
+}
+
+// Subscribe returns a subscription to the updates of the job, the caller must close it
+func (s *CreateJobService) Subscribe(ctx context.Context, id string) *redis.PubSub {
+	return s.rdb.Subscribe(ctx, s.UpdatesChannel(id))
+}
+
+func (s *CreateJobService) save(ctx context.Context, job *CreateJob) {
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(job)
+	if err != nil {
+		s.log.Error().Err(err).Msgf("failed to marshal create job %s", job.ID)
+		return
+	}
+	if err := s.rdb.Set(ctx, s.jobKey(job.ID), data, createJobTTL).Err(); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save create job %s", job.ID)
+	}
+	if err := s.rdb.Publish(ctx, s.UpdatesChannel(job.ID), data).Err(); err != nil {
+		s.log.Warn().Err(err).Msgf("failed to publish create job %s", job.ID)
+	}
+}
+
+// Submit starts a create job. A submit with an already used idempotency key, or while the user
+// has a running job for the workspace, returns the existing job and created=false instead of starting
+// another one. A running job for another workspace gives ErrCreateJobConflict.
+func (s *CreateJobService) Submit(ctx context.Context, req *CreateJobRequest, idempotencyKey string) (job *CreateJob, created bool, err error) {
+	if job, err := s.Existing(ctx, req.User, req.Workspace, idempotencyKey); err == nil {
+		return job, false, nil
+	} else if errors.Is(err, ErrCreateJobConflict) {
+		return nil, false, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create job id: %w", err)
+	}
+	ok, err := s.rdb.SetNX(ctx, s.lockKey(req.User), id, createJobLockTTL).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to lock create job: %w", err)
+	}
+	if !ok {
+		job, err := s.activeFor(ctx, req.User, req.Workspace)
+		return job, false, err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	job = &CreateJob{
+		ID:            id,
+		User:          req.User,
+		Workspace:     req.Workspace,
+		ContainerName: req.ContainerName,
+		AgentHost:     req.AgentHost,
+		Profile:       req.Profile,
+		State:         CreateJobQueued,
+		Message:       "Waiting to start",
+		Steps:         []CreateJobStep{{State: CreateJobQueued, Message: "Waiting to start", At: now}},
+		CreatedAt:     now,
+		Form:          req.Form,
+	}
+	s.save(ctx, job)
+	if idempotencyKey != "" {
+		if err := s.rdb.Set(ctx, s.idempotencyKey(req.User, idempotencyKey), id, createJobIdempotencyTTL).Err(); err != nil {
+			s.log.Warn().Err(err).Msgf("failed to save idempotency key of create job %s", id)
+		}
+	}
+	s.log.Info().Msgf("create job %s queued for %s on %s", id, req.User, req.AgentHost)
+
+	go s.run(job, req)
+	return job, true, nil
+}
+
+// progress moves the job to state with a step message
+func (s *CreateJobService) progress(ctx context.Context, job *CreateJob, state, message string) {
+	job.State = state
+	job.Message = message
+	job.Steps = append(job.Steps, CreateJobStep{State: state, Message: message, At: time.Now().UTC().Format(time.RFC3339)})
+	s.save(ctx, job)
+}
+
+func (s *CreateJobService) fail(ctx context.Context, job *CreateJob, message string, err error) {
+	job.Error = err.Error()
+	var admissionErr *AdmissionError
+	if errors.As(err, &admissionErr) {
+		job.Violations = admissionErr.Violations
+	}
+	job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	s.progress(ctx, job, CreateJobFailed, message)
+	s.log.Error().Err(err).Msgf("create job %s of %s failed", job.ID, job.User)
+}
+
+func (s *CreateJobService) run(job *CreateJob, req *CreateJobRequest) {
+	ctx := context.Background()
+	defer s.rdb.Del(ctx, s.lockKey(job.User))
+
+	// pulling
+	image, _ := req.Spec["image"].(string)
+	if image == "" {
+		defaults, err := s.agentService.GetContainerDefaults(job.AgentHost, job.Profile)
+		if err != nil {
+			s.fail(ctx, job, "Failed to read the profile defaults", err)
+			return
+		}
+		image = defaults.Image
+	}
+	job.Image = image
+	s.progress(ctx, job, CreateJobPulling, fmt.Sprintf("Pulling image %s", image))
+	tracker := NewImagePullTracker()
+	lastSave := time.Now()
+	pullCtx, cancel := context.WithTimeout(ctx, createJobPullTimeout)
+	pulled, err := s.agentService.PullImageStream(pullCtx, job.AgentHost, image, false, func(p ImagePullProgress) {
+		percent, summary := tracker.Update(p)
+		// layer progress only updates the message, saved at most every createJobProgressInterval
+		if time.Since(lastSave) < createJobProgressInterval {
+			return
+		}
+		lastSave = time.Now()
+		job.Progress = percent
+		job.Message = fmt.Sprintf("Pulling image %s: %s", image, summary)
+		s.save(ctx, job)
+	})
+	cancel()
+	if err != nil {
+		s.fail(ctx, job, fmt.Sprintf("Failed to pull image %s", image), err)
+		return
+	}
+
+	job.Progress = 100
+
+	// creating
+	s.progress(ctx, job, CreateJobCreating, fmt.Sprintf("Image %s is %s, creating container", image, pulled.Status))
+	created, err := s.agentService.CreateContainer(job.AgentHost, req.Spec)
+	if err != nil {
+		s.fail(ctx, job, "Failed to create container", err)
+		return
+	}
+	job.Ports = created.Ports
+	containerInfo := &ContainerInfo{
+		User:          job.User,
+		Workspace:     job.Workspace,
+		ContainerName: job.ContainerName,
+		AgentHost:     job.AgentHost,
+		Profile:       job.Profile,
+		Ports:         created.Ports,
+	}
+	if err := s.reg.Add(ctx, containerInfo); err != nil {
+		// do not leave an unregistered container behind
+		if _, delErr := s.agentService.RemoveContainer(job.AgentHost, job.ContainerName); delErr != nil {
+			s.log.Error().Err(delErr).Msgf("failed to rollback container %s on agent %s", job.ContainerName, job.AgentHost)
+		}
+		s.fail(ctx, job, "Failed to register container", err)
+		return
+	}
+
+	// starting
+	s.progress(ctx, job, CreateJobStarting, "Starting container")
+	if _, err := s.agentService.StartContainer(job.AgentHost, job.ContainerName); err != nil {
+		s.fail(ctx, job, "Container was created but failed to start, you can start it from the home page", err)
+		return
+	}
+	if err := s.activity.Reset(ctx, WorkspaceID(job.User, job.Workspace)); err != nil {
+		s.log.Error().Err(err).Msgf("failed to reset activity for %s", WorkspaceID(job.User, job.Workspace))
+	}
+
+	message := "Container started, code-server is still starting"
+	deadline := time.Now().Add(createJobReadyTimeout)
+	for time.Now().Before(deadline) {
+		if ready, err := s.agentService.IsContainerReady(job.AgentHost, job.ContainerName, s.readyPort); err == nil && ready.Ready {
+			message = "Workspace is ready"
+			break
+		}
+		time.Sleep(createJobReadyInterval)
+	}
+
+	job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	s.progress(ctx, job, CreateJobReady, message)
+	s.log.Info().Msgf("create job %s of %s is ready", job.ID, job.User)
+}
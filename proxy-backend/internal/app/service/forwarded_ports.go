@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// forwardedPortLabelMax limits the length of the label of a forwarded port
+const forwardedPortLabelMax = 64
+
+// forwardedPortsSeededKey is set once the workspaces of before declared ports got the legacyForwardedPorts
+const forwardedPortsSeededKey = "forwarded-ports-seeded"
+
+// legacyForwardedPorts were forwarded for every container before ports were declared per workspace
+var legacyForwardedPorts = []int{80, 443, 3000, 5000, 8000, 8080, 8081, 8082, 9000}
+
+// portHostLabelPattern is a <port>-<workspace>-<user> label of a port subdomain. A user name that can not be
+// part of a host label, like one with ".", has no port subdomains.
+var portHostLabelPattern = regexp.MustCompile(`^[0-9]{1,5}-[a-z0-9_]{1,32}-[a-z0-9_-]+$`)
+
+// ValidPortHostLabel reports whether label can be the first label of a port subdomain
+func ValidPortHostLabel(label string) bool {
+	return len(label) <= 63 && portHostLabelPattern.MatchString(label)
+}
+
+// ErrPortNotFound is returned when a port of a workspace is not declared for forwarding.
+var ErrPortNotFound = errors.New("port not found")
+
+// ForwardedPortProtocols are the protocols of /code-server/request/<user>/<protocol>/<port>
+var ForwardedPortProtocols = []string{"http", "https", "sse", "sse-https"}
+
+// ForwardedPort is a container port declared for forwarding, the agent only proxies declared ports
+type ForwardedPort struct {
+	Port       int    `json:"port"`
+	Label      string `json:"label,omitempty"`
+	Protocol   string `json:"protocol"`
+	DeclaredBy string `json:"declared_by,omitempty"`
+	DeclaredAt string `json:"declared_at"`
+}
+
+// forwardedPortsKey is the hash the agent reads the declared ports of a container from, port -> protocol.
+// It mirrors ContainerInfo.ForwardedPorts so the agent does not depend on the layout of the registry entries.
+func forwardedPortsKey(user, containerName string) string {
+	return "forwarded-ports:" + user + "/" + containerName
+}
+
+// mirrorForwardedPorts queues the rewrite of the forwarded ports hash of the container of a workspace of user
+func mirrorForwardedPorts(ctx context.Context, pipe redis.Pipeliner, user string, c *ContainerInfo) {
+	key := forwardedPortsKey(user, c.ContainerName)
+	pipe.Del(ctx, key)
+	if len(c.ForwardedPorts) == 0 {
+		return
+	}
+	fields := make(map[string]any, len(c.ForwardedPorts))
+	for _, p := range c.ForwardedPorts {
+		fields[strconv.Itoa(p.Port)] = p.Protocol
+	}
+	pipe.HSet(ctx, key, fields)
+}
+
+// ForwardedPortOf returns the declared port, nil if it is not declared
+func (c *ContainerInfo) ForwardedPortOf(port int) *ForwardedPort {
+	for i := range c.ForwardedPorts {
+		if c.ForwardedPorts[i].Port == port {
+			return &c.ForwardedPorts[i]
+		}
+	}
+	return nil
+}
+
+// ForwardedPortPath is the path the owner reaches a declared port under
+func (c *ContainerInfo) ForwardedPortPath(p ForwardedPort) string {
+	prefix := "/code-server/"
+	if NormalizeWorkspace(c.Workspace) != DefaultWorkspace {
+		prefix += c.Workspace + "/"
+	}
+	return fmt.Sprintf("%srequest/%s/%s/%d/", prefix, c.User, p.Protocol, p.Port)
+}
+
+// ForwardedPortHost is the host a declared port is reached on with subdomain routing, ok is false when the
+// user name can not be part of a host label
+func (c *ContainerInfo) ForwardedPortHost(p ForwardedPort, baseDomain string) (host string, ok bool) {
+	label := fmt.Sprintf("%d-%s-%s", p.Port, NormalizeWorkspace(c.Workspace), c.User)
+	if !ValidPortHostLabel(label) {
+		return "", false
+	}
+	return label + "." + strings.TrimPrefix(baseDomain, "."), true
+}
+
+// PortInRanges reports whether port is in one of ranges, "8501" or "8500-8599"
+func PortInRanges(port int, ranges []string) bool {
+	for _, r := range ranges {
+		from, to, isRange := strings.Cut(strings.TrimSpace(r), "-")
+		if !isRange {
+			to = from
+		}
+		lo, err1 := strconv.Atoi(strings.TrimSpace(from))
+		hi, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 == nil && err2 == nil && port >= lo && port <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// DeclarePort declares a port of a workspace of user for forwarding, a declared port keeps its visibility
+// and only gets the new label and protocol
+func (s *ContainerRegistryService) DeclarePort(ctx context.Context, user, workspace string, fp ForwardedPort) (*ForwardedPort, error) {
+	if fp.Port < 1 || fp.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", fp.Port)
+	}
+	fp.Label = strings.TrimSpace(fp.Label)
+	if len(fp.Label) > forwardedPortLabelMax {
+		return nil, fmt.Errorf("label must be at most %d characters", forwardedPortLabelMax)
+	}
+	fp.Protocol = strings.ToLower(strings.TrimSpace(fp.Protocol))
+	if fp.Protocol == "" {
+		fp.Protocol = "http"
+	}
+	if !slices.Contains(ForwardedPortProtocols, fp.Protocol) {
+		return nil, fmt.Errorf("protocol must be one of %s", strings.Join(ForwardedPortProtocols, ", "))
+	}
+	fp.DeclaredAt = time.Now().UTC().Format(time.RFC3339)
+
+	err := s.update(ctx, user, workspace, func(containerInfo *ContainerInfo) error {
+		containerInfo.ForwardedPorts = slices.DeleteFunc(containerInfo.ForwardedPorts, func(p ForwardedPort) bool {
+			return p.Port == fp.Port
+		})
+		containerInfo.ForwardedPorts = append(containerInfo.ForwardedPorts, fp)
+		slices.SortFunc(containerInfo.ForwardedPorts, func(a, b ForwardedPort) int { return a.Port - b.Port })
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("Declared port %d (%s) of %s/%s by %s", fp.Port, fp.Protocol, user, NormalizeWorkspace(workspace), fp.DeclaredBy)
+	return &fp, nil
+}
+
+// UndeclarePort stops forwarding a port of a workspace of user and drops its visibility
+func (s *ContainerRegistryService) UndeclarePort(ctx context.Context, user, workspace string, port int) (*ForwardedPort, error) {
+	var removed *ForwardedPort
+	err := s.update(ctx, user, workspace, func(containerInfo *ContainerInfo) error {
+		i := slices.IndexFunc(containerInfo.ForwardedPorts, func(p ForwardedPort) bool { return p.Port == port })
+		if i < 0 {
+			return ErrPortNotFound
+		}
+		removed = &containerInfo.ForwardedPorts[i]
+		containerInfo.ForwardedPorts = slices.Delete(slices.Clone(containerInfo.ForwardedPorts), i, i+1)
+		delete(containerInfo.PortVisibility, strconv.Itoa(port))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("Removed port %d of %s/%s", port, user, NormalizeWorkspace(workspace))
+	return removed, nil
+}
+
+// SyncForwardedPorts writes the forwarded ports hash of every workspace for the agents. On the first run the
+// workspaces without declared ports get the ports every container was forwarded on before, so they keep working.
+func (s *ContainerRegistryService) SyncForwardedPorts(ctx context.Context) error {
+	done, err := s.rdb.Exists(ctx, forwardedPortsSeededKey).Result()
+	if err != nil {
+		return err
+	}
+	seed := done == 0
+	containers, err := s.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	seeded := 0
+	for i := range containers {
+		c := &containers[i]
+		if seed && len(c.ForwardedPorts) == 0 {
+			err := s.update(ctx, c.User, c.Workspace, func(containerInfo *ContainerInfo) error {
+				if len(containerInfo.ForwardedPorts) > 0 {
+					return nil
+				}
+				now := time.Now().UTC().Format(time.RFC3339)
+				for _, port := range legacyForwardedPorts {
+					protocol := "http"
+					if port == 443 {
+						protocol = "https"
+					}
+					containerInfo.ForwardedPorts = append(containerInfo.ForwardedPorts, ForwardedPort{Port: port, Protocol: protocol, DeclaredAt: now})
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to seed forwarded ports of %s/%s: %w", c.User, c.Workspace, err)
+			}
+			seeded++
+			continue
+		}
+		_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			mirrorForwardedPorts(ctx, pipe, c.User, c)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write forwarded ports of %s/%s: %w", c.User, c.Workspace, err)
+		}
+	}
+	if seed {
+		if err := s.rdb.Set(ctx, forwardedPortsSeededKey, time.Now().UTC().Format(time.RFC3339), 0).Err(); err != nil {
+			return err
+		}
+		s.log.Info().Msgf("Declared the formerly forwarded ports for %d workspaces", seeded)
+	}
+	return nil
+}
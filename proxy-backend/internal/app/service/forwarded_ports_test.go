@@ -0,0 +1,31 @@
+package service
+
+import "testing"
+
+func TestPortInRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		port   int
+		ranges []string
+		want   bool
+	}{
+		{name: "no ranges", port: 8501, ranges: nil, want: false},
+		{name: "single port", port: 8501, ranges: []string{"8501"}, want: true},
+		{name: "other single port", port: 8502, ranges: []string{"8501"}, want: false},
+		{name: "inside range", port: 8550, ranges: []string{"8500-8599"}, want: true},
+		{name: "range start", port: 8500, ranges: []string{"8500-8599"}, want: true},
+		{name: "range end", port: 8599, ranges: []string{"8500-8599"}, want: true},
+		{name: "below range", port: 8499, ranges: []string{"8500-8599"}, want: false},
+		{name: "above range", port: 8600, ranges: []string{"8500-8599"}, want: false},
+		{name: "second entry", port: 3000, ranges: []string{"8500-8599", "3000"}, want: true},
+		{name: "spaces", port: 8501, ranges: []string{" 8500 - 8599 "}, want: true},
+		{name: "invalid entry skipped", port: 3000, ranges: []string{"abc", "80-x", "3000"}, want: true},
+		{name: "invalid entry only", port: 80, ranges: []string{"80-x"}, want: false},
+		{name: "reversed range", port: 8550, ranges: []string{"8599-8500"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := PortInRanges(tt.port, tt.ranges); got != tt.want {
+			t.Errorf("%s: PortInRanges(%d, %v) = %v, want %v", tt.name, tt.port, tt.ranges, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"v0/internal/app/xsession"
+)
+
+// IdleStopController stops containers of workspaces that have been inactive longer than the idle timeout of
+// their owner. Activity is the last proxied request (ActivityService) or any open connection into the workspace.
+type IdleStopController struct {
+	reg            *ContainerRegistryService
+	agentService   *AgentService
+	activity       *ActivityService
+	sessions       *xsession.CodeServerSessionRegistry
+	defaultTimeout time.Duration
+	groupTimeouts  map[string]time.Duration
+	log            zerolog.Logger
+}
+
+// NewIdleStopController creates the controller. Timeouts are in minutes, 0 disables stopping.
+func NewIdleStopController(
+	reg *ContainerRegistryService,
+	agentService *AgentService,
+	activity *ActivityService,
+	sessions *xsession.CodeServerSessionRegistry,
+	defaultTimeoutMinutes int,
+	groupTimeoutMinutes map[string]int,
+	log zerolog.Logger,
+) *IdleStopController {
+	groupTimeouts := make(map[string]time.Duration, len(groupTimeoutMinutes))
+	for group, minutes := range groupTimeoutMinutes {
+		groupTimeouts[group] = time.Duration(minutes) * time.Minute
+	}
+	return &IdleStopController{
+		reg:            reg,
+		agentService:   agentService,
+		activity:       activity,
+		sessions:       sessions,
+		defaultTimeout: time.Duration(defaultTimeoutMinutes) * time.Minute,
+		groupTimeouts:  groupTimeouts,
+		log:            log,
+	}
+}
+
+// Start runs Sweep on every interval until parent is done.
+func (c *IdleStopController) Start(parent context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.Sweep(parent)
+			case <-parent.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Sweep checks every registered container once and stops the idle ones.
+func (c *IdleStopController) Sweep(ctx context.Context) {
+	containers, err := c.reg.GetAll(ctx)
+	if err != nil {
+		c.log.Error().Err(err).Msg("idle stop: failed to list containers")
+		return
+	}
+	openConns := map[string]int{}
+	for _, connID := range c.sessions.ConnIDs() {
+		openConns[connWorkspace(connID)]++
+	}
+
+	for _, info := range containers {
+		id := WorkspaceID(info.User, info.Workspace)
+		if openConns[id] > 0 {
+			c.activity.Touch(ctx, id, nil)
+			continue
+		}
+
+		activity, err := c.activity.Get(ctx, id)
+		if err != nil {
+			// never seen since this controller runs, start counting from now
+			if errors.Is(err, ErrActivityNotFound) {
+				_ = c.activity.Reset(ctx, id)
+			}
+			continue
+		}
+
+		if info.StopReason == StopReasonMigrating || info.StopReason == StopReasonRecreating {
+			continue
+		}
+
+		timeout := c.timeoutFor(activity.Groups)
+		if timeout <= 0 || time.Since(activity.LastSeen) < timeout {
+			continue
+		}
+
+		// already stopped by us and nobody came back since
+		if info.StopReason == StopReasonIdle {
+			if stoppedAt, err := time.Parse(time.RFC3339, info.StoppedAt); err == nil && stoppedAt.After(activity.LastSeen) {
+				continue
+			}
+		}
+
+		running, err := c.agentService.IsContainerRunning(info.AgentHost, info.ContainerName)
+		if err != nil || !running.Running {
+			continue
+		}
+
+		if _, err := c.agentService.StopContainer(info.AgentHost, info.ContainerName); err != nil {
+			c.log.Error().Err(err).Msgf("idle stop: failed to stop %s on %s", info.ContainerName, info.AgentHost)
+			continue
+		}
+		if err := c.reg.SetStopReason(ctx, info.User, info.Workspace, StopReasonIdle); err != nil {
+			c.log.Error().Err(err).Msgf("idle stop: failed to record stop for %s", info.User)
+		}
+		c.log.Info().Msgf("idle stop: stopped %s for %s, last seen %s", info.ContainerName, id, activity.LastSeen.Format(time.RFC3339))
+	}
+}
+
+// connWorkspace returns the WorkspaceID a connection of the code-server session registry goes to. Connection IDs
+// start with the WorkspaceID, behind a marker like "shared " for connections into workspaces of other users.
+func connWorkspace(connID string) string {
+	head, _, _ := strings.Cut(connID, " | ")
+	if i := strings.LastIndexByte(head, ' '); i >= 0 {
+		head = head[i+1:]
+	}
+	return head
+}
+
+// timeoutFor returns the most generous timeout among the user's groups.
+// A group override of 0 means the user's containers are never stopped.
+func (c *IdleStopController) timeoutFor(groups []string) time.Duration {
+	timeout := c.defaultTimeout
+	overridden := false
+	for _, group := range groups {
+		groupTimeout, ok := c.groupTimeouts[group]
+		if !ok {
+			continue
+		}
+		if groupTimeout <= 0 {
+			return 0
+		}
+		if !overridden || groupTimeout > timeout {
+			timeout = groupTimeout
+			overridden = true
+		}
+	}
+	return timeout
+}
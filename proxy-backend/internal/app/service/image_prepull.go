@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// Pre-pull status values, of the whole run and of each agent/image pair
+const (
+	PrepullRunning = "running"
+	PrepullDone    = "done"
+
+	PrepullQueued  = "queued"
+	PrepullPulling = "pulling"
+	PrepullPulled  = "pulled"
+	PrepullPresent = "present"
+	PrepullFailed  = "failed"
+)
+
+const (
+	// prepullTTL is how long the result of a pre-pull run is kept
+	prepullTTL = 7 * 24 * time.Hour
+	// prepullImageTimeout bounds the pull of one image on one agent
+	prepullImageTimeout = 30 * time.Minute
+	// prepullProgressInterval limits how often layer progress is saved
+	prepullProgressInterval = 2 * time.Second
+)
+
+type PrepullResult struct {
+	Agent     string `json:"agent"`
+	Image     string `json:"image"`
+	Status    string `json:"status"`
+	Progress  int    `json:"progress"`
+	Message   string `json:"message,omitempty"`
+	Error     string `json:"error,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Prepull is a run pulling images on agents ahead of use so the first create does not wait for the pull
+type Prepull struct {
+	ID          string          `json:"id"`
+	RequestedBy string          `json:"requested_by,omitempty"`
+	Status      string          `json:"status"`
+	Images      []string        `json:"images,omitempty"`
+	Agents      []string        `json:"agents"`
+	Results     []PrepullResult `json:"results"`
+	StartedAt   string          `json:"started_at"`
+	UpdatedAt   string          `json:"updated_at"`
+	FinishedAt  string          `json:"finished_at,omitempty"`
+}
+
+// ImagePrepullService pulls images on agents in background and records a status per agent and image
+type ImagePrepullService struct {
+	rdb          *redis.Client
+	agentService *AgentService
+	log          zerolog.Logger
+}
+
+func NewImagePrepullService(rdb *redis.Client, agentService *AgentService, log zerolog.Logger) *ImagePrepullService {
+	return &ImagePrepullService{rdb, agentService, log}
+}
+
+func (s *ImagePrepullService) prepullKey(id string) string {
+	return "prepull:" + id
+}
+
+func (s *ImagePrepullService) latestKey() string {
+	return "prepull-latest"
+}
+
+// Get returns the pre-pull run with the given id
+func (s *ImagePrepullService) Get(ctx context.Context, id string) (*Prepull, error) {
+	val, err := s.rdb.Get(ctx, s.prepullKey(id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("prepull not found")
+		}
+		return nil, fmt.Errorf("failed to get prepull: %w", err)
+	}
+	var p Prepull
+	if err := json.Unmarshal([]byte(val), &p); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal prepull: %w", err)
+	}
+	return &p, nil
+}
+
+// Latest returns the last started pre-pull run
+func (s *ImagePrepullService) Latest(ctx context.Context) (*Prepull, error) {
+	id, err := s.rdb.Get(ctx, s.latestKey()).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("prepull not found")
+		}
+		return nil, fmt.Errorf("failed to get prepull: %w", err)
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *ImagePrepullService) save(ctx context.Context, p *Prepull) {
+	p.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(p)
+	if err != nil {
+		s.log.Error().Err(err).Msgf("failed to marshal prepull %s", p.ID)
+		return
+	}
+	if err := s.rdb.Set(ctx, s.prepullKey(p.ID), data, prepullTTL).Err(); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save prepull %s", p.ID)
+	}
+}
+
+// templateImages returns the distinct images of the container profiles of the agent
+func (s *ImagePrepullService) templateImages(agentURL string) ([]string, error) {
+	profiles, err := s.agentService.ListProfiles(agentURL)
+	if err != nil {
+		return nil, err
+	}
+	images := []string{}
+	for _, p := range profiles {
+		if p.Image != "" && !slices.Contains(images, p.Image) {
+			images = append(images, p.Image)
+		}
+	}
+	return images, nil
+}
+
+// Start pulls images on agents in background. Without images every agent pulls the images of its own
+// container profiles, without agents all registered agents are used.
+func (s *ImagePrepullService) Start(ctx context.Context, images, agents []string, requestedBy string) (*Prepull, error) {
+	agents, err := s.agentService.ResolveAgentURLs(ctx, agents)
+	if err != nil {
+		return nil, err
+	}
+
+	cleaned := []string{}
+	for _, image := range images {
+		image = strings.TrimSpace(image)
+		if image != "" && !slices.Contains(cleaned, image) {
+			cleaned = append(cleaned, image)
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prepull id: %w", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	p := &Prepull{
+		ID:          id,
+		RequestedBy: requestedBy,
+		Status:      PrepullRunning,
+		Images:      cleaned,
+		Agents:      agents,
+		Results:     []PrepullResult{},
+		StartedAt:   now,
+	}
+	for _, agent := range agents {
+		agentImages := cleaned
+		if len(agentImages) == 0 {
+			agentImages, err = s.templateImages(agent)
+			if err != nil {
+				p.Results = append(p.Results, PrepullResult{
+					Agent: agent, Status: PrepullFailed, UpdatedAt: now,
+					Error: fmt.Sprintf("failed to list profiles: %v", err),
+				})
+				continue
+			}
+		}
+		for _, image := range agentImages {
+			p.Results = append(p.Results, PrepullResult{Agent: agent, Image: image, Status: PrepullQueued, UpdatedAt: now})
+		}
+	}
+	if len(p.Results) == 0 {
+		return nil, fmt.Errorf("no images to pull")
+	}
+
+	s.save(ctx, p)
+	if err := s.rdb.Set(ctx, s.latestKey(), p.ID, prepullTTL).Err(); err != nil {
+		s.log.Warn().Err(err).Msgf("failed to save latest prepull %s", p.ID)
+	}
+	s.log.Info().Msgf("prepull %s started by %s on %d agents", p.ID, requestedBy, len(agents))
+
+	// the run updates p, the caller gets a snapshot
+	started := *p
+	started.Results = slices.Clone(p.Results)
+	go s.run(p)
+	return &started, nil
+}
+
+func (s *ImagePrepullService) run(p *Prepull) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	lastSave := time.Time{}
+
+	// update changes one result under mu, saves are throttled unless force is set
+	update := func(i int, force bool, change func(r *PrepullResult)) {
+		mu.Lock()
+		defer mu.Unlock()
+		change(&p.Results[i])
+		p.Results[i].UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if force || time.Since(lastSave) >= prepullProgressInterval {
+			lastSave = time.Now()
+			s.save(ctx, p)
+		}
+	}
+
+	// agents pull in parallel, images of one agent one after another
+	byAgent := map[string][]int{}
+	for i, r := range p.Results {
+		if r.Status == PrepullQueued {
+			byAgent[r.Agent] = append(byAgent[r.Agent], i)
+		}
+	}
+	var wg sync.WaitGroup
+	for agent, indexes := range byAgent {
+		wg.Add(1)
+		go func(agent string, indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				image := p.Results[i].Image
+				update(i, true, func(r *PrepullResult) { r.Status = PrepullPulling })
+
+				tracker := NewImagePullTracker()
+				pullCtx, cancel := context.WithTimeout(ctx, prepullImageTimeout)
+				// warming the cache is about getting the current image of the tag, not keeping an old one
+				result, err := s.agentService.PullImageStream(pullCtx, agent, image, true, func(ev ImagePullProgress) {
+					percent, summary := tracker.Update(ev)
+					update(i, false, func(r *PrepullResult) {
+						r.Progress = percent
+						r.Message = summary
+					})
+				})
+				cancel()
+
+				if err != nil {
+					s.log.Error().Err(err).Msgf("prepull %s: failed to pull %s on %s", p.ID, image, agent)
+					update(i, true, func(r *PrepullResult) {
+						r.Status = PrepullFailed
+						r.Error = err.Error()
+					})
+					continue
+				}
+				update(i, true, func(r *PrepullResult) {
+					r.Status = result.Status
+					r.Progress = 100
+					r.Message = ""
+				})
+			}
+		}(agent, indexes)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	p.Status = PrepullDone
+	p.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	s.save(ctx, p)
+	mu.Unlock()
+	s.log.Info().Msgf("prepull %s finished", p.ID)
+}
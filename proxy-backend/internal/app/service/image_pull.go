@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ImagePullTracker folds the per-layer pull events of one image into overall progress.
+// Downloading counts for the first half of a layer and extracting for the second half.
+type ImagePullTracker struct {
+	mu     sync.Mutex
+	layers map[string]float64 // layer id -> done fraction
+	order  []string
+}
+
+func NewImagePullTracker() *ImagePullTracker {
+	return &ImagePullTracker{layers: map[string]float64{}}
+}
+
+// Update records the event and returns the overall percentage and a short summary
+func (t *ImagePullTracker) Update(p ImagePullProgress) (percent int, summary string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.Layer != "" {
+		if _, ok := t.layers[p.Layer]; !ok {
+			t.order = append(t.order, p.Layer)
+		}
+		fraction := func() float64 {
+			if p.Total <= 0 {
+				return 0
+			}
+			return float64(p.Current) / float64(p.Total)
+		}
+		switch p.Status {
+		case "Already exists", "Pull complete":
+			t.layers[p.Layer] = 1
+		case "Downloading":
+			t.layers[p.Layer] = 0.5 * fraction()
+		case "Verifying Checksum", "Download complete":
+			t.layers[p.Layer] = 0.5
+		case "Extracting":
+			t.layers[p.Layer] = 0.5 + 0.5*fraction()
+		default:
+			if _, ok := t.layers[p.Layer]; !ok {
+				t.layers[p.Layer] = 0
+			}
+		}
+	}
+
+	if len(t.layers) == 0 {
+		return 0, p.Status
+	}
+	total, done := 0.0, 0
+	for _, id := range t.order {
+		total += t.layers[id]
+		if t.layers[id] >= 1 {
+			done++
+		}
+	}
+	percent = int(total / float64(len(t.layers)) * 100)
+	return percent, fmt.Sprintf("%d/%d layers, %d%%", done, len(t.layers), percent)
+}
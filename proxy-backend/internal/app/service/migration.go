@@ -0,0 +1,364 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// Migration status values
+const (
+	MigrationRunning    = "running"
+	MigrationSucceeded  = "succeeded"
+	MigrationFailed     = "failed"
+	MigrationRolledBack = "rolled_back"
+)
+
+const (
+	// migrationTTL is how long the progress of a finished migration is kept
+	migrationTTL = 7 * 24 * time.Hour
+	// migrationLockTTL guards against a crashed proxy-backend holding the lock forever
+	migrationLockTTL = 6 * time.Hour
+)
+
+type MigrationStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	At     string `json:"at"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Migration is the progress of moving a user's container from one agent to another
+type Migration struct {
+	User          string          `json:"user"`
+	Workspace     string          `json:"workspace"`
+	ContainerName string          `json:"container_name"`
+	Source        string          `json:"source"`
+	Target        string          `json:"target"`
+	KeepSource    bool            `json:"keep_source"`
+	SourcePorts   map[string]int  `json:"source_ports,omitempty"`
+	TargetPorts   map[string]int  `json:"target_ports,omitempty"`
+	RequestedBy   string          `json:"requested_by,omitempty"`
+	Status        string          `json:"status"`
+	Step          string          `json:"step"`
+	Error         string          `json:"error,omitempty"`
+	Warnings      []string        `json:"warnings,omitempty"`
+	Steps         []MigrationStep `json:"steps"`
+	StartedAt     string          `json:"started_at"`
+	UpdatedAt     string          `json:"updated_at"`
+	FinishedAt    string          `json:"finished_at,omitempty"`
+
+	// prevStopReason and prevStoppedAt are put back once the migration is over
+	prevStopReason string
+	prevStoppedAt  string
+}
+
+// MigrationService moves containers between agents: stop on source, recreate on target,
+// copy the workspace home through the docker archive API and switch the registry entry.
+type MigrationService struct {
+	rdb          *redis.Client
+	reg          *ContainerRegistryService
+	agentService *AgentService
+	log          zerolog.Logger
+}
+
+func NewMigrationService(rdb *redis.Client, reg *ContainerRegistryService, agentService *AgentService, log zerolog.Logger) *MigrationService {
+	return &MigrationService{rdb, reg, agentService, log}
+}
+
+func (s *MigrationService) migrationKey(id string) string {
+	return "migration:" + id
+}
+
+func (s *MigrationService) lockKey(id string) string {
+	return "migration-lock:" + id
+}
+
+// Get returns the progress of the last migration of a workspace of the user
+func (s *MigrationService) Get(ctx context.Context, user, workspace string) (*Migration, error) {
+	val, err := s.rdb.Get(ctx, s.migrationKey(WorkspaceID(user, workspace))).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("migration not found")
+		}
+		return nil, fmt.Errorf("failed to get migration: %w", err)
+	}
+	var m Migration
+	if err := json.Unmarshal([]byte(val), &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal migration: %w", err)
+	}
+	return &m, nil
+}
+
+func (s *MigrationService) save(ctx context.Context, m *Migration) {
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(m)
+	if err != nil {
+		s.log.Error().Err(err).Msgf("failed to marshal migration of %s", m.User)
+		return
+	}
+	if err := s.rdb.Set(ctx, s.migrationKey(WorkspaceID(m.User, m.Workspace)), data, migrationTTL).Err(); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save migration of %s", m.User)
+	}
+}
+
+// resolveTarget picks the target agent, "auto" or empty uses the LB selector
+func (s *MigrationService) resolveTarget(ctx context.Context, source, target string) (string, error) {
+	if target == "" || strings.ToLower(target) == "auto" {
+		selected, err := s.agentService.AgentLBSelector()
+		if err != nil {
+			return "", err
+		}
+		if selected.URL == source {
+			return "", fmt.Errorf("no other agent available, the least loaded agent is the source")
+		}
+		return selected.URL, nil
+	}
+
+	agents, err := s.agentService.RetrieveAllAgentData(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, agent := range agents {
+		if fmt.Sprintf("%s://%s", agent.MainHostProto, agent.MainHost) == target {
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("target agent %s is not registered", target)
+}
+
+// Start validates the request and runs the migration of a workspace in background
+func (s *MigrationService) Start(ctx context.Context, user, workspace, target string, keepSource bool, requestedBy string) (*Migration, error) {
+	cntInfo, err := s.reg.Get(ctx, user, workspace)
+	if err != nil {
+		return nil, err
+	}
+	target, err = s.resolveTarget(ctx, cntInfo.AgentHost, target)
+	if err != nil {
+		return nil, err
+	}
+	if cntInfo.StopReason == StopReasonRecreating {
+		return nil, fmt.Errorf("container of %s is being recreated", user)
+	}
+	if target == cntInfo.AgentHost {
+		return nil, fmt.Errorf("container of %s is already on %s", user, target)
+	}
+	if exist, err := s.agentService.IsContainerExist(target, cntInfo.ContainerName); err != nil {
+		return nil, fmt.Errorf("target agent %s is not reachable: %w", target, err)
+	} else if exist.Exist {
+		return nil, fmt.Errorf("container %s already exists on %s", cntInfo.ContainerName, target)
+	}
+
+	ok, err := s.rdb.SetNX(ctx, s.lockKey(WorkspaceID(user, cntInfo.Workspace)), target, migrationLockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock migration: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("a migration of %s is already running", user)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	m := &Migration{
+		User:          user,
+		Workspace:     cntInfo.Workspace,
+		ContainerName: cntInfo.ContainerName,
+		Source:        cntInfo.AgentHost,
+		Target:        target,
+		KeepSource:    keepSource,
+		SourcePorts:   cntInfo.Ports,
+		RequestedBy:   requestedBy,
+		Status:        MigrationRunning,
+		Steps:         []MigrationStep{},
+		StartedAt:     now,
+	}
+	// a reason left over by a crashed migration must not lock the container for good
+	if cntInfo.StopReason != StopReasonMigrating {
+		m.prevStopReason = cntInfo.StopReason
+		m.prevStoppedAt = cntInfo.StoppedAt
+	}
+	s.save(ctx, m)
+	s.log.Info().Msgf("migration of %s started: %s -> %s", user, m.Source, m.Target)
+
+	go s.run(m)
+	return m, nil
+}
+
+// step runs fn as a named step and records its result
+func (s *MigrationService) step(ctx context.Context, m *Migration, name string, fn func() error) error {
+	m.Step = name
+	m.Steps = append(m.Steps, MigrationStep{Name: name, Status: MigrationRunning, At: time.Now().UTC().Format(time.RFC3339)})
+	s.save(ctx, m)
+
+	err := fn()
+	last := &m.Steps[len(m.Steps)-1]
+	last.At = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		last.Status = MigrationFailed
+		last.Error = err.Error()
+		s.log.Error().Err(err).Msgf("migration of %s failed at %s", m.User, name)
+	} else {
+		last.Status = MigrationSucceeded
+	}
+	s.save(ctx, m)
+	return err
+}
+
+func (s *MigrationService) run(m *Migration) {
+	ctx := context.Background()
+	defer s.rdb.Del(ctx, s.lockKey(WorkspaceID(m.User, m.Workspace)))
+
+	var (
+		spec          map[string]any
+		wasRunning    bool
+		stoppedSource bool
+		createdTarget bool
+		switched      bool
+	)
+
+	err := s.step(ctx, m, "read_spec", func() error {
+		var err error
+		spec, err = s.agentService.GetContainerSpec(m.Source, m.ContainerName)
+		if err != nil {
+			return err
+		}
+		if volumes, ok := spec["volumes"].([]any); ok {
+			for _, v := range volumes {
+				if bind, _ := v.(string); strings.HasPrefix(bind, "/") {
+					m.Warnings = append(m.Warnings, fmt.Sprintf("host path %s is not migrated", strings.SplitN(bind, ":", 2)[0]))
+				}
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = s.step(ctx, m, "stop_source", func() error {
+			running, err := s.agentService.IsContainerRunning(m.Source, m.ContainerName)
+			if err != nil {
+				return err
+			}
+			wasRunning = running.Running
+			if err := s.reg.SetStopReason(ctx, m.User, m.Workspace, StopReasonMigrating); err != nil {
+				return err
+			}
+			stoppedSource = true
+			if wasRunning {
+				if _, err := s.agentService.StopContainer(m.Source, m.ContainerName); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = s.step(ctx, m, "create_target", func() error {
+			created, err := s.agentService.CreateContainer(m.Target, spec)
+			if err != nil {
+				return err
+			}
+			createdTarget = true
+			m.TargetPorts = created.Ports
+			return nil
+		})
+	}
+
+	if err == nil {
+		err = s.step(ctx, m, "transfer_state", func() error {
+			archive, err := s.agentService.ExportContainerState(ctx, m.Source, m.ContainerName)
+			if err != nil {
+				return err
+			}
+			defer archive.Close()
+			return s.agentService.ImportContainerState(ctx, m.Target, m.ContainerName, archive)
+		})
+	}
+
+	if err == nil {
+		err = s.step(ctx, m, "switch_registry", func() error {
+			if err := s.reg.SwapAgentHost(ctx, m.User, m.Workspace, m.Source, m.Target, m.TargetPorts); err != nil {
+				return err
+			}
+			switched = true
+			return nil
+		})
+	}
+
+	if err == nil && wasRunning {
+		err = s.step(ctx, m, "start_target", func() error {
+			_, err := s.agentService.StartContainer(m.Target, m.ContainerName)
+			return err
+		})
+	}
+
+	if err != nil {
+		m.Error = err.Error()
+		m.Status = s.rollback(ctx, m, wasRunning, stoppedSource, createdTarget, switched)
+		m.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		s.save(ctx, m)
+		return
+	}
+
+	// an idle or admin stop is kept, so the idle controller and the start page still see why the container is down
+	if err := s.reg.restoreStop(ctx, m.User, m.Workspace, m.prevStopReason, m.prevStoppedAt); err != nil {
+		s.log.Error().Err(err).Msgf("failed to restore stop reason for %s", m.User)
+	}
+
+	// the target is live from here, a failed cleanup is only a warning
+	if !m.KeepSource {
+		if serr := s.step(ctx, m, "remove_source", func() error {
+			_, err := s.agentService.RemoveContainer(m.Source, m.ContainerName)
+			return err
+		}); serr != nil {
+			m.Warnings = append(m.Warnings, fmt.Sprintf("source container was not removed: %v", serr))
+		}
+	}
+
+	m.Status = MigrationSucceeded
+	m.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	s.save(ctx, m)
+	s.log.Info().Msgf("migration of %s succeeded: %s -> %s", m.User, m.Source, m.Target)
+}
+
+// rollback undoes the completed steps in reverse order and returns the final status
+func (s *MigrationService) rollback(ctx context.Context, m *Migration, wasRunning, stoppedSource, createdTarget, switched bool) string {
+	status := MigrationRolledBack
+	fail := func(format string, err error) {
+		status = MigrationFailed
+		m.Warnings = append(m.Warnings, fmt.Sprintf(format, err))
+		s.log.Error().Err(err).Msgf("migration rollback of %s", m.User)
+	}
+
+	_ = s.step(ctx, m, "rollback", func() error {
+		if switched {
+			if err := s.reg.SwapAgentHost(ctx, m.User, m.Workspace, m.Target, m.Source, m.SourcePorts); err != nil {
+				fail("failed to restore registry entry: %v", err)
+			}
+		}
+		if createdTarget {
+			if _, err := s.agentService.RemoveContainer(m.Target, m.ContainerName); err != nil {
+				fail("failed to remove target container: %v", err)
+			}
+		}
+		if stoppedSource {
+			if err := s.reg.restoreStop(ctx, m.User, m.Workspace, m.prevStopReason, m.prevStoppedAt); err != nil {
+				fail("failed to restore stop reason: %v", err)
+			}
+			if wasRunning {
+				if _, err := s.agentService.StartContainer(m.Source, m.ContainerName); err != nil {
+					fail("failed to restart source container: %v", err)
+				}
+			}
+		}
+		if status != MigrationRolledBack {
+			return fmt.Errorf("rollback incomplete")
+		}
+		return nil
+	})
+	return status
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Visibility modes of a forwarded port
+const (
+	// the owner of the workspace and the users it is shared with, ports without an entry are private
+	PortPrivate = "private"
+	// also the members of an LDAP group
+	PortGroup = "group"
+	// anyone holding a signed link
+	PortPublic = "public"
+)
+
+// PortVisibility says who can reach a declared port of a workspace through /code-server/request/<user>/<protocol>/<port>
+type PortVisibility struct {
+	Mode      string `json:"mode"`
+	Group     string `json:"group,omitempty"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// PortVisibilityOf returns the visibility of a port, private if it was never changed
+func (c *ContainerInfo) PortVisibilityOf(port int) PortVisibility {
+	if v, ok := c.PortVisibility[strconv.Itoa(port)]; ok {
+		return v
+	}
+	return PortVisibility{Mode: PortPrivate}
+}
+
+// CanReachPort reports whether the user can reach the port without a public link. Public ports are
+// reached through their link, so they are not open to every logged in user either.
+func (c *ContainerInfo) CanReachPort(user string, groups []string, port int) bool {
+	if c.HasRole(user, groups, GrantRoleUse) {
+		return true
+	}
+	v := c.PortVisibilityOf(port)
+	return v.Mode == PortGroup && v.Group != "" && slices.Contains(groups, v.Group)
+}
+
+// SetPortVisibility changes who can reach a declared port of a workspace of user, private removes the entry
+func (s *ContainerRegistryService) SetPortVisibility(ctx context.Context, user, workspace string, port int, v PortVisibility) (*PortVisibility, error) {
+	v.Group = strings.TrimSpace(v.Group)
+	switch v.Mode {
+	case PortPrivate, PortPublic:
+		v.Group = ""
+	case PortGroup:
+		if v.Group == "" {
+			return nil, fmt.Errorf("group is required")
+		}
+	default:
+		return nil, fmt.Errorf("mode must be %s, %s or %s", PortPrivate, PortGroup, PortPublic)
+	}
+	// UpdatedAt versions the public links, two changes within a second must still give two versions
+	v.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	key := strconv.Itoa(port)
+	err := s.update(ctx, user, workspace, func(containerInfo *ContainerInfo) error {
+		if containerInfo.ForwardedPortOf(port) == nil {
+			return ErrPortNotFound
+		}
+		if v.Mode == PortPrivate {
+			delete(containerInfo.PortVisibility, key)
+			return nil
+		}
+		if containerInfo.PortVisibility == nil {
+			containerInfo.PortVisibility = map[string]PortVisibility{}
+		}
+		containerInfo.PortVisibility[key] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("Port %d of %s/%s is %s by %s", port, user, NormalizeWorkspace(workspace), v.Mode, v.UpdatedBy)
+	return &v, nil
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Reconcile issue kinds
+const (
+	// registry entry whose container does not exist on its agent
+	ReconcileMissingContainer = "missing_container"
+	// registry entry whose container lives on another agent
+	ReconcileWrongAgent = "wrong_agent"
+	// managed container without a registry entry
+	ReconcileOrphanContainer = "orphan_container"
+	// managed container of a user whose registry entry points to another container
+	ReconcileDuplicateContainer = "duplicate_container"
+	// registry entry on an agent that is not registered or not reachable, skipped
+	ReconcileAgentUnavailable = "agent_unavailable"
+)
+
+// orphanGracePeriod skips containers that were just created and are not registered yet
+const orphanGracePeriod = 2 * time.Minute
+
+type ReconcileIssue struct {
+	Kind          string `json:"kind"`
+	User          string `json:"user,omitempty"`
+	Workspace     string `json:"workspace,omitempty"`
+	ContainerName string `json:"container_name,omitempty"`
+	ContainerID   string `json:"container_id,omitempty"`
+	AgentHost     string `json:"agent_host,omitempty"`
+	State         string `json:"state,omitempty"`
+	Action        string `json:"action,omitempty"`
+	Repaired      bool   `json:"repaired"`
+	Error         string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	StartedAt         string           `json:"started_at"`
+	FinishedAt        string           `json:"finished_at"`
+	DryRun            bool             `json:"dry_run"`
+	Agents            []string         `json:"agents"`
+	UnreachableAgents []string         `json:"unreachable_agents"`
+	Registered        int              `json:"registered"`
+	Containers        int              `json:"containers"`
+	Issues            []ReconcileIssue `json:"issues"`
+}
+
+type agentContainer struct {
+	agentURL  string
+	container ManagedContainer
+}
+
+// reconcileStep is an issue with the registry change that repairs it, applied unless dry run
+type reconcileStep struct {
+	issue ReconcileIssue
+	// entry to write
+	add *ContainerInfo
+	// remove the entry of issue.User and issue.Workspace
+	remove bool
+}
+
+// recreateLeftover reports a container the agent moved aside while recreating, it is removed or renamed back when the recreate ends
+func recreateLeftover(name string) bool {
+	return strings.Contains(name, "-recreate-")
+}
+
+// Reconciler compares the container registry with the managed containers on every agent.
+// Repairs only touch the registry, containers on agents are never removed.
+type Reconciler struct {
+	reg          *ContainerRegistryService
+	agentService *AgentService
+	log          zerolog.Logger
+	mu           sync.Mutex
+	last         *ReconcileReport
+}
+
+func NewReconciler(reg *ContainerRegistryService, agentService *AgentService, log zerolog.Logger) *Reconciler {
+	return &Reconciler{reg: reg, agentService: agentService, log: log}
+}
+
+// Start runs Reconcile on every interval until parent is done.
+func (r *Reconciler) Start(parent context.Context, interval time.Duration, dryRun bool) {
+	t := time.NewTicker(interval)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if _, err := r.Reconcile(parent, dryRun); err != nil {
+					r.log.Error().Err(err).Msg("reconcile failed")
+				}
+			case <-parent.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Last returns the report of the last run, nil if it never ran
+func (r *Reconciler) Last() *ReconcileReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Reconcile computes the diff between registry and agents and repairs it unless dryRun.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		StartedAt:         time.Now().UTC().Format(time.RFC3339),
+		DryRun:            dryRun,
+		Agents:            []string{},
+		UnreachableAgents: []string{},
+		Issues:            []ReconcileIssue{},
+	}
+
+	agents, err := r.agentService.RetrieveAllAgentData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// managed containers by name, per reachable agent
+	reachable := map[string]bool{}
+	byName := map[string][]agentContainer{}
+	for _, agent := range agents {
+		agentURL := fmt.Sprintf("%s://%s", agent.MainHostProto, agent.MainHost)
+		containers, err := r.agentService.ListManagedContainers(agentURL)
+		if err != nil {
+			r.log.Warn().Err(err).Msgf("reconcile: skipping unreachable agent %s", agentURL)
+			report.UnreachableAgents = append(report.UnreachableAgents, agentURL)
+			continue
+		}
+		reachable[agentURL] = true
+		report.Agents = append(report.Agents, agentURL)
+		for _, c := range containers {
+			byName[c.Name()] = append(byName[c.Name()], agentContainer{agentURL, c})
+			report.Containers++
+		}
+	}
+
+	entries, err := r.reg.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Registered = len(entries)
+
+	for _, step := range reconcileDiff(entries, byName, reachable) {
+		issue := step.issue
+		if !dryRun {
+			switch {
+			case step.add != nil:
+				r.repair(&issue, r.reg.Add(ctx, step.add))
+			case step.remove:
+				r.repair(&issue, r.reg.Remove(ctx, issue.User, issue.Workspace))
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	report.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	if len(report.Issues) > 0 {
+		r.log.Warn().Msgf("reconcile: %d issue(s) found (dry run: %t)", len(report.Issues), dryRun)
+	}
+
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report, nil
+}
+
+func (r *Reconciler) repair(issue *ReconcileIssue, err error) {
+	if err != nil {
+		issue.Error = err.Error()
+		r.log.Error().Err(err).Msgf("reconcile: failed to repair %s of %s", issue.Kind, issue.ContainerName)
+		return
+	}
+	issue.Repaired = true
+	r.log.Info().Msgf("reconcile: repaired %s of %s (%s)", issue.Kind, issue.ContainerName, issue.Action)
+}
+
+// reconcileDiff compares the registry entries with the managed containers by name on the reachable agents
+func reconcileDiff(entries []ContainerInfo, byName map[string][]agentContainer, reachable map[string]bool) []reconcileStep {
+	steps := []reconcileStep{}
+
+	// registry -> agents
+	claimed := map[string]bool{}                // agentURL + "|" + container name
+	registeredWorkspaces := map[string]string{} // WorkspaceID -> container name
+	for i := range entries {
+		entry := &entries[i]
+		registeredWorkspaces[WorkspaceID(entry.User, entry.Workspace)] = entry.ContainerName
+
+		found := byName[entry.ContainerName]
+		// a migration or recreate moves the container and repairs the entry itself when it ends
+		if entry.StopReason == StopReasonMigrating || entry.StopReason == StopReasonRecreating {
+			for _, ac := range found {
+				claimed[ac.agentURL+"|"+entry.ContainerName] = true
+			}
+			continue
+		}
+
+		onAgent := false
+		for _, ac := range found {
+			if ac.agentURL == entry.AgentHost {
+				onAgent = true
+				claimed[ac.agentURL+"|"+entry.ContainerName] = true
+			}
+		}
+		if onAgent {
+			continue
+		}
+
+		step := reconcileStep{issue: ReconcileIssue{User: entry.User, Workspace: entry.Workspace, ContainerName: entry.ContainerName, AgentHost: entry.AgentHost}}
+		issue := &step.issue
+		switch {
+		case len(found) == 1:
+			// moved or re-registered under another address
+			issue.Kind = ReconcileWrongAgent
+			issue.ContainerID = found[0].container.ID
+			issue.State = found[0].container.State
+			issue.Action = fmt.Sprintf("set agent host to %s", found[0].agentURL)
+			claimed[found[0].agentURL+"|"+entry.ContainerName] = true
+			moved := *entry
+			moved.AgentHost = found[0].agentURL
+			step.add = &moved
+		case len(found) > 1:
+			// ambiguous, leave it to an admin
+			issue.Kind = ReconcileWrongAgent
+			issue.Action = "none, container exists on multiple agents"
+			for _, ac := range found {
+				claimed[ac.agentURL+"|"+entry.ContainerName] = true
+			}
+		case !reachable[entry.AgentHost]:
+			issue.Kind = ReconcileAgentUnavailable
+			issue.Action = "none"
+		default:
+			issue.Kind = ReconcileMissingContainer
+			issue.Action = "remove registry entry"
+			delete(registeredWorkspaces, WorkspaceID(entry.User, entry.Workspace))
+			step.remove = true
+		}
+		steps = append(steps, step)
+	}
+
+	// agents -> registry
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if recreateLeftover(name) {
+			continue
+		}
+		for _, ac := range byName[name] {
+			if claimed[ac.agentURL+"|"+name] {
+				continue
+			}
+			c := ac.container
+			if time.Since(time.Unix(c.Created, 0)) < orphanGracePeriod {
+				continue
+			}
+			workspace := NormalizeWorkspace(c.Workspace)
+			step := reconcileStep{issue: ReconcileIssue{
+				Kind:          ReconcileOrphanContainer,
+				User:          c.Owner,
+				Workspace:     workspace,
+				ContainerName: name,
+				ContainerID:   c.ID,
+				AgentHost:     ac.agentURL,
+				State:         c.State,
+			}}
+			issue := &step.issue
+			_, registered := registeredWorkspaces[WorkspaceID(c.Owner, workspace)]
+			switch {
+			case c.Owner == "":
+				issue.Action = "none, container has no owner label"
+			case registered:
+				issue.Kind = ReconcileDuplicateContainer
+				issue.Action = "none, remove the container manually if unused"
+			default:
+				issue.Action = "add registry entry"
+				registeredWorkspaces[WorkspaceID(c.Owner, workspace)] = name
+				step.add = &ContainerInfo{
+					User:          c.Owner,
+					Workspace:     workspace,
+					ContainerName: name,
+					AgentHost:     ac.agentURL,
+					Profile:       c.Profile,
+					CreatedAt:     time.Unix(c.Created, 0).UTC().Format(time.RFC3339),
+				}
+			}
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func managedContainer(id, name, owner, workspace string) ManagedContainer {
+	return ManagedContainer{
+		ID:        id,
+		Names:     []string{"/" + name},
+		State:     "exited",
+		Created:   time.Now().Add(-time.Hour).Unix(),
+		Owner:     owner,
+		Workspace: workspace,
+	}
+}
+
+func TestReconcileDiffSkipsRecreate(t *testing.T) {
+	agent := "http://agent-1"
+	entries := []ContainerInfo{{User: "alice", Workspace: DefaultWorkspace, ContainerName: "code-server-alice", AgentHost: agent, StopReason: StopReasonRecreating}}
+	// the old container is moved aside and the new one is not created yet
+	byName := map[string][]agentContainer{
+		"code-server-alice-recreate-1700000000": {{agent, managedContainer("old", "code-server-alice-recreate-1700000000", "alice", "")}},
+	}
+
+	if steps := reconcileDiff(entries, byName, map[string]bool{agent: true}); len(steps) != 0 {
+		t.Fatalf("reconcileDiff() = %+v, want no issues during a recreate", steps)
+	}
+
+	// a leftover of a finished recreate is neither an orphan nor a duplicate
+	entries[0].StopReason = ""
+	byName["code-server-alice"] = []agentContainer{{agent, managedContainer("new", "code-server-alice", "alice", "")}}
+	if steps := reconcileDiff(entries, byName, map[string]bool{agent: true}); len(steps) != 0 {
+		t.Fatalf("reconcileDiff() = %+v, want no issues for a recreate leftover", steps)
+	}
+}
+
+func TestReconcileDiffSkipsMigration(t *testing.T) {
+	source, target := "http://agent-1", "http://agent-2"
+	entries := []ContainerInfo{{User: "alice", Workspace: "dev", ContainerName: "code-server-alice--dev", AgentHost: source, StopReason: StopReasonMigrating}}
+	reachable := map[string]bool{source: true, target: true}
+
+	// the container is already on the target and not yet removed from the source
+	byName := map[string][]agentContainer{
+		"code-server-alice--dev": {
+			{source, managedContainer("a", "code-server-alice--dev", "alice", "dev")},
+			{target, managedContainer("b", "code-server-alice--dev", "alice", "dev")},
+		},
+	}
+	if steps := reconcileDiff(entries, byName, reachable); len(steps) != 0 {
+		t.Fatalf("reconcileDiff() = %+v, want no issues during a migration", steps)
+	}
+
+	// the source container is removed before the entry is switched to the target
+	byName["code-server-alice--dev"] = byName["code-server-alice--dev"][1:]
+	if steps := reconcileDiff(entries, byName, reachable); len(steps) != 0 {
+		t.Fatalf("reconcileDiff() = %+v, want no issues during a migration", steps)
+	}
+}
+
+func TestReconcileDiffRepairs(t *testing.T) {
+	agent := "http://agent-1"
+	entries := []ContainerInfo{
+		{User: "alice", Workspace: DefaultWorkspace, ContainerName: "code-server-alice", AgentHost: agent},
+		{User: "bob", Workspace: DefaultWorkspace, ContainerName: "code-server-bob", AgentHost: agent},
+	}
+	byName := map[string][]agentContainer{
+		"code-server-alice": {{agent, managedContainer("a", "code-server-alice", "alice", "")}},
+		"code-server-carol": {{agent, managedContainer("c", "code-server-carol", "carol", "")}},
+	}
+
+	steps := reconcileDiff(entries, byName, map[string]bool{agent: true})
+	if len(steps) != 2 {
+		t.Fatalf("reconcileDiff() = %+v, want 2 issues", steps)
+	}
+	if steps[0].issue.Kind != ReconcileMissingContainer || steps[0].issue.User != "bob" || !steps[0].remove {
+		t.Errorf("steps[0] = %+v, want removal of bob's entry", steps[0])
+	}
+	if steps[1].issue.Kind != ReconcileOrphanContainer || steps[1].add == nil || steps[1].add.User != "carol" {
+		t.Errorf("steps[1] = %+v, want registration of carol's container", steps[1])
+	}
+}
@@ -0,0 +1,393 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+// Recreate status values, of one container and of a bulk run
+const (
+	RecreateQueued    = "queued"
+	RecreateRunning   = "running"
+	RecreateSucceeded = "succeeded"
+	RecreateFailed    = "failed"
+	RecreateDone      = "done"
+)
+
+const (
+	// recreateTTL is how long the result of a recreate is kept
+	recreateTTL = 7 * 24 * time.Hour
+	// recreateLockTTL guards against a crashed proxy-backend holding the lock forever
+	recreateLockTTL = 2 * time.Hour
+	// recreateTimeout bounds the pull and swap of one container
+	recreateTimeout = time.Hour
+)
+
+// Recreate is the progress of replacing a user's container with one running a new image
+type Recreate struct {
+	User          string         `json:"user"`
+	Workspace     string         `json:"workspace"`
+	ContainerName string         `json:"container_name"`
+	AgentHost     string         `json:"agent_host"`
+	OldImage      string         `json:"old_image,omitempty"`
+	Image         string         `json:"image"`
+	Ports         map[string]int `json:"ports,omitempty"`
+	StateCopied   bool           `json:"state_copied"`
+	RequestedBy   string         `json:"requested_by,omitempty"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	StartedAt     string         `json:"started_at"`
+	UpdatedAt     string         `json:"updated_at"`
+	FinishedAt    string         `json:"finished_at,omitempty"`
+
+	// prevStopReason and prevStoppedAt are put back once the recreate is over
+	prevStopReason string
+	prevStoppedAt  string
+}
+
+type RecreateBulkResult struct {
+	User          string `json:"user"`
+	Workspace     string `json:"workspace"`
+	ContainerName string `json:"container_name"`
+	AgentHost     string `json:"agent_host"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// RecreateBulk is a run recreating the containers of every user of an image, one after another
+type RecreateBulk struct {
+	ID          string               `json:"id"`
+	FromImage   string               `json:"from_image"`
+	ToImage     string               `json:"to_image"`
+	RequestedBy string               `json:"requested_by,omitempty"`
+	Status      string               `json:"status"`
+	Results     []RecreateBulkResult `json:"results"`
+	StartedAt   string               `json:"started_at"`
+	UpdatedAt   string               `json:"updated_at"`
+	FinishedAt  string               `json:"finished_at,omitempty"`
+}
+
+// RecreateService upgrades containers to a new image. The agent keeps env, binds, limits, labels and
+// network of the container, copies the workspace home and rolls back if the new container does not start.
+type RecreateService struct {
+	rdb          *redis.Client
+	reg          *ContainerRegistryService
+	agentService *AgentService
+	log          zerolog.Logger
+}
+
+func NewRecreateService(rdb *redis.Client, reg *ContainerRegistryService, agentService *AgentService, log zerolog.Logger) *RecreateService {
+	return &RecreateService{rdb, reg, agentService, log}
+}
+
+func (s *RecreateService) recreateKey(id string) string {
+	return "recreate:" + id
+}
+
+func (s *RecreateService) lockKey(id string) string {
+	return "recreate-lock:" + id
+}
+
+func (s *RecreateService) bulkKey(id string) string {
+	return "recreate-bulk:" + id
+}
+
+func (s *RecreateService) latestBulkKey() string {
+	return "recreate-bulk-latest"
+}
+
+// Get returns the progress of the last recreate of a workspace of the user
+func (s *RecreateService) Get(ctx context.Context, user, workspace string) (*Recreate, error) {
+	val, err := s.rdb.Get(ctx, s.recreateKey(WorkspaceID(user, workspace))).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("recreate not found")
+		}
+		return nil, fmt.Errorf("failed to get recreate: %w", err)
+	}
+	var r Recreate
+	if err := json.Unmarshal([]byte(val), &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recreate: %w", err)
+	}
+	return &r, nil
+}
+
+func (s *RecreateService) save(ctx context.Context, r *Recreate) {
+	r.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(r)
+	if err != nil {
+		s.log.Error().Err(err).Msgf("failed to marshal recreate of %s", r.User)
+		return
+	}
+	if err := s.rdb.Set(ctx, s.recreateKey(WorkspaceID(r.User, r.Workspace)), data, recreateTTL).Err(); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save recreate of %s", r.User)
+	}
+}
+
+// GetBulk returns the bulk run with the given id, "latest" returns the last started one
+func (s *RecreateService) GetBulk(ctx context.Context, id string) (*RecreateBulk, error) {
+	if id == "latest" {
+		latest, err := s.rdb.Get(ctx, s.latestBulkKey()).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return nil, fmt.Errorf("recreate not found")
+			}
+			return nil, fmt.Errorf("failed to get recreate: %w", err)
+		}
+		id = latest
+	}
+	val, err := s.rdb.Get(ctx, s.bulkKey(id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("recreate not found")
+		}
+		return nil, fmt.Errorf("failed to get recreate: %w", err)
+	}
+	var b RecreateBulk
+	if err := json.Unmarshal([]byte(val), &b); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal recreate: %w", err)
+	}
+	return &b, nil
+}
+
+func (s *RecreateService) saveBulk(ctx context.Context, b *RecreateBulk) {
+	b.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(b)
+	if err != nil {
+		s.log.Error().Err(err).Msgf("failed to marshal recreate %s", b.ID)
+		return
+	}
+	if err := s.rdb.Set(ctx, s.bulkKey(b.ID), data, recreateTTL).Err(); err != nil {
+		s.log.Error().Err(err).Msgf("failed to save recreate %s", b.ID)
+	}
+}
+
+// prepare takes the recreate lock of the workspace, keeps the container from being started and records a running recreate
+func (s *RecreateService) prepare(ctx context.Context, user, workspace, image, requestedBy string) (*Recreate, error) {
+	cntInfo, err := s.reg.Get(ctx, user, workspace)
+	if err != nil {
+		return nil, err
+	}
+	id := WorkspaceID(user, cntInfo.Workspace)
+	if cntInfo.StopReason == StopReasonMigrating {
+		return nil, fmt.Errorf("container of %s is being migrated", id)
+	}
+
+	image = strings.TrimSpace(image)
+	if image == "" {
+		// the image of the container template, a profile may have been moved to a new image since
+		defaults, err := s.agentService.GetContainerDefaults(cntInfo.AgentHost, cntInfo.Profile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get template image: %w", err)
+		}
+		image = defaults.Image
+	}
+
+	ok, err := s.rdb.SetNX(ctx, s.lockKey(id), image, recreateLockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock recreate: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("a recreate of %s is already running", id)
+	}
+	if err := s.reg.SetStopReason(ctx, user, cntInfo.Workspace, StopReasonRecreating); err != nil {
+		s.rdb.Del(ctx, s.lockKey(id))
+		return nil, err
+	}
+
+	r := &Recreate{
+		User:          user,
+		Workspace:     cntInfo.Workspace,
+		ContainerName: cntInfo.ContainerName,
+		AgentHost:     cntInfo.AgentHost,
+		Image:         image,
+		RequestedBy:   requestedBy,
+		Status:        RecreateRunning,
+		StartedAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	// a reason left over by a crashed recreate must not lock the container for good
+	if cntInfo.StopReason != StopReasonRecreating {
+		r.prevStopReason = cntInfo.StopReason
+		r.prevStoppedAt = cntInfo.StoppedAt
+	}
+	s.save(ctx, r)
+	return r, nil
+}
+
+// Start recreates the container of a workspace with image in background. Empty image uses the current
+// image of the container template, so users get the latest published environment.
+func (s *RecreateService) Start(ctx context.Context, user, workspace, image, requestedBy string) (*Recreate, error) {
+	r, err := s.prepare(ctx, user, workspace, image, requestedBy)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("recreate of %s started by %s: %s", WorkspaceID(r.User, r.Workspace), requestedBy, r.Image)
+
+	// the run updates r, the caller gets a snapshot
+	started := *r
+	go s.run(r)
+	return &started, nil
+}
+
+func (s *RecreateService) run(r *Recreate) {
+	ctx := context.Background()
+	defer s.rdb.Del(ctx, s.lockKey(WorkspaceID(r.User, r.Workspace)))
+
+	recreateCtx, cancel := context.WithTimeout(ctx, recreateTimeout)
+	resp, err := s.agentService.RecreateContainer(recreateCtx, r.AgentHost, r.ContainerName, r.Image)
+	cancel()
+
+	// the agent restored the old container on failure, it may be used again either way. An idle or
+	// admin stop is kept, so the idle controller and the start page still see why the container is down.
+	if cerr := s.reg.restoreStop(ctx, r.User, r.Workspace, r.prevStopReason, r.prevStoppedAt); cerr != nil {
+		s.log.Error().Err(cerr).Msgf("failed to restore stop reason for %s", r.User)
+	}
+	if err == nil {
+		r.OldImage = resp.OldImage
+		r.Ports = resp.Ports
+		r.StateCopied = resp.StateCopied
+		if len(resp.Ports) > 0 {
+			err = s.updatePorts(ctx, r.User, r.Workspace, resp.Ports)
+		}
+	}
+	s.finish(ctx, r, err)
+}
+
+// updatePorts records the host ports of the new container, the agent keeps the reservations of the old one
+func (s *RecreateService) updatePorts(ctx context.Context, user, workspace string, ports map[string]int) error {
+	return s.reg.update(ctx, user, workspace, func(c *ContainerInfo) error {
+		c.Ports = ports
+		return nil
+	})
+}
+
+func (s *RecreateService) finish(ctx context.Context, r *Recreate, err error) {
+	r.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		r.Status = RecreateFailed
+		r.Error = err.Error()
+		s.log.Error().Err(err).Msgf("recreate of %s with %s failed", WorkspaceID(r.User, r.Workspace), r.Image)
+	} else {
+		r.Status = RecreateSucceeded
+		s.log.Info().Msgf("recreate of %s succeeded: %s -> %s", WorkspaceID(r.User, r.Workspace), r.OldImage, r.Image)
+	}
+	s.save(ctx, r)
+}
+
+// StartBulk recreates in background the containers of all users running fromImage with toImage,
+// empty toImage re-pulls fromImage. Containers are matched by the ID fromImage resolves to on their agent,
+// or by the image reference they were created with when the tag has moved on since.
+func (s *RecreateService) StartBulk(ctx context.Context, fromImage, toImage, requestedBy string) (*RecreateBulk, error) {
+	fromImage = strings.TrimSpace(fromImage)
+	toImage = strings.TrimSpace(toImage)
+	if fromImage == "" {
+		return nil, fmt.Errorf("from_image is required")
+	}
+	if toImage == "" {
+		toImage = fromImage
+	}
+
+	registered, err := s.reg.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byAgent := map[string][]ContainerInfo{}
+	for _, info := range registered {
+		byAgent[info.AgentHost] = append(byAgent[info.AgentHost], info)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recreate id: %w", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	b := &RecreateBulk{
+		ID:          id,
+		FromImage:   fromImage,
+		ToImage:     toImage,
+		RequestedBy: requestedBy,
+		Status:      RecreateRunning,
+		Results:     []RecreateBulkResult{},
+		StartedAt:   now,
+	}
+	for agent, infos := range byAgent {
+		containers, err := s.agentService.ListManagedContainers(agent)
+		if err != nil {
+			s.log.Warn().Err(err).Msgf("recreate %s: failed to list containers of %s", id, agent)
+			continue
+		}
+		fromID, err := s.agentService.ImageID(agent, fromImage)
+		if err != nil {
+			s.log.Warn().Err(err).Msgf("recreate %s: failed to resolve %s on %s", id, fromImage, agent)
+		}
+		for _, info := range infos {
+			for i := range containers {
+				if containers[i].Name() != info.ContainerName {
+					continue
+				}
+				if (fromID != "" && containers[i].ImageID == fromID) || containers[i].Image == fromImage {
+					b.Results = append(b.Results, RecreateBulkResult{
+						User: info.User, Workspace: info.Workspace, ContainerName: info.ContainerName, AgentHost: agent,
+						Status: RecreateQueued, UpdatedAt: now,
+					})
+				}
+			}
+		}
+	}
+	if len(b.Results) == 0 {
+		return nil, fmt.Errorf("no containers use %s", fromImage)
+	}
+	slices.SortFunc(b.Results, func(a, b RecreateBulkResult) int {
+		return strings.Compare(WorkspaceID(a.User, a.Workspace), WorkspaceID(b.User, b.Workspace))
+	})
+
+	s.saveBulk(ctx, b)
+	if err := s.rdb.Set(ctx, s.latestBulkKey(), b.ID, recreateTTL).Err(); err != nil {
+		s.log.Warn().Err(err).Msgf("failed to save latest recreate %s", b.ID)
+	}
+	s.log.Info().Msgf("recreate %s started by %s: %d containers %s -> %s", b.ID, requestedBy, len(b.Results), fromImage, toImage)
+
+	started := *b
+	started.Results = slices.Clone(b.Results)
+	go s.runBulk(b)
+	return &started, nil
+}
+
+// runBulk recreates one container after another so an agent never pulls and copies for many at once
+func (s *RecreateService) runBulk(b *RecreateBulk) {
+	ctx := context.Background()
+	for i := range b.Results {
+		res := &b.Results[i]
+		res.Status = RecreateRunning
+		res.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		s.saveBulk(ctx, b)
+
+		r, err := s.prepare(ctx, res.User, res.Workspace, b.ToImage, b.RequestedBy)
+		if err == nil {
+			s.run(r)
+			if r.Status == RecreateFailed {
+				err = fmt.Errorf("%s", r.Error)
+			}
+		}
+		res.Status = RecreateSucceeded
+		if err != nil {
+			res.Status = RecreateFailed
+			res.Error = err.Error()
+		}
+		res.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		s.saveBulk(ctx, b)
+	}
+
+	b.Status = RecreateDone
+	b.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	s.saveBulk(ctx, b)
+	s.log.Info().Msgf("recreate %s finished", b.ID)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// RegistryAgentResult is the outcome of a registry credentials call on one agent
+type RegistryAgentResult struct {
+	Agent      string   `json:"agent"`
+	Registries []string `json:"registries"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// RegistryAuthService pushes private registry credentials to agents, which apply them without a restart.
+// Only registry hosts are logged or returned, never the credentials.
+type RegistryAuthService struct {
+	agentService *AgentService
+	file         string
+	log          zerolog.Logger
+}
+
+func NewRegistryAuthService(agentService *AgentService, file string, log zerolog.Logger) *RegistryAuthService {
+	return &RegistryAuthService{agentService, file, log}
+}
+
+// loadFile reads the credentials of REGISTRY_AUTH_FILE
+func (s *RegistryAuthService) loadFile() (map[string]RegistryCredential, error) {
+	if s.file == "" {
+		return nil, fmt.Errorf("no registry credentials given and REGISTRY_AUTH_FILE is not set")
+	}
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry credentials: %w", err)
+	}
+	creds := map[string]RegistryCredential{}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		// the decoder error may quote the file, do not pass it on
+		return nil, fmt.Errorf("invalid registry credentials in %s", s.file)
+	}
+	return creds, nil
+}
+
+func registryHosts(creds map[string]RegistryCredential) []string {
+	hosts := make([]string, 0, len(creds))
+	for host := range creds {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// forAgents runs call on every agent in parallel and returns the results in agent order
+func (s *RegistryAuthService) forAgents(agents []string, call func(agent string) (*RegistryCredentialsResponse, error)) []RegistryAgentResult {
+	results := make([]RegistryAgentResult, len(agents))
+	var wg sync.WaitGroup
+	for i, agent := range agents {
+		wg.Add(1)
+		go func(i int, agent string) {
+			defer wg.Done()
+			results[i] = RegistryAgentResult{Agent: agent, Registries: []string{}}
+			resp, err := call(agent)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Registries = resp.Registries
+		}(i, agent)
+	}
+	wg.Wait()
+	return results
+}
+
+// List returns the registry hosts each agent has credentials for
+func (s *RegistryAuthService) List(ctx context.Context, agents []string) ([]RegistryAgentResult, error) {
+	agents, err := s.agentService.ResolveAgentURLs(ctx, agents)
+	if err != nil {
+		return nil, err
+	}
+	return s.forAgents(agents, s.agentService.ListRegistryCredentials), nil
+}
+
+// Push replaces the registry credentials of the agents, no creds pushes the content of REGISTRY_AUTH_FILE
+func (s *RegistryAuthService) Push(ctx context.Context, creds map[string]RegistryCredential, agents []string, requestedBy string) ([]RegistryAgentResult, error) {
+	if len(creds) == 0 {
+		var err error
+		if creds, err = s.loadFile(); err != nil {
+			return nil, err
+		}
+	}
+	agents, err := s.agentService.ResolveAgentURLs(ctx, agents)
+	if err != nil {
+		return nil, err
+	}
+
+	results := s.forAgents(agents, func(agent string) (*RegistryCredentialsResponse, error) {
+		return s.agentService.PushRegistryCredentials(agent, creds)
+	})
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+			s.log.Error().Msgf("failed to push registry credentials to %s: %s", r.Agent, r.Error)
+		}
+	}
+	s.log.Info().Msgf("registry credentials for %s pushed by %s to %d agents, %d failed",
+		strings.Join(registryHosts(creds), ", "), requestedBy, len(agents), failed)
+	return results, nil
+}
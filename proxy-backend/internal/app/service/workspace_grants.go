@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Roles on a workspace, each one includes the ones before it
+const (
+	// open the workspace in code-server and reach its forwarded ports
+	GrantRoleUse = "use"
+	// also start, stop and restart it
+	GrantRoleManage = "manage"
+	// the user the workspace belongs to, it can not be granted
+	GrantRoleOwner = "owner"
+)
+
+var grantRoles = []string{GrantRoleUse, GrantRoleManage, GrantRoleOwner}
+
+// ErrGrantNotFound is returned when a workspace has no grant for the user or group.
+var ErrGrantNotFound = errors.New("grant not found")
+
+// WorkspaceGrant gives a user or the members of an LDAP group a role on a workspace of another user
+type WorkspaceGrant struct {
+	User      string `json:"user,omitempty"`
+	Group     string `json:"group,omitempty"`
+	Role      string `json:"role"`
+	GrantedBy string `json:"granted_by,omitempty"`
+	GrantedAt string `json:"granted_at"`
+}
+
+// Subject is the grantee as shown to users, groups are prefixed with @
+func (g WorkspaceGrant) Subject() string {
+	if g.Group != "" {
+		return "@" + g.Group
+	}
+	return g.User
+}
+
+func (g WorkspaceGrant) sameSubject(user, group string) bool {
+	return g.User == user && g.Group == group
+}
+
+// RoleOf returns the highest role the user has on the workspace, empty if none
+func (c *ContainerInfo) RoleOf(user string, groups []string) string {
+	if user == c.User {
+		return GrantRoleOwner
+	}
+	best := -1
+	for _, g := range c.Grants {
+		if (g.User != "" && g.User == user) || (g.Group != "" && slices.Contains(groups, g.Group)) {
+			best = max(best, slices.Index(grantRoles, g.Role))
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return grantRoles[best]
+}
+
+// HasRole reports whether the user has at least role on the workspace
+func (c *ContainerInfo) HasRole(user string, groups []string, role string) bool {
+	have := c.RoleOf(user, groups)
+	return have != "" && slices.Index(grantRoles, have) >= slices.Index(grantRoles, role)
+}
+
+// Grant gives a user or a group a role on a workspace of owner, an existing grant of the same grantee is replaced
+func (s *ContainerRegistryService) Grant(ctx context.Context, owner, workspace string, grant WorkspaceGrant) (*WorkspaceGrant, error) {
+	grant.User = strings.TrimSpace(grant.User)
+	grant.Group = strings.TrimSpace(grant.Group)
+	if (grant.User == "") == (grant.Group == "") {
+		return nil, fmt.Errorf("either user or group is required")
+	}
+	if grant.User == owner {
+		return nil, fmt.Errorf("%s owns the workspace", owner)
+	}
+	if grant.Role != GrantRoleUse && grant.Role != GrantRoleManage {
+		return nil, fmt.Errorf("role must be %s or %s", GrantRoleUse, GrantRoleManage)
+	}
+	grant.GrantedAt = time.Now().UTC().Format(time.RFC3339)
+
+	err := s.update(ctx, owner, workspace, func(containerInfo *ContainerInfo) error {
+		containerInfo.Grants = slices.DeleteFunc(containerInfo.Grants, func(g WorkspaceGrant) bool {
+			return g.sameSubject(grant.User, grant.Group)
+		})
+		containerInfo.Grants = append(containerInfo.Grants, grant)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("Granted %s on %s/%s to %s by %s", grant.Role, owner, NormalizeWorkspace(workspace), grant.Subject(), grant.GrantedBy)
+	return &grant, nil
+}
+
+// Revoke removes the grant of a user or a group from a workspace of owner and returns it
+func (s *ContainerRegistryService) Revoke(ctx context.Context, owner, workspace, user, group string) (*WorkspaceGrant, error) {
+	user, group = strings.TrimSpace(user), strings.TrimSpace(group)
+	var revoked *WorkspaceGrant
+	err := s.update(ctx, owner, workspace, func(containerInfo *ContainerInfo) error {
+		i := slices.IndexFunc(containerInfo.Grants, func(g WorkspaceGrant) bool {
+			return g.sameSubject(user, group)
+		})
+		if i < 0 {
+			return ErrGrantNotFound
+		}
+		revoked = &containerInfo.Grants[i]
+		containerInfo.Grants = slices.Delete(slices.Clone(containerInfo.Grants), i, i+1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info().Msgf("Revoked %s on %s/%s from %s", revoked.Role, owner, NormalizeWorkspace(workspace), revoked.Subject())
+	return revoked, nil
+}
+
+// SharedWith returns the workspaces of other users the user has a grant on, directly or by one of the groups
+func (s *ContainerRegistryService) SharedWith(ctx context.Context, user string, groups []string) ([]ContainerInfo, error) {
+	containers, err := s.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shared := []ContainerInfo{}
+	for _, c := range containers {
+		if c.User != user && c.RoleOf(user, groups) != "" {
+			shared = append(shared, c)
+		}
+	}
+	return shared, nil
+}
+
+// update changes a workspace entry with change, it is retried if the entry changes meanwhile
+func (s *ContainerRegistryService) update(ctx context.Context, user, workspace string, change func(*ContainerInfo) error) error {
+	workspacesKey := s.workspacesKey(user)
+	workspace = NormalizeWorkspace(workspace)
+	txf := func(tx *redis.Tx) error {
+		val, err := tx.HGet(ctx, workspacesKey, workspace).Result()
+		if err != nil {
+			if err == redis.Nil {
+				return ErrContainerNotFound
+			}
+			return fmt.Errorf("failed to get container info: %w", err)
+		}
+		var containerInfo ContainerInfo
+		if err := json.Unmarshal([]byte(val), &containerInfo); err != nil {
+			return fmt.Errorf("failed to unmarshal container info: %w", err)
+		}
+		if err := change(&containerInfo); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&containerInfo)
+		if err != nil {
+			return fmt.Errorf("failed to marshal container info: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, workspacesKey, workspace, data)
+			mirrorForwardedPorts(ctx, pipe, user, &containerInfo)
+			return nil
+		})
+		return err
+	}
+	for range 3 {
+		err := s.rdb.Watch(ctx, txf, workspacesKey)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("container info of %s/%s kept changing", user, workspace)
+}
@@ -112,6 +112,35 @@ func (r *CodeServerSessionRegistry) CancelConn(sessionID, connID string) bool {
 	return true
 }
 
+// CancelMatching cancels the connections of all sessions match selects and returns how many were cancelled
+func (r *CodeServerSessionRegistry) CancelMatching(match func(sessionID, connID string) bool) int {
+	r.mu.Lock()
+	var cancels []connEntry
+	var transports []*http.Transport
+	for sid, m := range r.cancels {
+		for cid, e := range m {
+			if match(sid, cid) {
+				cancels = append(cancels, e)
+				delete(m, cid)
+			}
+		}
+		if len(m) == 0 {
+			delete(r.cancels, sid)
+			transports = append(transports, r.transports[sid]...)
+			delete(r.transports, sid)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, e := range cancels {
+		e.cancel()
+	}
+	for _, tr := range transports {
+		tr.CloseIdleConnections()
+	}
+	return len(cancels)
+}
+
 func (r *CodeServerSessionRegistry) ListConns(sessionID string) []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -135,6 +164,19 @@ func (r *CodeServerSessionRegistry) ListSessions() map[string]int {
 	return out
 }
 
+// ConnIDs returns the IDs of the open connections of all sessions
+func (r *CodeServerSessionRegistry) ConnIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for _, m := range r.cancels {
+		for connID := range m {
+			ids = append(ids, connID)
+		}
+	}
+	return ids
+}
+
 func (r *CodeServerSessionRegistry) CloseIdle(sessionID string) bool {
 	r.mu.Lock()
 	transports, ok := r.transports[sessionID]
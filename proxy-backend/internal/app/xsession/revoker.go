@@ -17,11 +17,12 @@ type Revoker struct {
 	sessionDriver *RedisSessionManager
 	log           zerolog.Logger
 	sessionCookie string
+	cookieDomain  string
 }
 
-func NewRevoker(s *RedisSessionManager, log zerolog.Logger, sessionCookie string) *Revoker {
+func NewRevoker(s *RedisSessionManager, log zerolog.Logger, sessionCookie, cookieDomain string) *Revoker {
 	data := make(map[string]map[string]connEntry)
-	return &Revoker{logoutAfter: data, sessionDriver: s, log: log, sessionCookie: sessionCookie}
+	return &Revoker{logoutAfter: data, sessionDriver: s, log: log, sessionCookie: sessionCookie, cookieDomain: cookieDomain}
 }
 
 func (r *Revoker) AddRevokeUser(userID string, entry map[string]connEntry) {
@@ -63,7 +64,7 @@ func (r *Revoker) ConsumeIfRevokedWithLoggedIn(c echo.Context, withTLS bool, isL
 			return "", "", []string{}, false, err
 		}
 
-		security.ExpireSessionCookie(c, withTLS, r.sessionCookie)
+		security.ExpireSessionCookie(c, withTLS, r.sessionCookie, r.cookieDomain)
 		for _, v := range r.logoutAfter[username] {
 			v.cancel()
 		}
@@ -19,6 +19,13 @@ type AppConfig struct {
 	redisInsightConfig  `mapstructure:",squash"`
 	containerEditConfig `mapstructure:",squash"`
 	pamConfig           `mapstructure:",squash"`
+
+	containerLifecycleConfig `mapstructure:",squash"`
+	fileTransferConfig       `mapstructure:",squash"`
+	reconcilerConfig         `mapstructure:",squash"`
+	registryAuthConfig       `mapstructure:",squash"`
+	workspaceConfig          `mapstructure:",squash"`
+	portConfig               `mapstructure:",squash"`
 }
 
 // GlobalAppConfig represents the application configuration
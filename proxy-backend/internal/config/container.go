@@ -14,4 +14,14 @@ type containerEditConfig struct {
 	ContainerAllowEditRestart    bool `mapstructure:"CONTAINER_ALLOW_EDIT_RESTART"`
 	ContainerAllowEditSysctls    bool `mapstructure:"CONTAINER_ALLOW_EDIT_SYSCTLS"`
 	ContainerAllowEditVolumes    bool `mapstructure:"CONTAINER_ALLOW_EDIT_VOLUMES"`
+
+	ContainerAllowEditPidsLimit         bool `mapstructure:"CONTAINER_ALLOW_EDIT_PIDS_LIMIT"`
+	ContainerAllowEditUlimits           bool `mapstructure:"CONTAINER_ALLOW_EDIT_ULIMITS"`
+	ContainerAllowEditShmSize           bool `mapstructure:"CONTAINER_ALLOW_EDIT_SHM_SIZE"`
+	ContainerAllowEditMemorySwap        bool `mapstructure:"CONTAINER_ALLOW_EDIT_MEMORY_SWAP"`
+	ContainerAllowEditMemoryReservation bool `mapstructure:"CONTAINER_ALLOW_EDIT_MEMORY_RESERVATION"`
+	ContainerAllowEditCPUShares         bool `mapstructure:"CONTAINER_ALLOW_EDIT_CPU_SHARES"`
+	ContainerAllowEditCpuset            bool `mapstructure:"CONTAINER_ALLOW_EDIT_CPUSET"`
+	ContainerAllowEditStorageSize       bool `mapstructure:"CONTAINER_ALLOW_EDIT_STORAGE_SIZE"`
+	ContainerAllowEditTmpfs             bool `mapstructure:"CONTAINER_ALLOW_EDIT_TMPFS"`
 }
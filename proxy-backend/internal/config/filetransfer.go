@@ -0,0 +1,6 @@
+package config
+
+// fileTransferConfig holds the configuration for container file upload/download.
+type fileTransferConfig struct {
+	ContainerFileUploadMaxMB int `mapstructure:"CONTAINER_FILE_UPLOAD_MAX_MB"`
+}
@@ -0,0 +1,11 @@
+package config
+
+// containerLifecycleConfig holds the configuration for the container idle stop and wake on request.
+// CONTAINER_IDLE_GROUP_TIMEOUTS format: "group1:240,group2:0" (minutes, 0 = never stop)
+type containerLifecycleConfig struct {
+	ContainerIdleStopEnabled          bool   `mapstructure:"CONTAINER_IDLE_STOP_ENABLED"`
+	ContainerIdleTimeoutMinutes       int    `mapstructure:"CONTAINER_IDLE_TIMEOUT_MINUTES"`
+	ContainerIdleGroupTimeouts        string `mapstructure:"CONTAINER_IDLE_GROUP_TIMEOUTS"`
+	ContainerIdleCheckIntervalSeconds int    `mapstructure:"CONTAINER_IDLE_CHECK_INTERVAL_SECONDS"`
+	ContainerWakeOnRequest            bool   `mapstructure:"CONTAINER_WAKE_ON_REQUEST"`
+}
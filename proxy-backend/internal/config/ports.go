@@ -0,0 +1,17 @@
+package config
+
+// portConfig holds the configuration for forwarded ports.
+// CONTAINER_FORWARD_PORT_GROUP_RANGES format: "group1:8501-8599,group1:8888,group2:1024-65535", ports the members of
+// a group can declare on top of the ones of the container template.
+// Without PORT_LINK_SECRET ports cannot be made public, PORT_LINK_TTL_HOURS 0 means 168 (one week).
+// With PORT_SUBDOMAIN_BASE_DOMAIN ports are also reached on <port>-<workspace>-<user>.<base domain>, the session cookie
+// is then scoped to PORT_SUBDOMAIN_COOKIE_DOMAIN (default the base domain) and logins go to PORT_SUBDOMAIN_LOGIN_URL
+// (default <scheme>://<base domain>/auth/login).
+type portConfig struct {
+	ContainerForwardPortGroupRanges string `mapstructure:"CONTAINER_FORWARD_PORT_GROUP_RANGES"`
+	PortLinkSecret                  string `mapstructure:"PORT_LINK_SECRET"`
+	PortLinkTTLHours                int    `mapstructure:"PORT_LINK_TTL_HOURS"`
+	PortSubdomainBaseDomain         string `mapstructure:"PORT_SUBDOMAIN_BASE_DOMAIN"`
+	PortSubdomainCookieDomain       string `mapstructure:"PORT_SUBDOMAIN_COOKIE_DOMAIN"`
+	PortSubdomainLoginURL           string `mapstructure:"PORT_SUBDOMAIN_LOGIN_URL"`
+}
@@ -0,0 +1,9 @@
+package config
+
+// reconcilerConfig holds the configuration for the registry/agent reconciliation loop.
+// With CONTAINER_RECONCILE_REPAIR=false the loop only reports drift (dry-run).
+type reconcilerConfig struct {
+	ContainerReconcileEnabled         bool `mapstructure:"CONTAINER_RECONCILE_ENABLED"`
+	ContainerReconcileIntervalSeconds int  `mapstructure:"CONTAINER_RECONCILE_INTERVAL_SECONDS"`
+	ContainerReconcileRepair          bool `mapstructure:"CONTAINER_RECONCILE_REPAIR"`
+}
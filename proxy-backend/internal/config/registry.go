@@ -0,0 +1,7 @@
+package config
+
+// registryAuthConfig holds the private registry credentials pushed to agents.
+// The file is JSON {"registry.local:5000": {"username": "...", "password": "..."}}.
+type registryAuthConfig struct {
+	RegistryAuthFile string `mapstructure:"REGISTRY_AUTH_FILE"`
+}
@@ -0,0 +1,8 @@
+package config
+
+// workspaceConfig holds the configuration for the number of workspaces a user can keep.
+// CONTAINER_WORKSPACE_GROUP_LIMITS format: "group1:5,group2:2", the highest limit among the user's groups applies
+type workspaceConfig struct {
+	ContainerWorkspaceLimit       int    `mapstructure:"CONTAINER_WORKSPACE_LIMIT"`
+	ContainerWorkspaceGroupLimits string `mapstructure:"CONTAINER_WORKSPACE_GROUP_LIMITS"`
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"strconv"
 	"strings"
 )
 
@@ -45,6 +46,43 @@ func ParseToList(s string) []string {
 	return []string{strings.TrimSpace(s)}
 }
 
+// ParseToIntMap parses "key1:1,key2:2" into a map. Malformed pairs are skipped.
+func ParseToIntMap(s string) map[string]int {
+	out := make(map[string]int)
+	if strings.TrimSpace(s) == "" {
+		return out
+	}
+	for _, pair := range ParseToList(s) {
+		k, v, ok := strings.Cut(pair, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		out[strings.TrimSpace(k)] = n
+	}
+	return out
+}
+
+// ParseToListMap parses "key1:a,key1:b,key2:c" into a map of lists. Malformed pairs are skipped.
+func ParseToListMap(s string) map[string][]string {
+	out := make(map[string][]string)
+	if strings.TrimSpace(s) == "" {
+		return out
+	}
+	for _, pair := range ParseToList(s) {
+		k, v, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(v) == "" {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		out[k] = append(out[k], strings.TrimSpace(v))
+	}
+	return out
+}
+
 // NewEncKey32FromSecret returns a 32-byte AES key.
 // Accepts Base64 (std/raw) or plain string; always derives 32 bytes via SHA-256.
 func NewEncKey32FromSecret(secret string) []byte {